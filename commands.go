@@ -0,0 +1,465 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"mr/mapreduce"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// runCmd runs a job in this process
+func runCmd(fs *flag.FlagSet, args []string) int {
+	job := addJobFlags(fs)
+	parallel := fs.Int("parallel", 1, "Number of tasks to run at once, 0 for the number of CPUs")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	spec, err := job.spec("inputs")
+	if err != nil {
+		return usageError(fs, "%v", err)
+	}
+	if *parallel < 0 {
+		return usageError(fs, "-parallel cannot be negative")
+	}
+	runner := mapreduce.SequentialRunner
+	if *parallel != 1 {
+		runner = mapreduce.LocalParallel(*parallel)
+	}
+	if err := spec.Run(runner, job.sandbox.options()...); err != nil {
+		return failure("job %s failed: %v", spec.Name, err)
+	}
+	fmt.Printf("Result in %s\n", mapreduce.AnsName(spec.Name))
+	if spec.OutputFormat != "" {
+		fmt.Printf("Exported to %s\n", mapreduce.ExportName(spec.Name, spec.OutputFormat))
+	}
+	return exitOK
+}
+
+// planCmd prints the plan of a job. The exit code is 1 when problems were
+// found
+func planCmd(fs *flag.FlagSet, args []string) int {
+	job := addJobFlags(fs)
+	asJSON := fs.Bool("json", false, "Print the plan in JSON")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	spec, err := job.spec("inputs")
+	if err != nil {
+		return usageError(fs, "%v", err)
+	}
+	plan, err := spec.Plan(job.sandbox.options()...)
+	if err != nil {
+		return usageError(fs, "%v", err)
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(plan)
+	} else {
+		printPlan(plan)
+	}
+	if len(plan.Warnings) > 0 {
+		return exitFailure
+	}
+	return exitOK
+}
+
+// printPlan prints a job plan for humans
+func printPlan(plan *mapreduce.JobPlan) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Job:\t%s\n", plan.Job)
+	fmt.Fprintf(w, "Map tasks:\t%d (%s stored, %s sampled)\n", len(plan.MapTasks), formatBytes(plan.InputBytes), formatBytes(plan.SampledBytes))
+	fmt.Fprintf(w, "Reduce tasks:\t%d\n", plan.ReduceTasks)
+	fmt.Fprintf(w, "Estimated input:\t%s\n", formatBytes(plan.EstimatedInputBytes))
+	if plan.ReduceTasks > 0 {
+		fmt.Fprintf(w, "Estimated intermediate:\t%s\n", formatBytes(plan.EstimatedIntermediateBytes))
+	}
+	fmt.Fprintf(w, "Estimated output:\t%d records, %s\n", plan.EstimatedOutputRecords, formatBytes(plan.EstimatedOutputBytes))
+	w.Flush()
+
+	fmt.Println("\nMap tasks:")
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, task := range plan.MapTasks {
+		sampled := ""
+		if task.Sampled {
+			sampled = "sampled"
+		}
+		fmt.Fprintf(w, "  %d\t%s\t%s\t%s\n", task.Task, task.File, formatBytes(task.Bytes), sampled)
+	}
+	w.Flush()
+	if len(plan.Partitions) > 0 {
+		fmt.Println("\nReduce tasks:")
+		w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for i, size := range plan.Partitions {
+			fmt.Fprintf(w, "  %d\t%s\n", i, formatBytes(size))
+		}
+		w.Flush()
+	}
+	if len(plan.Warnings) > 0 {
+		fmt.Println("\nWarnings:")
+		for _, warning := range plan.Warnings {
+			fmt.Printf("  - %s\n", warning)
+		}
+	}
+}
+
+// formatBytes formats a size in bytes with a binary unit, e.g. "1.5 MiB"
+func formatBytes(n int64) string {
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	size, unit := float64(n)/1024, 0
+	for size >= 1024 && unit < 3 {
+		size /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f %ciB", size, "KMGT"[unit])
+}
+
+// masterCmd starts a master, and a first job when inputs are given
+func masterCmd(fs *flag.FlagSet, args []string) int {
+	rpcAddr := fs.String("rpcAddr", mapreduce.DefaultRPCAddr, "Address to accept workers on, port 0 for any free port")
+	dashboardAddr := fs.String("dashboardAddr", mapreduce.DefaultDashboardAddr, "Address of the dashboard and API, port 0 for any free port")
+	nWorkers := fs.Int("nWorkers", 0, "Number of workers to start in this process")
+	job := addJobFlags(fs)
+	tlsFlags := addTLSFlags(fs)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *nWorkers < 0 {
+		return usageError(fs, "-nWorkers cannot be negative")
+	}
+	var spec mapreduce.JobSpec
+	if job.given() {
+		var err error
+		if spec, err = job.spec(); err != nil {
+			return usageError(fs, "%v", err)
+		}
+	}
+	tlsConfig, err := tlsFlags.config()
+	if err != nil {
+		return usageError(fs, "invalid TLS settings: %v", err)
+	}
+	clusterOpts := clusterOptions(tlsConfig, job.sandbox)
+
+	// The control API is enabled by an admin token, read from the
+	// environment like the cluster token
+	m := mapreduce.NewMaster(append(clusterOpts,
+		mapreduce.WithRPCAddr(*rpcAddr),
+		mapreduce.WithDashboardAddr(*dashboardAddr),
+		mapreduce.WithAdminToken(os.Getenv("MAPREDUCE_ADMIN_TOKEN")))...)
+	if err := m.Serve(); err != nil {
+		return failure("master failed to start: %v", err)
+	}
+	if *nWorkers > 0 {
+		fmt.Printf("Starting %d worker(s)\n", *nWorkers)
+		mapreduce.RunWorkers(connectAddr(m.RPCAddr()), *nWorkers, mapreduce.MapWordCount, mapreduce.ReduceWordCount, clusterOpts...)
+	}
+	if spec.Name != "" {
+		if err := m.StartJob(spec); err != nil {
+			return failure("cannot start job %s: %v", spec.Name, err)
+		}
+	}
+	select {}
+}
+
+// workerCmd starts workers pulling tasks from a master
+func workerCmd(fs *flag.FlagSet, args []string) int {
+	masterAddr := fs.String("master", "localhost:1234", "RPC address of the master, or comma-separated addresses tried in turn")
+	id := fs.String("id", mapreduce.DefaultWorkerID(), "ID of the workers, unique in the cluster; the workers add their number to it")
+	reconnectTimeout := fs.Duration("reconnectTimeout", mapreduce.DefaultReconnectTimeout,
+		"How long to try to reach a master before giving up, 0 for ever")
+	nWorkers := fs.Int("nWorkers", 1, "Number of workers to start")
+	mapSlots := fs.Int("mapSlots", 0, "Number of map tasks a worker runs at once")
+	reduceSlots := fs.Int("reduceSlots", 0, "Number of reduce tasks a worker runs at once")
+	faults := fs.String("faults", "", "Faults to inject for testing, e.g. 'seed=1,crash-before=0.1,delay=0.2'")
+	metricsAddr := fs.String("metricsAddr", "", "Address to serve the worker metrics at, e.g. ':9100'")
+	sandbox := addSandboxFlags(fs)
+	tlsFlags := addTLSFlags(fs)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() > 0 {
+		return usageError(fs, "unexpected arguments %v", fs.Args())
+	}
+	if *id == "" {
+		return usageError(fs, "-id cannot be empty")
+	}
+	if *nWorkers <= 0 {
+		return usageError(fs, "-nWorkers must be positive")
+	}
+	if *mapSlots < 0 || *reduceSlots < 0 {
+		return usageError(fs, "-mapSlots and -reduceSlots cannot be negative")
+	}
+	injected, err := mapreduce.ParseFaults(*faults)
+	if err != nil {
+		return usageError(fs, "invalid -faults: %v", err)
+	}
+	tlsConfig, err := tlsFlags.config()
+	if err != nil {
+		return usageError(fs, "invalid TLS settings: %v", err)
+	}
+
+	addrs := strings.Split(*masterAddr, ",")
+	opts := append(clusterOptions(tlsConfig, sandbox),
+		mapreduce.WithMasterAddrs(addrs[1:]...), mapreduce.WithReconnectTimeout(*reconnectTimeout))
+	if *mapSlots > 0 || *reduceSlots > 0 {
+		opts = append(opts, mapreduce.WithSlots(*mapSlots, *reduceSlots))
+	}
+	if injected != (mapreduce.Faults{}) {
+		opts = append(opts, mapreduce.WithFaults(injected))
+	}
+
+	// Run until all the workers exit, failing if one of them gave up
+	fmt.Printf("Starting %d worker(s) for master %s\n", *nWorkers, *masterAddr)
+	errs := make(chan error)
+	for i := range *nWorkers {
+		w := mapreduce.NewWorker(fmt.Sprintf("%s-%d", *id, i), addrs[0],
+			mapreduce.MapWordCount, mapreduce.ReduceWordCount, opts...)
+		go func() { errs <- w.Start() }()
+	}
+	metricsErr := make(chan error)
+	if *metricsAddr != "" {
+		fmt.Printf("Worker metrics at: http://%s/metrics\n", *metricsAddr)
+		go func() { metricsErr <- mapreduce.ServeWorkerMetrics(*metricsAddr) }()
+	}
+	code := 0
+	for range *nWorkers {
+		select {
+		case err := <-errs:
+			if err != nil {
+				code = failure("%v", err)
+			}
+		case err := <-metricsErr:
+			return failure("worker metrics failed: %v", err)
+		}
+	}
+	return code
+}
+
+// clientFlags are the flags of the commands calling the API of a master
+type clientFlags struct {
+	url *string
+	tls *tlsFlags
+}
+
+func addClientFlags(fs *flag.FlagSet) *clientFlags {
+	return &clientFlags{
+		url: fs.String("url", "http://localhost:8080", "URL of the dashboard of the master"),
+		tls: addTLSFlags(fs),
+	}
+}
+
+// client returns a client of the master. The admin token is read from the
+// environment, like the master does
+func (f *clientFlags) client() (*mapreduce.Client, error) {
+	tlsConfig, err := f.tls.config()
+	if err != nil {
+		return nil, fmt.Errorf("invalid TLS settings: %v", err)
+	}
+	return mapreduce.NewClient(*f.url, os.Getenv("MAPREDUCE_ADMIN_TOKEN"), tlsConfig), nil
+}
+
+// submitCmd submits a job to a master
+func submitCmd(fs *flag.FlagSet, args []string) int {
+	clientFlags := addClientFlags(fs)
+	job := addJobFlags(fs)
+	wait := fs.Bool("wait", false, "Wait for the job to end; the exit code tells whether it completed")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	spec, err := job.spec()
+	if err != nil {
+		return usageError(fs, "%v", err)
+	}
+	if job.sandbox.given() {
+		return usageError(fs, "-storage and -inputRoots are set on the master")
+	}
+	client, err := clientFlags.client()
+	if err != nil {
+		return usageError(fs, "%v", err)
+	}
+	if err := client.Submit(spec); err != nil {
+		return failure("cannot submit job %s: %v", spec.Name, err)
+	}
+	fmt.Printf("Job %s submitted\n", spec.Name)
+	if !*wait {
+		return exitOK
+	}
+
+	for {
+		status, err := client.Job(spec.Name)
+		if err != nil {
+			return failure("cannot get the status of job %s: %v", spec.Name, err)
+		}
+		switch status.Status {
+		case mapreduce.JobRunning, mapreduce.JobMerging:
+			time.Sleep(time.Second)
+			continue
+		case mapreduce.JobCompleted:
+			fmt.Printf("Job %s completed, result in %s\n", spec.Name, status.Result)
+			return exitOK
+		}
+		return failure("job %s %s %s", spec.Name, status.Status, status.Error)
+	}
+}
+
+// statusCmd shows the status of the jobs of a master
+func statusCmd(fs *flag.FlagSet, args []string) int {
+	clientFlags := addClientFlags(fs)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() > 1 {
+		return usageError(fs, "too many arguments")
+	}
+	client, err := clientFlags.client()
+	if err != nil {
+		return usageError(fs, "%v", err)
+	}
+
+	if fs.NArg() == 0 {
+		jobs, err := client.Jobs()
+		if err != nil {
+			return failure("cannot list jobs: %v", err)
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "JOB\tSTATUS\tTASKS\tRESULT")
+		for _, job := range jobs {
+			fmt.Fprintf(w, "%s\t%s\t%d/%d\t%s\n", job.Name, job.Status, job.Completed, job.Total, job.Result)
+		}
+		w.Flush()
+		return exitOK
+	}
+
+	job, err := client.Job(fs.Arg(0))
+	if err != nil {
+		return failure("cannot get the status of job %s: %v", fs.Arg(0), err)
+	}
+	var tasks []string
+	for _, status := range sortedKeys(job.Tasks) {
+		tasks = append(tasks, fmt.Sprintf("%s %d", status, job.Tasks[status]))
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Job:\t%s\n", job.Name)
+	fmt.Fprintf(w, "Status:\t%s\n", job.Status)
+	fmt.Fprintf(w, "Tasks:\t%d/%d completed (%s)\n", job.Completed, job.Total, strings.Join(tasks, ", "))
+	if job.Result != "" {
+		fmt.Fprintf(w, "Result:\t%s\n", job.Result)
+	}
+	if job.Error != "" {
+		fmt.Fprintf(w, "Error:\t%s\n", job.Error)
+	}
+	if len(job.Counters) > 0 {
+		fmt.Fprintln(w, "Counters:")
+		for _, name := range sortedKeys(job.Counters) {
+			fmt.Fprintf(w, "  %s\t%d\n", name, job.Counters[name])
+		}
+	}
+	w.Flush()
+	return exitOK
+}
+
+// cancelCmd cancels a job
+func cancelCmd(fs *flag.FlagSet, args []string) int {
+	clientFlags := addClientFlags(fs)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 1 {
+		return usageError(fs, "expected a job name")
+	}
+	client, err := clientFlags.client()
+	if err != nil {
+		return usageError(fs, "%v", err)
+	}
+	if err := client.Cancel(fs.Arg(0)); err != nil {
+		return failure("cannot cancel job %s: %v", fs.Arg(0), err)
+	}
+	fmt.Printf("Job %s cancelled\n", fs.Arg(0))
+	return exitOK
+}
+
+// logsCmd prints the events of a job
+func logsCmd(fs *flag.FlagSet, args []string) int {
+	clientFlags := addClientFlags(fs)
+	follow := fs.Bool("follow", false, "Keep printing the events until the job ends")
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if fs.NArg() != 1 {
+		return usageError(fs, "expected a job name")
+	}
+	client, err := clientFlags.client()
+	if err != nil {
+		return usageError(fs, "%v", err)
+	}
+
+	jobName, next := fs.Arg(0), 0
+	for {
+		events, err := client.Events(jobName, next)
+		if err != nil {
+			return failure("cannot get the events of job %s: %v", jobName, err)
+		}
+		if events.First > next {
+			fmt.Printf("(%d events dropped by the master, see the history of the job)\n", events.First-next)
+		}
+		for _, e := range events.Events {
+			fmt.Println(formatEvent(e))
+		}
+		next = events.Next
+		if !*follow || events.Status != mapreduce.JobRunning && events.Status != mapreduce.JobMerging {
+			return exitOK
+		}
+		time.Sleep(time.Second)
+	}
+}
+
+// formatEvent returns a line describing e
+func formatEvent(e mapreduce.Event) string {
+	line := e.Time.Format("2006-01-02 15:04:05.000") + " " + e.Type
+	if e.Task != nil {
+		line += fmt.Sprintf(" task=%d type=%s file=%s attempt=%d", e.Task.ID, e.Task.Type, e.Task.File, e.Task.Attempt)
+	}
+	if e.Worker != "" {
+		line += " worker=" + e.Worker
+	}
+	if e.Error != "" {
+		line += fmt.Sprintf(" error=%q", e.Error)
+	}
+	return line
+}
+
+// historyCmd serves the histories of past jobs, from the storage of the
+// master that ran them
+func historyCmd(fs *flag.FlagSet, args []string) int {
+	addr := fs.String("addr", ":8080", "Address to serve the job histories at")
+	dir := fs.String("historyDir", mapreduce.DefaultHistoryDir, "Directory of the job histories")
+	sandbox := addSandboxFlags(fs)
+	if code, ok := parseFlags(fs, args); !ok {
+		return code
+	}
+	if *sandbox.inputRoots != "" {
+		return usageError(fs, "-inputRoots only applies to jobs")
+	}
+	if err := mapreduce.ServeHistory(*addr, sandbox.store(), *dir); err != nil {
+		return failure("history server failed: %v", err)
+	}
+	return exitOK
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
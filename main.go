@@ -1,92 +1,288 @@
 package main
 
 import (
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
 	"mr/mapreduce"
+	"net"
 	"os"
 	"strings"
 )
 
+// Exit codes of the commands
+const (
+	exitOK      = 0
+	exitFailure = 1 // the command or the job failed
+	exitUsage   = 2 // invalid command line
+)
+
+// command is a subcommand of mr
+type command struct {
+	name    string
+	args    string // synopsis of the arguments after the flags
+	summary string
+	run     func(fs *flag.FlagSet, args []string) int
+}
+
+var commands = []command{
+	{"run", "[inputs...]", "Run a job in this process", runCmd},
+	{"plan", "[inputs...]", "Show the tasks of a job and estimate its sizes, without running it", planCmd},
+	{"master", "[inputs...]", "Start a master, running a first job on inputs if any", masterCmd},
+	{"worker", "", "Start workers pulling tasks from a master", workerCmd},
+	{"submit", "[inputs...]", "Submit a job to a master, described by its inputs or -spec", submitCmd},
+	{"status", "[job]", "Show the status of the jobs of a master, or of one of them", statusCmd},
+	{"cancel", "job", "Cancel a job", cancelCmd},
+	{"logs", "job", "Show the events of a job", logsCmd},
+	{"history", "", "Serve the histories of past jobs", historyCmd},
+}
+
+// apps are the jobs the commands can run, by name
+var apps = map[string]mapreduce.App{
+	"wordcount": {Map: mapreduce.MapWordCount, Reduce: mapreduce.ReduceWordCount},
+}
+
+func init() {
+	// Workers run the tasks of the jobs submitted with -app
+	for name, app := range apps {
+		mapreduce.RegisterApp(name, app)
+	}
+}
+
 func main() {
-	// Define flags
-	mode := flag.String("mode", "", "Mode to run: 'master' or 'worker'")
-	files := "inputs/file1.txt,inputs/file2.txt"
-	nReduce := flag.Int("nReduce", 3, "Number of reduce tasks")
-	masterAddr := "localhost:1234"
-	nWorkers := flag.Int("nWorkers", 1, "Number of workers to launch (only used in master mode)")
-	jobName := "wordcount"
-
-	// Parse flags
-	flag.Parse()
-	flag.VisitAll(func(f *flag.Flag) {
-		fmt.Printf("Flag: -%s = %s\n", f.Name, f.Value)
-	})
-
-	// Validate mode
-	if *mode != "master" && *mode != "worker" {
-		fmt.Println("Invalid mode. Use -mode=master or -mode=worker")
-		flag.Usage()
-		os.Exit(1)
-	}
-
-	// Validate common flags
-	if *nReduce <= 0 {
-		fmt.Println("Error: -nReduce must be a positive integer")
-		flag.Usage()
-		os.Exit(1)
-	}
-	if jobName == "" {
-		fmt.Println("Error: -job must not be empty")
-		flag.Usage()
-		os.Exit(1)
-	}
-
-	switch *mode {
-	case "master":
-		// Validate master-specific flags
-		if files == "" {
-			fmt.Println("Error: You must provide input files with -files")
-			flag.Usage()
-			os.Exit(1)
+	os.Exit(run(os.Args[1:]))
+}
+
+// run runs the command named by the first argument, returning the exit code
+func run(args []string) int {
+	if len(args) < 1 {
+		usage()
+		return exitUsage
+	}
+	name := args[0]
+	if name == "help" || name == "-h" || name == "-help" || name == "--help" {
+		usage()
+		return exitOK
+	}
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd.parse(args[1:])
 		}
+	}
+	fmt.Fprintf(os.Stderr, "mr: unknown command %q\n", name)
+	usage()
+	return exitUsage
+}
 
-		// Clean and validate input files
-		inputFiles := strings.Split(files, ",")
-		var cleanedFiles []string
-		for _, f := range inputFiles {
-			f = strings.TrimSpace(f)
-			if f != "" {
-				cleanedFiles = append(cleanedFiles, f)
-			}
+// usage prints the list of the commands
+func usage() {
+	fmt.Fprintln(os.Stderr, "Usage: mr <command> [flags] [arguments]\n\nCommands:")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintln(os.Stderr, "\nRun 'mr <command> -h' for the flags of a command.")
+}
+
+// parse runs the command with its flag set, after checking the flags are
+// valid
+func (cmd command) parse(args []string) int {
+	fs := flag.NewFlagSet(cmd.name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: mr %s [flags] %s\n\n%s.\n\nFlags:\n", cmd.name, cmd.args, cmd.summary)
+		fs.PrintDefaults()
+	}
+	return cmd.run(fs, args)
+}
+
+// parseFlags parses the flags of fs, returning the exit code to use when
+// the command must stop
+func parseFlags(fs *flag.FlagSet, args []string) (int, bool) {
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		return exitOK, false
+	}
+	if err != nil {
+		return exitUsage, false
+	}
+	return exitOK, true
+}
+
+// usageError reports an invalid command line
+func usageError(fs *flag.FlagSet, format string, args ...any) int {
+	fmt.Fprintf(fs.Output(), "Error: "+format+"\n", args...)
+	fs.Usage()
+	return exitUsage
+}
+
+// failure reports an error of a command
+func failure(format string, args ...any) int {
+	fmt.Fprintf(os.Stderr, "Error: "+format+"\n", args...)
+	return exitFailure
+}
+
+// jobFlags are the flags describing a job
+type jobFlags struct {
+	fs      *flag.FlagSet
+	file    *string
+	name    *string
+	nReduce *int
+	app     *string
+	include *string
+	exclude *string
+	sandbox *sandboxFlags
+}
+
+func addJobFlags(fs *flag.FlagSet) *jobFlags {
+	return &jobFlags{
+		fs:      fs,
+		file:    fs.String("spec", "", "Job spec file, in YAML or JSON; the flags set override its fields"),
+		name:    fs.String("job", "wordcount", "Name of the job"),
+		nReduce: fs.Int("nReduce", 3, "Number of reduce tasks (0 for a map-only job)"),
+		app:     fs.String("app", "wordcount", "Registered app the job runs"),
+		include: fs.String("include", "", "Comma-separated patterns of input files to keep (e.g. '*.txt,*.gz')"),
+		exclude: fs.String("exclude", "", "Comma-separated patterns of input files to skip"),
+		sandbox: addSandboxFlags(fs),
+	}
+}
+
+// sandboxFlags are the flags setting the files jobs can read
+type sandboxFlags struct {
+	storage    *string
+	inputRoots *string
+}
+
+func addSandboxFlags(fs *flag.FlagSet) *sandboxFlags {
+	return &sandboxFlags{
+		storage:    fs.String("storage", "", "Directory holding the inputs and the files of the jobs (default the working directory)"),
+		inputRoots: fs.String("inputRoots", "", "Comma-separated directories of the storage inputs must be in; workers refuse the others"),
+	}
+}
+
+// given reports whether the flags were set
+func (f *sandboxFlags) given() bool {
+	return *f.storage != "" || *f.inputRoots != ""
+}
+
+// options returns the storage and the input roots of the jobs. Inputs are
+// paths relative to the storage, e.g. "logs" for /data/logs with
+// -storage=/data
+func (f *sandboxFlags) options() []mapreduce.Option {
+	opts := []mapreduce.Option{mapreduce.WithInputRoots(splitList(*f.inputRoots)...)}
+	if *f.storage != "" {
+		opts = append(opts, mapreduce.WithStorage(f.store()))
+	}
+	return opts
+}
+
+// store returns the storage of the jobs
+func (f *sandboxFlags) store() mapreduce.Storage {
+	if *f.storage == "" {
+		return mapreduce.DefaultStorage
+	}
+	return mapreduce.LocalStorage{Dir: *f.storage}
+}
+
+// given reports whether a job is described, by inputs or a spec file
+func (f *jobFlags) given() bool {
+	return f.fs.NArg() > 0 || *f.file != ""
+}
+
+// spec returns the spec of the job on the inputs given as arguments, or
+// else on those of the spec file or defaultInputs, or why it is invalid
+func (f *jobFlags) spec(defaultInputs ...string) (mapreduce.JobSpec, error) {
+	spec := mapreduce.JobSpec{
+		Name:    *f.name,
+		NReduce: *f.nReduce,
+		App:     *f.app,
+		Include: splitList(*f.include),
+		Exclude: splitList(*f.exclude),
+	}
+	if *f.file != "" {
+		loaded, err := mapreduce.LoadJobSpec(*f.file)
+		if err != nil {
+			return spec, err
 		}
-		if len(cleanedFiles) == 0 {
-			fmt.Println("Error: No valid input files provided")
-			flag.Usage()
-			os.Exit(1)
+		f.fs.Visit(func(fl *flag.Flag) {
+			switch fl.Name {
+			case "job":
+				loaded.Name = spec.Name
+			case "nReduce":
+				loaded.NReduce = spec.NReduce
+			case "app":
+				loaded.App = spec.App
+			case "include":
+				loaded.Include = spec.Include
+			case "exclude":
+				loaded.Exclude = spec.Exclude
+			}
+		})
+		if loaded.App == "" && loaded.Map == "" {
+			loaded.App = spec.App
 		}
+		spec = *loaded
+	}
+	if f.fs.NArg() > 0 {
+		spec.Inputs = f.fs.Args()
+	} else if len(spec.Inputs) == 0 {
+		spec.Inputs = defaultInputs
+	}
+	return spec, spec.Validate()
+}
 
-		// Handle nWorkers
-		if *nWorkers < 0 {
-			fmt.Println("Error: -nWorkers cannot be negative")
-			flag.Usage()
-			os.Exit(1)
-		}
-		fmt.Printf("Starting %d worker(s) for master mode\n", *nWorkers)
+// tlsFlags are the flags securing the connections to a master
+type tlsFlags struct {
+	cert, key, ca *string
+}
 
-		// If nWorkers > 0, start that many workers locally (in goroutines)
-		if *nWorkers > 0 {
-			go mapreduce.RunWorkers(masterAddr, *nWorkers, mapreduce.MapWordCount, mapreduce.ReduceWordCount)
-		} else {
-			fmt.Println("No workers started (nWorkers=0)")
-		}
+func addTLSFlags(fs *flag.FlagSet) *tlsFlags {
+	return &tlsFlags{
+		cert: fs.String("tlsCert", "", "Certificate file, to use TLS between the master, its workers and its clients"),
+		key:  fs.String("tlsKey", "", "Key file of -tlsCert"),
+		ca:   fs.String("tlsCA", "", "CA file to verify peer certificates with; requires certificates from both sides"),
+	}
+}
+
+// config returns the TLS configuration, nil without -tlsCert and -tlsKey
+func (f *tlsFlags) config() (*tls.Config, error) {
+	if *f.cert == "" && *f.key == "" {
+		return nil, nil
+	}
+	return mapreduce.LoadTLSConfig(*f.cert, *f.key, *f.ca)
+}
 
-		// Start the master (coordinator) that runs the distributed MapReduce job
-		mapreduce.StartDistributed(jobName, cleanedFiles, *nReduce, mapreduce.MapWordCount, mapreduce.ReduceWordCount)
+// clusterOptions returns the settings shared by a master and its workers.
+// The cluster token is read from the environment, so that it is neither
+// printed nor visible in ps
+func clusterOptions(tlsConfig *tls.Config, sandbox *sandboxFlags) []mapreduce.Option {
+	opts := append(sandbox.options(), mapreduce.WithClusterToken(os.Getenv("MAPREDUCE_CLUSTER_TOKEN")))
+	if tlsConfig != nil {
+		opts = append(opts, mapreduce.WithTLS(tlsConfig))
+	}
+	return opts
+}
 
-	case "worker":
-		// Workers don't use nWorkers; ignore it
-		fmt.Println("Starting a single worker")
-		mapreduce.RunWorkers(masterAddr, 1, mapreduce.MapWordCount, mapreduce.ReduceWordCount)
+// connectAddr turns the address a server listens on into one to connect to
+// it locally, e.g. "[::]:1234" into "localhost:1234"
+func connectAddr(listenAddr string) string {
+	host, port, err := net.SplitHostPort(listenAddr)
+	if err != nil {
+		return listenAddr
+	}
+	if ip := net.ParseIP(host); host == "" || ip != nil && ip.IsUnspecified() {
+		host = "localhost"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(list string) []string {
+	var res []string
+	for _, f := range strings.Split(list, ",") {
+		f = strings.TrimSpace(f)
+		if f != "" {
+			res = append(res, f)
+		}
 	}
+	return res
 }
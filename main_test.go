@@ -0,0 +1,162 @@
+package main
+
+import (
+	"io"
+	"mr/mapreduce"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// capture runs the command line args, returning its exit code and what it
+// printed on the standard output
+func capture(t *testing.T, args ...string) (int, string) {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("cannot create a pipe: %v", err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	code := run(args)
+	os.Stdout = stdout
+	w.Close()
+	return code, <-out
+}
+
+// startMaster serves the API of a master without workers, whose admin token
+// the commands read from the environment. Its storage, in a temporary
+// directory, holds an input file "inputs/in".
+func startMaster(t *testing.T) (*mapreduce.Master, string) {
+	t.Helper()
+	t.Setenv("MAPREDUCE_ADMIN_TOKEN", "secret")
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "inputs"), 0755)
+	if err := os.WriteFile(filepath.Join(dir, "inputs", "in"), []byte("foo bar"), 0644); err != nil {
+		t.Fatalf("cannot write the input: %v", err)
+	}
+	store := mapreduce.LocalStorage{Dir: dir}
+	m := mapreduce.NewMaster(mapreduce.WithAdminToken("secret"), mapreduce.WithStorage(store))
+	srv := httptest.NewServer(m.Handler())
+	t.Cleanup(srv.Close)
+	return m, srv.URL
+}
+
+func TestUsageErrors(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"unknown"},
+		{"run", "-unknownFlag"},
+		{"run", "-nReduce=-1"},
+		{"worker", "-nWorkers=0"},
+		{"worker", "-id="},
+		{"worker", "extra"},
+		{"status", "a", "b"},
+		{"cancel"},
+		{"logs", "a", "b"},
+		{"submit", "-inputRoots=inputs", "inputs"},
+		{"history", "-inputRoots=inputs"},
+	} {
+		if code, _ := capture(t, args...); code != exitUsage {
+			t.Errorf("mr %s: exit code %d, want %d", strings.Join(args, " "), code, exitUsage)
+		}
+	}
+	if code, _ := capture(t, "help"); code != exitOK {
+		t.Errorf("mr help: exit code %d, want %d", code, exitOK)
+	}
+}
+
+func TestClientCommands(t *testing.T) {
+	m, url := startMaster(t)
+
+	code, out := capture(t, "submit", "-url="+url, "-job=cli", "-nReduce=1", "inputs")
+	if code != exitOK || !strings.Contains(out, "Job cli submitted") {
+		t.Fatalf("submit: exit code %d, output %q", code, out)
+	}
+	if status, err := m.JobStatus("cli"); err != nil || status.Status != mapreduce.JobRunning {
+		t.Fatalf("submitted job: %+v, %v", status, err)
+	}
+	if code, _ := capture(t, "submit", "-url="+url, "-job=cli", "inputs"); code != exitFailure {
+		t.Errorf("submit of a job of the same name: exit code %d, want %d", code, exitFailure)
+	}
+
+	code, out = capture(t, "status", "-url="+url)
+	if code != exitOK || !strings.Contains(out, "JOB") || !strings.Contains(out, "cli") || !strings.Contains(out, mapreduce.JobRunning) {
+		t.Errorf("status: exit code %d, output %q", code, out)
+	}
+	code, out = capture(t, "status", "-url="+url, "cli")
+	if code != exitOK || !strings.Contains(out, "Job:") || !strings.Contains(out, "0/") {
+		t.Errorf("status cli: exit code %d, output %q", code, out)
+	}
+	if code, _ := capture(t, "status", "-url="+url, "missing"); code != exitFailure {
+		t.Errorf("status of a missing job: exit code %d, want %d", code, exitFailure)
+	}
+
+	code, out = capture(t, "cancel", "-url="+url, "cli")
+	if code != exitOK || !strings.Contains(out, "Job cli cancelled") {
+		t.Errorf("cancel: exit code %d, output %q", code, out)
+	}
+	if status, _ := m.JobStatus("cli"); status.Status != mapreduce.JobCancelled {
+		t.Errorf("cancelled job is %s", status.Status)
+	}
+
+	code, out = capture(t, "logs", "-url="+url, "cli")
+	if code != exitOK || !strings.Contains(out, mapreduce.EventJobSubmitted) || !strings.Contains(out, mapreduce.EventJobCancelled) {
+		t.Errorf("logs: exit code %d, output %q", code, out)
+	}
+
+	// Without the admin token, the master refuses to change its jobs
+	t.Setenv("MAPREDUCE_ADMIN_TOKEN", "wrong")
+	if code, _ := capture(t, "submit", "-url="+url, "-job=refused", "inputs"); code != exitFailure {
+		t.Errorf("submit with a wrong token: exit code %d, want %d", code, exitFailure)
+	}
+}
+
+func TestSubmitWaitFailure(t *testing.T) {
+	m, url := startMaster(t)
+
+	// The job never completes without workers: cancel it while submit waits
+	go func() {
+		for {
+			if err := m.CancelJob("waited"); err == nil {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	code, out := capture(t, "submit", "-url="+url, "-job=waited", "-wait", "inputs")
+	if code != exitFailure || !strings.Contains(out, "Job waited submitted") {
+		t.Errorf("submit -wait of a cancelled job: exit code %d, output %q", code, out)
+	}
+}
+
+func TestRunStorage(t *testing.T) {
+	dir := t.TempDir()
+	os.Mkdir(filepath.Join(dir, "data"), 0755)
+	if err := os.WriteFile(filepath.Join(dir, "data", "in.txt"), []byte("foo bar foo"), 0644); err != nil {
+		t.Fatalf("cannot write the input: %v", err)
+	}
+
+	// Inputs are paths in the storage, wherever it is
+	code, out := capture(t, "run", "-storage="+dir, "-inputRoots=data", "-job=stored", "-nReduce=1", "data")
+	if code != exitOK || !strings.Contains(out, mapreduce.AnsName("stored")) {
+		t.Fatalf("run with a storage: exit code %d, output %q", code, out)
+	}
+	if _, err := os.Stat(filepath.Join(dir, mapreduce.AnsName("stored"))); err != nil {
+		t.Errorf("no result in the storage: %v", err)
+	}
+	if code, _ := capture(t, "run", "-storage="+dir, "-job=absolute", filepath.Join(dir, "data")); code != exitFailure {
+		t.Errorf("run on an absolute input: exit code %d, want %d", code, exitFailure)
+	}
+	if code, _ := capture(t, "submit", "-storage="+dir, "data"); code != exitUsage {
+		t.Errorf("submit with a storage: exit code %d, want %d", code, exitUsage)
+	}
+}
@@ -0,0 +1,97 @@
+package mapreduce
+
+import (
+	"fmt"
+	"sync"
+)
+
+// App bundles the functions of a job under a name, so that workers can run
+// tasks of jobs they were not started for, e.g. the stages of a pipeline.
+// Every worker process must register the same apps.
+type App struct {
+	Map     func(string) []KeyValue
+	Reduce  func(string, []string) string
+	Options []Option // e.g. WithMapTask, WithReduceTask
+}
+
+// Partitioner returns the reduce task, in [0, nReduce), a key goes to.
+type Partitioner func(key string, nReduce int) int
+
+// PartitionHash is the default partitioner, spreading keys evenly.
+const PartitionHash = "hash"
+
+var (
+	appsMu       sync.Mutex
+	apps         = make(map[string]App)
+	partitioners = map[string]Partitioner{
+		PartitionHash: func(key string, nReduce int) int {
+			return int(ihash(key)) % nReduce
+		},
+	}
+)
+
+// RegisterApp makes app available to workers under name.
+func RegisterApp(name string, app App) {
+	appsMu.Lock()
+	defer appsMu.Unlock()
+	apps[name] = app
+}
+
+func lookupApp(name string) (App, bool) {
+	appsMu.Lock()
+	defer appsMu.Unlock()
+	app, ok := apps[name]
+	return app, ok
+}
+
+// RegisterPartitioner makes p available to jobs under name, see
+// WithPartitioner. Like apps, every worker process must register it.
+func RegisterPartitioner(name string, p Partitioner) {
+	appsMu.Lock()
+	defer appsMu.Unlock()
+	partitioners[name] = p
+}
+
+func lookupPartitioner(name string) (Partitioner, bool) {
+	appsMu.Lock()
+	defer appsMu.Unlock()
+	p, ok := partitioners[name]
+	return p, ok
+}
+
+// partitionOf returns the reduce task the partitioner registered under name,
+// p, sends key to, or an error when it is out of [0, nReduce).
+func partitionOf(p Partitioner, name, key string, nReduce int) (int, error) {
+	r := p(key, nReduce)
+	if r < 0 || r >= nReduce {
+		return 0, fmt.Errorf("partitioner %q sent key %q to reduce task %d, out of [0, %d)", name, key, r, nReduce)
+	}
+	return r, nil
+}
+
+// appTaskFuncs returns the TaskContext-aware map and reduce functions of the
+// app registered under name.
+func appTaskFuncs(name string) (MapTaskFunc, ReduceTaskFunc, bool) {
+	app, ok := lookupApp(name)
+	if !ok {
+		return nil, nil, false
+	}
+	cfg := newConfig(app.Options)
+	mapTask, err := cfg.mapFunc(app.Map)
+	if err != nil {
+		return nil, nil, false
+	}
+	reduceTask, err := cfg.reduceFunc(app.Reduce)
+	if err != nil {
+		return nil, nil, false
+	}
+	return mapTask, reduceTask, true
+}
+
+// WithApp makes the tasks of a distributed job run the app registered under
+// name instead of the functions the workers were started with.
+func WithApp(name string) Option {
+	return func(c *config) {
+		c.app = name
+	}
+}
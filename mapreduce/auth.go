@@ -0,0 +1,180 @@
+package mapreduce
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// MaxClockSkew bounds the difference between the clocks of a worker and of
+// the master when the worker registers.
+const MaxClockSkew = 5 * time.Minute
+
+// SessionIdleTimeout is how long a session must go unused before it
+// expires by default, e.g. when its worker is gone, see Master.Register and
+// WithSessionIdleTimeout. Workers use their session at least every
+// heartbeat interval while they run tasks, and every second while they wait
+// for one; one whose session expired anyway registers again.
+const SessionIdleTimeout = 10 * time.Second
+
+var errUnauthenticated = errors.New("unauthenticated")
+
+// WithClusterToken makes workers authenticate to the master with a secret
+// shared by the cluster. The master then only serves the workers that
+// registered with it, each in its own session; workers prove they know the
+// token with an HMAC, the token itself never goes over the network.
+func WithClusterToken(token string) Option {
+	return func(c *config) {
+		c.clusterToken = token
+	}
+}
+
+// WithSessionIdleTimeout sets how long a session of a worker can go unused
+// before the master ends it, SessionIdleTimeout by default. It should be
+// longer than the heartbeat interval of the workers.
+func WithSessionIdleTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.sessionIdle = d
+	}
+}
+
+// RegisterArgs authenticate a worker, see Master.Register.
+type RegisterArgs struct {
+	WorkerID string
+	Time     int64  // Unix time in nanoseconds, to refuse old requests
+	Nonce    string // random, to refuse replayed requests
+	MAC      string // hex HMAC-SHA256 of the fields above with the cluster token
+	Session  string // previous session of the worker, e.g. when it reconnects
+}
+
+// RegisterReply holds the session the other RPCs of a worker must carry.
+type RegisterReply struct {
+	Session string
+}
+
+// registerMAC returns the MAC of a registration with token.
+func registerMAC(token, workerID string, t int64, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	fmt.Fprintf(mac, "%s\n%d\n%s", workerID, t, nonce)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// randomHex returns n random bytes in hex.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// newRegisterArgs signs the registration of a worker with token.
+func newRegisterArgs(token, workerID string) *RegisterArgs {
+	args := &RegisterArgs{WorkerID: workerID, Time: time.Now().UnixNano(), Nonce: randomHex(16)}
+	args.MAC = registerMAC(token, workerID, args.Time, args.Nonce)
+	return args
+}
+
+// Register RPC handler opening the session of a worker. When the master has
+// no cluster token, any worker is served and sessions are not checked.
+// Registering again ends the previous session of the worker when the
+// registration carries it; sessions unused for a while expire. A
+// registration taking the ID of another live worker is refused.
+func (m *Master) Register(args *RegisterArgs, reply *RegisterReply) error {
+	defer m.metrics.rpcDuration.ObserveSince(time.Now(), "Register")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.clusterToken != "" {
+		if err := m.checkRegistration(args); err != nil {
+			return m.reject("Register", args.WorkerID, err)
+		}
+	}
+	now := time.Now()
+	m.expireSessions(now)
+	for session, workerID := range m.sessions {
+		if workerID != args.WorkerID {
+			continue
+		}
+		if session != args.Session {
+			return m.reject("Register", args.WorkerID, fmt.Errorf("%w: worker %s is already registered", errConflict, args.WorkerID))
+		}
+		delete(m.sessions, session)
+		delete(m.sessionSeen, session)
+	}
+	reply.Session = randomHex(32)
+	m.sessions[reply.Session] = args.WorkerID
+	m.sessionSeen[reply.Session] = now
+	return nil
+}
+
+// expireSessions ends the sessions unused for m.sessionIdle, so that
+// those of the workers gone for good do not pile up. The caller must hold
+// m.mu.
+func (m *Master) expireSessions(now time.Time) {
+	for session, seen := range m.sessionSeen {
+		if now.Sub(seen) >= m.sessionIdle {
+			delete(m.sessions, session)
+			delete(m.sessionSeen, session)
+		}
+	}
+}
+
+// checkRegistration verifies the MAC, time and nonce of a registration.
+// The caller must hold m.mu.
+func (m *Master) checkRegistration(args *RegisterArgs) error {
+	expected := registerMAC(m.clusterToken, args.WorkerID, args.Time, args.Nonce)
+	if !hmac.Equal([]byte(args.MAC), []byte(expected)) {
+		return fmt.Errorf("%w: invalid MAC", errUnauthenticated)
+	}
+	now := time.Now()
+	t := time.Unix(0, args.Time)
+	if t.Before(now.Add(-MaxClockSkew)) || t.After(now.Add(MaxClockSkew)) {
+		return fmt.Errorf("%w: registration time %s too far from %s", errUnauthenticated,
+			t.Format(time.RFC3339), now.Format(time.RFC3339))
+	}
+	// Nonces are kept as long as their registration could be replayed
+	for nonce, seen := range m.nonces {
+		if now.Sub(seen) > 2*MaxClockSkew {
+			delete(m.nonces, nonce)
+		}
+	}
+	if _, ok := m.nonces[args.Nonce]; ok {
+		return fmt.Errorf("%w: replayed registration", errUnauthenticated)
+	}
+	m.nonces[args.Nonce] = now
+	return nil
+}
+
+// authorize checks that an RPC of a worker carries the session it
+// registered. The caller must hold m.mu.
+func (m *Master) authorize(method, session, workerID string) error {
+	now := time.Now()
+	owner, ok := m.sessions[session]
+	if ok && now.Sub(m.sessionSeen[session]) >= m.sessionIdle {
+		delete(m.sessions, session)
+		delete(m.sessionSeen, session)
+		ok = false
+	}
+	if ok && owner == workerID {
+		m.sessionSeen[session] = now
+	}
+	if m.clusterToken == "" {
+		return nil
+	}
+	if !ok || owner != workerID {
+		return m.reject(method, workerID, fmt.Errorf("%w: no session for worker %s", errUnauthenticated, workerID))
+	}
+	return nil
+}
+
+// reject logs and counts a refused RPC, returning its error. The caller must
+// hold m.mu.
+func (m *Master) reject(method, workerID string, err error) error {
+	log.Printf("Rejected %s from worker %q: %v\n", method, workerID, err)
+	m.metrics.rejected.Add(1, method)
+	return err
+}
@@ -0,0 +1,116 @@
+package mapreduce
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// DefaultHeartbeatInterval is how often a worker tells the master it is
+// still running a task, see WithHeartbeatInterval.
+const DefaultHeartbeatInterval = 2 * time.Second
+
+// WithContext makes Sequential, StartDistributed, DoMap, DoReduce and
+// workers stop once ctx is done, e.g. cancelled or past its deadline. A
+// task stopped this way removes what it wrote, and a job stopped this way
+// fails with the error of ctx.
+func WithContext(ctx context.Context) Option {
+	return func(c *config) {
+		c.ctx = ctx
+	}
+}
+
+// WithHeartbeatInterval sets how often a worker sends a heartbeat to the
+// master while it runs a task. The reply tells it whether to go on.
+func WithHeartbeatInterval(d time.Duration) Option {
+	return func(c *config) {
+		c.heartbeatInterval = d
+	}
+}
+
+// Context returns the context of the task, done once the task is
+// cancelled. Long map and reduce functions may return early then, their
+// output being discarded anyway.
+func (tc *TaskContext) Context() context.Context {
+	if tc.ctx == nil {
+		return context.Background()
+	}
+	return tc.ctx
+}
+
+// taskCancelled returns the error of a task stopped by its context.
+func taskCancelled(ctx context.Context, taskType, jobName string, n int) error {
+	return fmt.Errorf("%s task %d of job %s stopped: %w", taskType, n, jobName, ctx.Err())
+}
+
+// HeartbeatArgs identify the task a worker runs, see Master.Heartbeat.
+type HeartbeatArgs struct {
+	WorkerID string
+	Session  string // see Register
+	JobName  string
+	TaskID   int
+	Slots    Slots // of the worker, see WithSlots
+	Used     Slots // slots running a task
+}
+
+// HeartbeatReply tells a worker whether to go on with its task.
+type HeartbeatReply struct {
+	Cancel bool // the worker no longer runs the task: it must stop it
+}
+
+// Heartbeat RPC handler for workers running a task. Each heartbeat pushes
+// back the timeout of the task, so that long tasks of live workers are not
+// reassigned. The task is cancelled when it is no longer assigned to the
+// worker, e.g. because its job was cancelled or because it timed out and
+// was given to another worker.
+func (m *Master) Heartbeat(args *HeartbeatArgs, reply *HeartbeatReply) error {
+	defer m.metrics.rpcDuration.ObserveSince(time.Now(), "Heartbeat")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.authorize("Heartbeat", args.Session, args.WorkerID); err != nil {
+		return err
+	}
+	m.reportSlots(args.WorkerID, args.Slots, args.Used)
+
+	reply.Cancel = true
+	if job := m.job(args.JobName); job != nil {
+		for i, task := range job.tasks {
+			if task.TaskID == args.TaskID {
+				reply.Cancel = task.Status != "in-progress" || task.Worker != args.WorkerID
+				if !reply.Cancel {
+					// The worker is alive: the task timeout starts again
+					job.tasks[i].Heartbeat = time.Now()
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// heartbeat sends heartbeats for task until ctx is done, cancelling it
+// when the master says so.
+func (w *Worker) heartbeat(ctx context.Context, cancel context.CancelFunc, task Task, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		w.mu.Lock()
+		args := &HeartbeatArgs{WorkerID: w.id, JobName: task.JobName, TaskID: task.TaskID, Slots: w.cfg.slots, Used: w.running}
+		w.mu.Unlock()
+		var reply HeartbeatReply
+		if err := w.call("Master.Heartbeat", args, &reply); err != nil {
+			log.Printf("Worker %s cannot send a heartbeat: %v\n", w.id, err)
+			continue
+		}
+		if reply.Cancel {
+			log.Printf("Worker %s stops task %d of job %s, cancelled by the master\n", w.id, task.TaskID, task.JobName)
+			cancel()
+			return
+		}
+	}
+}
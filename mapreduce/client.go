@@ -0,0 +1,108 @@
+package mapreduce
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Client calls the HTTP API of a master, e.g. for the command line.
+type Client struct {
+	url        string // of the dashboard, e.g. "http://localhost:8080"
+	adminToken string // for the calls that change the state of the master
+	http       *http.Client
+}
+
+// NewClient returns a client of the master serving its dashboard at
+// baseURL. tlsConfig, which may be nil, holds the client certificate when
+// the master requires one.
+func NewClient(baseURL, adminToken string, tlsConfig *tls.Config) *Client {
+	return &Client{
+		url:        strings.TrimSuffix(baseURL, "/"),
+		adminToken: adminToken,
+		http:       &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+	}
+}
+
+// APIError is an error status returned by the master.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s: %s", http.StatusText(e.StatusCode), e.Message)
+}
+
+// do sends a request to path of the API, decoding the JSON response in v
+// when it is not nil.
+func (c *Client) do(method, path string, body any, v any) error {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.url+path, r)
+	if err != nil {
+		return err
+	}
+	if c.adminToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.adminToken)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(resp.Body)
+		return &APIError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Submit starts the job spec describes, see Master.StartJob.
+func (c *Client) Submit(spec JobSpec) error {
+	return c.do("POST", "/api/jobs", spec, nil)
+}
+
+// Jobs returns the status of the jobs of the master.
+func (c *Client) Jobs() ([]JobStatus, error) {
+	var jobs []JobStatus
+	return jobs, c.do("GET", "/api/jobs", nil, &jobs)
+}
+
+// Job returns the status of a job.
+func (c *Client) Job(jobName string) (*JobStatus, error) {
+	var status JobStatus
+	if err := c.do("GET", "/api/jobs/"+url.PathEscape(jobName), nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Cancel cancels a job, see Master.CancelJob.
+func (c *Client) Cancel(jobName string) error {
+	return c.do("POST", "/api/jobs/"+url.PathEscape(jobName)+"/cancel", nil, nil)
+}
+
+// Events returns the events of a job from the after-th one.
+func (c *Client) Events(jobName string, after int) (*JobEvents, error) {
+	var events JobEvents
+	path := fmt.Sprintf("/api/jobs/%s/events?after=%d", url.PathEscape(jobName), after)
+	if err := c.do("GET", path, nil, &events); err != nil {
+		return nil, err
+	}
+	return &events, nil
+}
@@ -4,7 +4,7 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"os"
+	"sort"
 )
 
 // Debugging enabled?
@@ -26,26 +26,49 @@ func CheckError(err error, format string, a ...interface{}) {
 	}
 }
 
-func concatFiles(destination string, sources []string) error {
-	// Créer ou ouvrir le fichier de destination
-	destFile, err := os.Create(destination)
+func concatFiles(store Storage, destination string, sources []string) error {
+	// Créer ou ouvrir le fichier de destination, publié une fois complet
+	destFile, err := createTaskOutput(store, destination)
 	if err != nil {
 		return err
 	}
-	defer destFile.Close()
 
 	// Copier le contenu de chaque fichier source
 	for _, src := range sources {
-		srcFile, err := os.Open(src)
+		srcFile, err := store.Open(src)
 		if err != nil {
+			destFile.Abort()
 			return err
 		}
 		_, err = io.Copy(destFile, srcFile)
 		srcFile.Close()
 		if err != nil {
+			destFile.Abort()
 			return err
 		}
 	}
 
-	return nil
+	return destFile.Commit()
+}
+
+// sortedKeys returns the keys of m in increasing order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
 }
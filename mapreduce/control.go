@@ -0,0 +1,305 @@
+package mapreduce
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Statuses of workers excluded from scheduling, see BlacklistWorker and
+// DrainWorker.
+const (
+	WorkerBlacklisted = "Blacklisted"
+	WorkerDraining    = "Draining" // finishing its task
+	WorkerDrained     = "Drained"
+	WorkerExited      = "Exited" // told to exit, see Master.Shutdown
+)
+
+// Types of the events recorded for control actions.
+const (
+	EventJobCancelled      = "job-cancelled"
+	EventJobFailed         = "job-failed"
+	EventSchedulingPaused  = "scheduling-paused"
+	EventSchedulingResumed = "scheduling-resumed"
+	EventTaskRetried       = "task-retried"
+	EventWorkerBlacklisted = "worker-blacklisted"
+	EventWorkerDrained     = "worker-drained"
+	EventWorkerReinstated  = "worker-reinstated"
+)
+
+// AttemptCancelled is the status of the attempts stopped by a control
+// action: the job was cancelled, the task retried or the worker blacklisted.
+const AttemptCancelled = "cancelled"
+
+var (
+	errNotFound = errors.New("not found")
+	errConflict = errors.New("not possible in the current state")
+	errInvalid  = errors.New("invalid request")
+)
+
+// WithAdminToken enables the control API of the master (/api/...), which
+// then requires an "Authorization: Bearer <token>" header.
+func WithAdminToken(token string) Option {
+	return func(c *config) {
+		c.adminToken = token
+	}
+}
+
+// CancelJob stops scheduling the tasks of a job. The job ends with an error,
+// and the results of its tasks are left as they are.
+func (m *Master) CancelJob(jobName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job := m.job(jobName)
+	if job == nil {
+		return fmt.Errorf("job %s: %w", jobName, errNotFound)
+	}
+	if !job.running() {
+		return fmt.Errorf("job %s is over: %w", jobName, errConflict)
+	}
+	m.stopJob(job)
+	log.Printf("Job %s cancelled\n", jobName)
+	job.history.record(Event{Type: EventJobCancelled, Job: jobName, Counters: job.counters.Snapshot()})
+	job.history.close()
+	close(job.done)
+	return nil
+}
+
+// failJob stops a job that cannot complete, e.g. because a task failed too
+// many times. The caller must hold m.mu.
+func (m *Master) failJob(job *distJob, reason string) {
+	m.stopJob(job)
+	job.err = reason
+	log.Printf("Job %s failed: %s\n", job.name, reason)
+	job.history.record(Event{Type: EventJobFailed, Job: job.name, Error: reason, Counters: job.counters.Snapshot()})
+	job.history.close()
+	close(job.done)
+}
+
+// stopJob cancels the tasks of a job that did not complete. The caller must
+// hold m.mu.
+func (m *Master) stopJob(job *distJob) {
+	for i, task := range job.tasks {
+		switch task.Status {
+		case "in-progress":
+			m.endAttempt(job, task, AttemptCancelled)
+			task.Status = "cancelled"
+			m.setTask(job, i, task)
+			m.idle(task.Worker)
+		case "pending":
+			task.Status = "cancelled"
+			m.setTask(job, i, task)
+		}
+	}
+	job.cancelled = true
+	m.publish(sseProgress, progressDelta{Progress: m.progressPercent()})
+}
+
+// Pause stops handing out tasks until Resume is called. Running tasks go on,
+// and can still time out.
+func (m *Master) Pause() {
+	m.setPaused(true, EventSchedulingPaused)
+}
+
+// Resume resumes scheduling after Pause.
+func (m *Master) Resume() {
+	m.setPaused(false, EventSchedulingResumed)
+}
+
+func (m *Master) setPaused(paused bool, event string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.paused == paused {
+		return
+	}
+	m.paused = paused
+	log.Printf("Scheduling %s\n", strings.TrimPrefix(event, "scheduling-"))
+	m.broadcast(Event{Type: event})
+	m.publish(ssePaused, pausedDelta{Paused: paused})
+}
+
+// RetryTask gives up the running attempt of a task, e.g. a straggler, and
+// makes the task available again right away.
+func (m *Master) RetryTask(jobName string, taskID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job := m.job(jobName)
+	if job == nil {
+		return fmt.Errorf("job %s: %w", jobName, errNotFound)
+	}
+	for i, task := range job.tasks {
+		if task.TaskID != taskID {
+			continue
+		}
+		if task.Status != "in-progress" {
+			return fmt.Errorf("task %d of job %s is %s: %w", taskID, jobName, task.Status, errConflict)
+		}
+		m.retry(job, i)
+		return nil
+	}
+	return fmt.Errorf("task %d of job %s: %w", taskID, jobName, errNotFound)
+}
+
+// retry makes the i-th task of job, in progress, pending again. The caller
+// must hold m.mu.
+func (m *Master) retry(job *distJob, i int) {
+	task := job.tasks[i]
+	log.Printf("Retrying task %d of job %s\n", task.TaskID, job.name)
+	job.history.record(Event{Type: EventTaskRetried, Job: job.name, Task: task.ref(), Worker: task.Worker})
+	m.endAttempt(job, task, AttemptCancelled)
+	worker := task.Worker
+	task.Status = "pending"
+	task.Worker = ""
+	m.setTask(job, i, task)
+	m.idle(worker)
+}
+
+// BlacklistWorker stops giving tasks to a worker, and retries the task it
+// is running.
+func (m *Master) BlacklistWorker(workerID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.workers[workerID]; !ok {
+		return fmt.Errorf("worker %s: %w", workerID, errNotFound)
+	}
+	m.excluded[workerID] = WorkerBlacklisted
+	log.Printf("Worker %s blacklisted\n", workerID)
+	m.broadcast(Event{Type: EventWorkerBlacklisted, Worker: workerID})
+	for _, job := range m.jobs {
+		for i, task := range job.tasks {
+			if task.Status == "in-progress" && task.Worker == workerID {
+				m.retry(job, i)
+			}
+		}
+	}
+	m.setWorker(workerID, WorkerBlacklisted)
+	return nil
+}
+
+// DrainWorker stops giving tasks to a worker once it is done with the one
+// it is running.
+func (m *Master) DrainWorker(workerID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	status, ok := m.workers[workerID]
+	if !ok {
+		return fmt.Errorf("worker %s: %w", workerID, errNotFound)
+	}
+	m.excluded[workerID] = WorkerDraining
+	log.Printf("Draining worker %s\n", workerID)
+	m.broadcast(Event{Type: EventWorkerDrained, Worker: workerID})
+	if status == "Working" {
+		m.setWorker(workerID, WorkerDraining)
+	} else {
+		m.setWorker(workerID, WorkerDrained)
+	}
+	return nil
+}
+
+// ReinstateWorker gives tasks again to a blacklisted or drained worker.
+func (m *Master) ReinstateWorker(workerID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.excluded[workerID]; !ok {
+		return fmt.Errorf("worker %s is not excluded: %w", workerID, errConflict)
+	}
+	delete(m.excluded, workerID)
+	log.Printf("Worker %s reinstated\n", workerID)
+	m.broadcast(Event{Type: EventWorkerReinstated, Worker: workerID})
+	if m.workers[workerID] == WorkerDraining {
+		m.setWorker(workerID, "Working")
+	} else {
+		m.setWorker(workerID, "Idle")
+	}
+	return nil
+}
+
+// idle records that a worker is done with a task. It keeps working while it
+// runs others. The caller must hold m.mu.
+func (m *Master) idle(workerID string) {
+	busy := m.workerTasks(workerID) != (Slots{})
+	switch m.excluded[workerID] {
+	case WorkerBlacklisted:
+		m.setWorker(workerID, WorkerBlacklisted)
+	case WorkerDraining:
+		if busy {
+			m.setWorker(workerID, WorkerDraining)
+		} else {
+			m.setWorker(workerID, WorkerDrained)
+		}
+	default:
+		if busy {
+			m.setWorker(workerID, "Working")
+		} else {
+			m.setWorker(workerID, "Idle")
+		}
+	}
+}
+
+// controlHandler serves a control action of the API, after checking the
+// admin token.
+func (m *Master) controlHandler(action func(r *http.Request) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if m.adminToken == "" {
+			http.Error(w, "Control API disabled, the master has no admin token", http.StatusForbidden)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(m.adminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="mapreduce"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err := action(r); err != nil {
+			status := http.StatusInternalServerError
+			switch {
+			case errors.Is(err, errNotFound):
+				status = http.StatusNotFound
+			case errors.Is(err, errConflict):
+				status = http.StatusConflict
+			case errors.Is(err, errInvalid):
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleControl registers the control API on mux.
+func (m *Master) handleControl(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/jobs/{job}/cancel", m.controlHandler(func(r *http.Request) error {
+		return m.CancelJob(r.PathValue("job"))
+	}))
+	mux.HandleFunc("POST /api/jobs/{job}/tasks/{task}/retry", m.controlHandler(func(r *http.Request) error {
+		taskID, err := strconv.Atoi(r.PathValue("task"))
+		if err != nil {
+			return fmt.Errorf("task %q: %w", r.PathValue("task"), errNotFound)
+		}
+		return m.RetryTask(r.PathValue("job"), taskID)
+	}))
+	mux.HandleFunc("POST /api/pause", m.controlHandler(func(r *http.Request) error {
+		m.Pause()
+		return nil
+	}))
+	mux.HandleFunc("POST /api/resume", m.controlHandler(func(r *http.Request) error {
+		m.Resume()
+		return nil
+	}))
+	mux.HandleFunc("POST /api/workers/{worker}/blacklist", m.controlHandler(func(r *http.Request) error {
+		return m.BlacklistWorker(r.PathValue("worker"))
+	}))
+	mux.HandleFunc("POST /api/workers/{worker}/drain", m.controlHandler(func(r *http.Request) error {
+		return m.DrainWorker(r.PathValue("worker"))
+	}))
+	mux.HandleFunc("POST /api/workers/{worker}/reinstate", m.controlHandler(func(r *http.Request) error {
+		return m.ReinstateWorker(r.PathValue("worker"))
+	}))
+}
@@ -0,0 +1,85 @@
+package mapreduce
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Counters maintained by the framework for every job.
+const (
+	CounterMapInputRecords      = "map.input.records" // calls to the map function
+	CounterMapInputBytes        = "map.input.bytes"   // after decompression
+	CounterMapOutputRecords     = "map.output.records"
+	CounterCombineInputRecords  = "combine.input.records"
+	CounterCombineOutputRecords = "combine.output.records"
+	CounterSpilledRecords       = "spilled.records" // records written to intermediate files
+	CounterShuffleBytes         = "reduce.shuffle.bytes"
+	CounterReduceInputRecords   = "reduce.input.records"
+	CounterReduceInputGroups    = "reduce.input.groups"
+	CounterReduceOutputRecords  = "reduce.output.records"
+)
+
+// Counters holds named counters, such as the number of malformed records
+// met by a job. It is safe for concurrent use.
+type Counters struct {
+	mu     sync.Mutex
+	values map[string]int64
+}
+
+func NewCounters() *Counters {
+	return &Counters{values: make(map[string]int64)}
+}
+
+// Add adds delta to the named counter.
+func (c *Counters) Add(name string, delta int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[name] += delta
+}
+
+// Get returns the value of the named counter, 0 if it was never set.
+func (c *Counters) Get(name string) int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[name]
+}
+
+// Snapshot returns a copy of all the counters.
+func (c *Counters) Snapshot() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res := make(map[string]int64, len(c.values))
+	for k, v := range c.values {
+		res[k] = v
+	}
+	return res
+}
+
+// Merge adds the given values to the counters.
+func (c *Counters) Merge(values map[string]int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, v := range values {
+		c.values[k] += v
+	}
+}
+
+// String lists the counters sorted by name.
+func (c *Counters) String() string {
+	values := c.Snapshot()
+	parts := make([]string, 0, len(values))
+	for _, k := range sortedKeys(values) {
+		parts = append(parts, fmt.Sprintf("%s=%d", k, values[k]))
+	}
+	return strings.Join(parts, " ")
+}
+
+// WithCounters adds the counters of the job tasks to c once they are done.
+// In a distributed job, only the attempt of a task that completed it first
+// is taken into account.
+func WithCounters(c *Counters) Option {
+	return func(cfg *config) {
+		cfg.counters = c
+	}
+}
@@ -1,105 +1,240 @@
 package mapreduce
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"html/template"
 	"log"
 	"net"
 	"net/http"
 	"net/rpc"
-	"os"
 	"sync"
 	"time"
 )
 
 // Task represents a map or reduce task
 type Task struct {
-	Type      string    `json:"Type"`      // "map" or "reduce"
-	File      string    `json:"File"`      // Input file for map tasks or identifier for reduce
-	Status    string    `json:"Status"`    // "pending", "in-progress", "completed"
-	Worker    string    `json:"Worker"`    // Worker assigned to the task
-	StartTime time.Time `json:"-"`         // Task start time (ignore in JSON)
-	TaskID    int       `json:"TaskID"`    // Unique task ID
-	MapNum    int       `json:"MapNum"`    // Map task index
-	ReduceNum int       `json:"ReduceNum"` // Reduce task index
-	NMap      int       `json:"NMap"`      // Total map tasks (for reduce tasks)
-	NReduce   int       `json:"NReduce"`   // Total reduce tasks (for map tasks)
-	JobName   string    `json:"JobName"`   // Job name for context
-}
-
-// Master holds the MapReduce job state
+	Type      string       `json:"Type"`               // "map" or "reduce"
+	File      string       `json:"File"`               // Input file for map tasks or identifier for reduce
+	Status    string       `json:"Status"`             // "pending", "in-progress", "completed"
+	Worker    string       `json:"Worker"`             // Worker assigned to the task
+	StartTime time.Time    `json:"StartTime,omitzero"` // Start time of the current attempt
+	Heartbeat time.Time    `json:"Heartbeat,omitzero"` // Last heartbeat of the current attempt
+	TaskID    int          `json:"TaskID"`             // Unique task ID
+	MapNum    int          `json:"MapNum"`             // Map task index
+	ReduceNum int          `json:"ReduceNum"`          // Reduce task index
+	NMap      int          `json:"NMap"`               // Total map tasks (for reduce tasks)
+	NReduce   int          `json:"NReduce"`            // Total reduce tasks (for map tasks)
+	JobName   string       `json:"JobName"`            // Job name for context
+	App       string       `json:"App"`                // Registered app running the task, see RegisterApp
+	Outputs   []string     `json:"Outputs"`            // Named outputs of the job
+	Attempts  int          `json:"Attempts"`           // Number of times the task was assigned
+	Settings  TaskSettings `json:"Settings,omitzero"`  // Settings of the job for the worker
+}
+
+// lastSeen returns when the worker running the task was last heard of: the
+// start of the attempt or its last heartbeat. The task times out when the
+// worker is silent for longer than the task timeout.
+func (t Task) lastSeen() time.Time {
+	if t.Heartbeat.After(t.StartTime) {
+		return t.Heartbeat
+	}
+	return t.StartTime
+}
+
+// Master holds the state of the jobs it runs and of its workers
 type Master struct {
 	mu          sync.Mutex
-	tasks       []Task
+	jobs        []*distJob
 	workers     map[string]string // workerID -> status ("Idle", "Working")
-	nMap        int
-	nReduce     int
-	completed   int
-	totalTasks  int
-	jobName     string
-	inputFiles  []string
+	slots       map[string]workerSlots
+	running     map[string]Slots // workerID -> tasks in progress, see setTask
 	taskTimeout time.Duration
-	mapF        func(string) []KeyValue
-	reduceF     func(string, []string) string
+	metrics     *masterMetrics
+	subscribers map[chan []byte]struct{} // dashboards following /events
+	adminToken  string                   // of the control API, disabled when empty
+	paused      bool
+	excluded    map[string]string // workerID -> WorkerBlacklisted or WorkerDraining
+
+	clusterToken string               // of the workers, see Register
+	sessions     map[string]string    // session -> workerID
+	sessionSeen  map[string]time.Time // session -> time of its last use
+	sessionIdle  time.Duration        // see WithSessionIdleTimeout
+	nonces       map[string]time.Time // of the recent registrations
+
+	storage    Storage  // of the jobs submitted through the API
+	inputRoots []string // of the jobs submitted through the API
+
+	rpcAddr       string
+	dashboardAddr string
+	tlsConfig     *tls.Config
+	onServe       func(rpcAddr, dashboardAddr string) // see WithOnServe
+	rpcListener   net.Listener                        // set by Serve
+	httpListener  net.Listener
+	httpServer    *http.Server
+	rpcConns      map[net.Conn]struct{} // of the workers, closed by Close
+	exiting       bool                  // workers are told to exit, see Shutdown
+}
+
+// distJob is a job submitted to the master
+type distJob struct {
+	name       string
+	tasks      []Task
+	nMap       int
+	nReduce    int
+	completed  int
+	totalTasks int
+	inputFiles []string
+	store      Storage
+	outputs    []string
+	counters   *Counters                // totals of the winning attempts
+	taskCounts map[int]map[string]int64 // counters of the completed tasks, by task ID
+	history    *historyLog              // events, see JobEvents
+	cancelled  bool
+	merges     bool          // the master merges the results, see RunJob
+	merged     bool          // the results can be read
+	err        string        // why the job failed
+	cfg        *config       // job settings, e.g. WithMaxAttempts
+	attempts   []TaskAttempt // in the order they started
+	done       chan struct{} // closed once every task is completed
 }
 
 // RPC argument/reply types
 type TaskArgs struct {
 	WorkerID string
+	Session  string // see Register
+	Free     Slots  // of the worker, none for a task of either type
 }
 
 type TaskReply struct {
 	Task      Task
 	Available bool
+	Exit      bool // the master shuts down: the worker must stop
 }
 
 type ReportArgs struct {
-	TaskID   int
-	WorkerID string
+	JobName   string
+	TaskID    int
+	WorkerID  string
+	Session   string           // see Register
+	Counters  map[string]int64 // counters of this attempt of the task
+	Error     string           // why the attempt failed, see ReportTaskFailed
+	BadInputs []int            // map tasks whose intermediate files could not be read
+}
+
+// NewMaster creates a master without jobs. StartDistributed creates one and
+// serves it; Handler serves its dashboard and metrics by other means. Only
+// the master settings of opts are used, e.g. WithAdminToken, along with
+// WithStorage and WithInputRoots for the jobs submitted through the API.
+func NewMaster(opts ...Option) *Master {
+	cfg := newConfig(opts)
+	return &Master{
+		workers:     make(map[string]string),
+		slots:       make(map[string]workerSlots),
+		running:     make(map[string]Slots),
+		taskTimeout: 10 * time.Second,
+		metrics:     newMasterMetrics(),
+		subscribers: make(map[chan []byte]struct{}),
+		adminToken:  cfg.adminToken,
+		excluded:    make(map[string]string),
+
+		clusterToken: cfg.clusterToken,
+		sessions:     make(map[string]string),
+		sessionSeen:  make(map[string]time.Time),
+		sessionIdle:  cfg.sessionIdle,
+		nonces:       make(map[string]time.Time),
+
+		storage:    cfg.storage,
+		inputRoots: cfg.inputRoots,
+
+		rpcAddr:       cfg.rpcAddr,
+		dashboardAddr: cfg.dashboardAddr,
+		tlsConfig:     cfg.tlsConfig,
+		onServe:       cfg.onServe,
+		rpcConns:      make(map[net.Conn]struct{}),
+	}
 }
 
-// GetTask RPC handler for workers to get a task
+// GetTask RPC handler for workers to get a task. Jobs are served in the
+// order they were submitted.
 func (m *Master) GetTask(args *TaskArgs, reply *TaskReply) error {
+	defer m.metrics.rpcDuration.ObserveSince(time.Now(), "GetTask")
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if err := m.authorize("GetTask", args.Session, args.WorkerID); err != nil {
+		return err
+	}
+
+	if m.exiting {
+		reply.Exit = true
+		m.setWorker(args.WorkerID, WorkerExited)
+		return nil
+	}
 
 	now := time.Now()
+	if status, ok := m.workers[args.WorkerID]; !ok || status == "Lost" {
+		m.idle(args.WorkerID)
+		m.broadcast(Event{Type: EventWorkerJoined, Worker: args.WorkerID})
+	}
+	_, excluded := m.excluded[args.WorkerID]
+
+	for _, job := range m.jobs {
+		// Reassign timed-out tasks first
+		for i, task := range job.tasks {
+			if task.Status == "in-progress" && now.Sub(task.lastSeen()) > job.taskTimeout(m.taskTimeout) {
+				log.Printf("Task %d of job %s timed out, reassigning\n", task.TaskID, job.name)
+				m.metrics.timeouts.Add(1, task.Type)
+				job.history.record(Event{Type: EventTaskTimeout, Job: job.name, Task: task.ref(), Worker: task.Worker})
+				m.endAttempt(job, task, AttemptTimeout)
+				if m.workers[task.Worker] != "Lost" {
+					m.setWorker(task.Worker, "Lost")
+					m.broadcast(Event{Type: EventWorkerLost, Worker: task.Worker})
+				}
+				task.Status = "pending"
+				task.Worker = ""
+				m.setTask(job, i, task)
+				m.checkAttempts(job, task)
+			}
+		}
 
-	// Reassign timed-out tasks first
-	for i, task := range m.tasks {
-		if task.Status == "in-progress" && now.Sub(task.StartTime) > m.taskTimeout {
-			log.Printf("Task %d timed out, reassigning\n", task.TaskID)
-			task.Status = "pending"
-			task.Worker = ""
-			m.tasks[i] = task
+		if m.paused || excluded || !job.running() {
+			continue
 		}
-	}
 
-	// Assign a pending task to the worker
-	for i, task := range m.tasks {
-		if task.Status == "pending" {
-			// For reduce tasks, ensure all map tasks are completed
-			if task.Type == "reduce" {
-				allMapsDone := true
-				for j := 0; j < m.nMap; j++ {
-					if m.tasks[j].Status != "completed" {
-						allMapsDone = false
-						break
+		// Assign a pending task to the worker
+		for i, task := range job.tasks {
+			if task.Status == "pending" && args.Free.fits(task.Type) {
+				// For reduce tasks, ensure all map tasks are completed
+				if task.Type == "reduce" {
+					allMapsDone := true
+					for j := 0; j < job.nMap; j++ {
+						if job.tasks[j].Status != "completed" {
+							allMapsDone = false
+							break
+						}
+					}
+					if !allMapsDone {
+						continue // skip reduce tasks until map tasks done
 					}
 				}
-				if !allMapsDone {
-					continue // skip reduce tasks until map tasks done
+				task.Status = "in-progress"
+				task.Worker = args.WorkerID
+				task.StartTime = now
+				task.Heartbeat = time.Time{}
+				task.Attempts++
+				if task.Attempts > 1 {
+					m.metrics.reassignments.Add(1, task.Type)
 				}
+				m.setTask(job, i, task)
+				m.startAttempt(job, task)
+				job.history.record(Event{Type: EventTaskAssigned, Job: job.name, Task: task.ref(), Worker: args.WorkerID})
+				reply.Task = task
+				reply.Available = true
+				m.setWorker(args.WorkerID, "Working")
+				return nil
 			}
-			task.Status = "in-progress"
-			task.Worker = args.WorkerID
-			task.StartTime = now
-			m.tasks[i] = task
-			reply.Task = task
-			reply.Available = true
-			m.workers[args.WorkerID] = "Working"
-			return nil
 		}
 	}
 
@@ -110,137 +245,230 @@ func (m *Master) GetTask(args *TaskArgs, reply *TaskReply) error {
 
 // ReportTaskDone marks a task as completed by a worker
 func (m *Master) ReportTaskDone(args *ReportArgs, reply *struct{}) error {
+	defer m.metrics.rpcDuration.ObserveSince(time.Now(), "ReportTaskDone")
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	if err := m.authorize("ReportTaskDone", args.Session, args.WorkerID); err != nil {
+		return err
+	}
 
-	for i, task := range m.tasks {
+	job := m.job(args.JobName)
+	if job == nil {
+		return nil
+	}
+	for i, task := range job.tasks {
 		if task.TaskID == args.TaskID && task.Worker == args.WorkerID && task.Status == "in-progress" {
 			task.Status = "completed"
-			m.tasks[i] = task
-			m.completed++
-			m.workers[args.WorkerID] = "Idle"
-			log.Printf("Task %d completed by worker %s\n", task.TaskID, args.WorkerID)
+			job.completed++
+			m.setTask(job, i, task)
+			m.endAttempt(job, task, AttemptCompleted)
+			job.counters.Merge(args.Counters)
+			job.taskCounts[task.TaskID] = args.Counters
+			m.publish(sseCounters, countersDelta{Job: job.name, Counters: job.counters.Snapshot()})
+			m.metrics.taskDuration.ObserveSince(task.StartTime, task.Type)
+			m.idle(args.WorkerID)
+			log.Printf("Task %d of job %s completed by worker %s\n", task.TaskID, job.name, args.WorkerID)
+			job.history.record(Event{Type: EventTaskComplete, Job: job.name, Task: task.ref(),
+				Worker: args.WorkerID, Counters: args.Counters})
+			if err := checkCounterLimits(job.counters.Snapshot(), job.cfg.counterLimits); err != nil {
+				m.failJob(job, err.Error())
+			} else if job.completed == job.totalTasks {
+				job.history.record(Event{Type: EventJobCompleted, Job: job.name, Counters: job.counters.Snapshot()})
+				job.history.close()
+				close(job.done)
+			}
 			break
 		}
 	}
 	return nil
 }
 
-// WorkerInfo is the JSON structure for workers in the dashboard data
-type WorkerInfo struct {
-	Name   string `json:"Name"`
-	Status string `json:"Status"`
-}
+// ReportTaskFailed makes a task a worker could not run available again
+// right away, instead of waiting for it to time out.
+func (m *Master) ReportTaskFailed(args *ReportArgs, reply *struct{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.authorize("ReportTaskFailed", args.Session, args.WorkerID); err != nil {
+		return err
+	}
 
-// DashboardData is the JSON response sent to the dashboard frontend
-type DashboardData struct {
-	Workers  []WorkerInfo `json:"Workers"`
-	Tasks    []Task       `json:"Tasks"`
-	Progress float64      `json:"Progress"`
+	job := m.job(args.JobName)
+	if job == nil {
+		return nil
+	}
+	for i, task := range job.tasks {
+		if task.TaskID == args.TaskID && task.Worker == args.WorkerID && task.Status == "in-progress" {
+			log.Printf("Task %d of job %s failed on worker %s: %s\n", task.TaskID, job.name, args.WorkerID, args.Error)
+			job.history.record(Event{Type: EventTaskFailed, Job: job.name, Task: task.ref(),
+				Worker: args.WorkerID, Error: args.Error})
+			m.endAttempt(job, task, AttemptFailed)
+			task.Status = "pending"
+			task.Worker = ""
+			m.setTask(job, i, task)
+			m.idle(args.WorkerID)
+			for _, mapNum := range args.BadInputs {
+				m.rerunMap(job, mapNum)
+			}
+			m.checkAttempts(job, task)
+			break
+		}
+	}
+	return nil
 }
 
-// StartDashboard starts the HTTP server serving the dashboard UI and data
-func (m *Master) StartDashboard() {
-	http.HandleFunc("/", m.dashboardHandler)
-	http.HandleFunc("/data", m.dataHandler)
-
-	fmt.Println("Dashboard running at: http://localhost:8080")
-
-	if err := http.ListenAndServe(":8080", nil); err != nil {
-		log.Fatal("Dashboard failed:", err)
+// rerunMap makes a completed map task of job pending again, its output
+// being unreadable. Its counters are taken back from the totals of the job,
+// those of the next attempt replacing them. The caller must hold m.mu.
+func (m *Master) rerunMap(job *distJob, mapNum int) {
+	for i, task := range job.tasks {
+		if task.Type == "map" && task.MapNum == mapNum && task.Status == "completed" {
+			log.Printf("Output of task %d of job %s is unreadable, running it again\n", task.TaskID, job.name)
+			job.history.record(Event{Type: EventTaskRetried, Job: job.name, Task: task.ref(), Worker: task.Worker})
+			task.Status = "pending"
+			task.Worker = ""
+			job.completed--
+			m.setTask(job, i, task)
+			for name, value := range job.taskCounts[task.TaskID] {
+				job.counters.Add(name, -value)
+			}
+			delete(job.taskCounts, task.TaskID)
+			m.publish(sseCounters, countersDelta{Job: job.name, Counters: job.counters.Snapshot()})
+			return
+		}
 	}
 }
 
-// dashboardHandler serves the dashboard HTML page
-func (m *Master) dashboardHandler(w http.ResponseWriter, r *http.Request) {
-	m.mu.Lock()
-	jobDone := m.completed >= m.totalTasks
-	m.mu.Unlock()
+// ref identifies the current attempt of the task in history events.
+func (t Task) ref() *EventTask {
+	return &EventTask{ID: t.TaskID, Type: t.Type, File: t.File, Attempt: t.Attempts}
+}
 
-	var result string
-	if jobDone {
-		data, err := os.ReadFile(AnsName(m.jobName)) // usually mrtmp.wordcount
-		if err != nil {
-			result = "Error reading result file."
-		} else {
-			result = string(data)
+// broadcast records an event that is not about a job in particular, e.g.
+// about a worker, in the history of the jobs still running. The caller must
+// hold m.mu.
+func (m *Master) broadcast(e Event) {
+	for _, job := range m.jobs {
+		if job.running() {
+			e.Job = job.name
+			job.history.record(e)
 		}
 	}
+}
 
-	tmpl, err := template.ParseFiles("mapreduce/dashboard.html")
-	if err != nil {
-		http.Error(w, "Internal server error - template not found", http.StatusInternalServerError)
-		return
-	}
-
-	data := struct {
-		FinalResult string
-	}{
-		FinalResult: result,
+// taskTimeout returns the task timeout of the job, def unless set with
+// WithTaskTimeout.
+func (job *distJob) taskTimeout(def time.Duration) time.Duration {
+	if job.cfg.taskTimeout > 0 {
+		return job.cfg.taskTimeout
 	}
+	return def
+}
 
-	if err := tmpl.Execute(w, data); err != nil {
-		http.Error(w, "Internal server error - template execution failed", http.StatusInternalServerError)
+// checkAttempts fails job when its task, which just failed or timed out,
+// reached the maximum number of attempts. The caller must hold m.mu.
+func (m *Master) checkAttempts(job *distJob, task Task) {
+	if max := job.cfg.maxAttempts; max > 0 && task.Attempts >= max && job.running() {
+		m.failJob(job, fmt.Sprintf("task %d failed %d times", task.TaskID, task.Attempts))
 	}
 }
 
-// dataHandler serves the live JSON data for the dashboard
-func (m *Master) dataHandler(w http.ResponseWriter, r *http.Request) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+// running reports whether the job still has tasks to run.
+func (job *distJob) running() bool {
+	return !job.cancelled && job.completed < job.totalTasks
+}
 
-	data := DashboardData{
-		Workers:  make([]WorkerInfo, 0, len(m.workers)),
-		Tasks:    m.tasks,
-		Progress: 0,
+// job returns the job with the given name, nil if there is none. The
+// caller must hold m.mu.
+func (m *Master) job(name string) *distJob {
+	for _, job := range m.jobs {
+		if job.name == name {
+			return job
+		}
 	}
+	return nil
+}
 
-	if m.totalTasks > 0 {
-		data.Progress = float64(m.completed) / float64(m.totalTasks) * 100
+// progressPercent returns the percentage of completed tasks over all jobs.
+// The caller must hold m.mu.
+func (m *Master) progressPercent() float64 {
+	completed, total := m.progress()
+	if total == 0 {
+		return 0
 	}
+	return float64(completed) / float64(total) * 100
+}
 
-	for w, s := range m.workers {
-		data.Workers = append(data.Workers, WorkerInfo{Name: w, Status: s})
+// progress returns the number of completed tasks and the total number of
+// tasks over all jobs but the cancelled ones. The caller must hold m.mu.
+func (m *Master) progress() (completed, total int) {
+	for _, job := range m.jobs {
+		if job.cancelled {
+			continue
+		}
+		completed += job.completed
+		total += job.totalTasks
 	}
+	return completed, total
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(data)
+// Submit creates the map and reduce tasks of a job and makes them available
+// to workers. Like Sequential, files may hold directories and glob
+// patterns, and nReduce may be 0 for a map-only job. Results are merged by
+// StartDistributed only; the parts are left to the caller otherwise.
+func (m *Master) Submit(jobName string, files []string, nReduce int, opts ...Option) error {
+	_, err := m.submit(jobName, files, nReduce, newConfig(opts), false)
+	return err
 }
 
-// StartDistributed runs the distributed MapReduce master server
-func StartDistributed(jobName string, files []string, nReduce int,
-	mapF func(string) []KeyValue, reduceF func(string, []string) string) {
+// submit creates a job, whose results are merged by the master when merges
+// is set, see finish.
+func (m *Master) submit(jobName string, files []string, nReduce int, cfg *config, merges bool) (*distJob, error) {
+	if err := cfg.check(jobName); err != nil {
+		return nil, err
+	}
+	files, err := planInputs(jobName, cfg.storage, files, cfg)
+	if err != nil {
+		return nil, err
+	}
 
-	m := &Master{
-		tasks:       make([]Task, 0),
-		workers:     make(map[string]string),
-		nMap:        len(files),
-		nReduce:     nReduce,
-		completed:   0,
-		totalTasks:  len(files) + nReduce,
-		jobName:     jobName,
-		inputFiles:  files,
-		taskTimeout: 10 * time.Second,
-		mapF:        mapF,
-		reduceF:     reduceF,
+	job := &distJob{
+		name:       jobName,
+		tasks:      make([]Task, 0),
+		nMap:       len(files),
+		nReduce:    nReduce,
+		totalTasks: len(files) + nReduce,
+		inputFiles: files,
+		store:      cfg.storage,
+		outputs:    cfg.outputs,
+		counters:   cfg.counters,
+		taskCounts: make(map[int]map[string]int64),
+		merges:     merges,
+		cfg:        cfg,
+		done:       make(chan struct{}),
+	}
+	if job.counters == nil {
+		job.counters = NewCounters()
 	}
 
 	// Create map tasks
 	for i, file := range files {
-		m.tasks = append(m.tasks, Task{
-			Type:    "map",
-			File:    file,
-			Status:  "pending",
-			TaskID:  i,
-			MapNum:  i,
-			NReduce: nReduce,
-			JobName: jobName,
+		job.tasks = append(job.tasks, Task{
+			Type:     "map",
+			File:     file,
+			Status:   "pending",
+			TaskID:   i,
+			MapNum:   i,
+			NReduce:  nReduce,
+			JobName:  jobName,
+			App:      cfg.app,
+			Outputs:  cfg.outputs,
+			Settings: cfg.settings,
 		})
 	}
 
 	// Create reduce tasks
 	for i := 0; i < nReduce; i++ {
-		m.tasks = append(m.tasks, Task{
+		job.tasks = append(job.tasks, Task{
 			Type:      "reduce",
 			File:      fmt.Sprintf("reduce-%d", i),
 			Status:    "pending",
@@ -248,41 +476,370 @@ func StartDistributed(jobName string, files []string, nReduce int,
 			ReduceNum: i,
 			NMap:      len(files),
 			JobName:   jobName,
+			App:       cfg.app,
+			Outputs:   cfg.outputs,
+			Settings:  cfg.settings,
 		})
 	}
 
-	// Start RPC server
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.job(jobName) != nil {
+		return nil, fmt.Errorf("job %s already submitted: %w", jobName, errConflict)
+	}
+	job.history, err = newHistoryLog(cfg.storage, cfg.historyDir, jobName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create history of job %s: %v", jobName, err)
+	}
+	job.history.record(Event{Type: EventJobSubmitted, Job: jobName, Config: &JobConfig{
+		Inputs:  files,
+		NReduce: nReduce,
+		Outputs: cfg.outputs,
+		App:     cfg.app,
+	}})
+	m.jobs = append(m.jobs, job)
+	for _, task := range job.tasks {
+		m.publish(sseTask, task)
+	}
+	m.publish(sseProgress, progressDelta{Progress: m.progressPercent()})
+	return job, nil
+}
+
+// RunJob submits a job, waits for all its tasks and merges its results.
+// The job is cancelled, and its files removed, once the context of opts is
+// done, see WithContext.
+func (m *Master) RunJob(jobName string, files []string, nReduce int, opts ...Option) error {
+	_, err := m.runJob(jobName, files, nReduce, newConfig(opts))
+	return err
+}
+
+func (m *Master) runJob(jobName string, files []string, nReduce int, cfg *config) (*distJob, error) {
+	job, err := m.submit(jobName, files, nReduce, cfg, true)
+	if err != nil {
+		return nil, err
+	}
+	stop := context.AfterFunc(cfg.ctx, func() { m.CancelJob(jobName) })
+	defer stop()
+	err = m.finish(job)
+	if err != nil && cfg.ctx.Err() != nil {
+		CleanIntermediary(jobName, job.nMap, job.nReduce, WithStorage(job.store), withOutputs(job.outputs))
+		err = fmt.Errorf("job %s stopped: %w", jobName, cfg.ctx.Err())
+	}
+	return job, err
+}
+
+// finish waits for all the tasks of job and merges its results.
+func (m *Master) finish(job *distJob) error {
+	<-job.done
+	if job.cancelled {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if job.err != "" {
+			return fmt.Errorf("job %s failed: %s", job.name, job.err)
+		}
+		return fmt.Errorf("job %s cancelled", job.name)
+	}
+	log.Printf("Job %s counters: %v\n", job.name, job.counters)
+
+	// Merge reduce (or map-only) output files and named outputs
+	err := mergeResults(job.store, job.name, job.nMap, job.nReduce, job.outputs)
+	if err == nil {
+		err = exportResult(job.name, job.cfg)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err != nil {
+		job.err = err.Error()
+		return err
+	}
+	job.merged = true
+	m.publish(sseResult, resultDelta{Job: job.name})
+	return nil
+}
+
+// WorkerInfo is the JSON structure for workers in the dashboard data
+type WorkerInfo struct {
+	Name   string `json:"Name"`
+	Status string `json:"Status"`
+	Tasks  Slots  `json:"Tasks"` // in progress on the worker
+	Slots  Slots  `json:"Slots"` // of the worker in its last heartbeat, none for one task at a time
+	Used   Slots  `json:"Used"`  // slots running a task in its last heartbeat
+}
+
+// DashboardData is the JSON response sent to the dashboard frontend
+type DashboardData struct {
+	Workers  []WorkerInfo                `json:"Workers"`
+	Tasks    []Task                      `json:"Tasks"`
+	Progress float64                     `json:"Progress"`
+	Counters map[string]map[string]int64 `json:"Counters"` // job name -> counter totals
+	Attempts []TaskAttempt               `json:"Attempts"`
+	Paused   bool                        `json:"Paused"`
+	Results  []string                    `json:"Results"` // jobs whose results can be browsed
+}
+
+// Handler returns the HTTP handler serving the dashboard UI, its data and
+// the Prometheus metrics of the master at /metrics.
+func (m *Master) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/{$}", m.dashboardHandler)
+	mux.Handle("/static/", staticHandler())
+	mux.HandleFunc("/data", m.dataHandler)
+	mux.HandleFunc("/events", m.eventsHandler)
+	mux.HandleFunc("/metrics", m.metricsHandler)
+	mux.HandleFunc("GET /api/results/{job}", m.resultsHandler)
+	mux.HandleFunc("GET /api/results/{job}/download", m.downloadHandler)
+	m.handleControl(mux)
+	m.handleJobs(mux)
+	return mux
+}
+
+// dashboardHandler serves the dashboard HTML page. Results are not part of
+// it, the page reads them by pages from the results API.
+func (m *Master) dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	if err := dashboardTemplate.Execute(w, nil); err != nil {
+		http.Error(w, "Internal server error - template execution failed", http.StatusInternalServerError)
+	}
+}
+
+// dataHandler serves the JSON data of the dashboard
+func (m *Master) dataHandler(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	data := m.dashboardData()
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(data)
+}
+
+// dashboardData returns the state of the master shown on the dashboard. The
+// caller must hold m.mu.
+func (m *Master) dashboardData() DashboardData {
+	data := DashboardData{
+		Workers:  make([]WorkerInfo, 0, len(m.workers)),
+		Tasks:    make([]Task, 0),
+		Attempts: make([]TaskAttempt, 0),
+		Results:  make([]string, 0),
+		Progress: 0,
+		Counters: make(map[string]map[string]int64),
+	}
+
+	for _, job := range m.jobs {
+		data.Tasks = append(data.Tasks, job.tasks...)
+		data.Counters[job.name] = job.counters.Snapshot()
+		data.Attempts = append(data.Attempts, job.attempts...)
+		if job.merged {
+			data.Results = append(data.Results, job.name)
+		}
+	}
+	data.Progress = m.progressPercent()
+	data.Paused = m.paused
+
+	for w := range m.workers {
+		data.Workers = append(data.Workers, m.workerInfo(w))
+	}
+	return data
+}
+
+// Serve starts accepting worker connections and serving the dashboard, on
+// the addresses set with WithRPCAddr and WithDashboardAddr. It returns once
+// both listen.
+func (m *Master) Serve() error {
 	rpcServer := rpc.NewServer()
-	err := rpcServer.Register(m)
-	CheckError(err, "RPC registration failed: %v\n", err)
-	listener, err := net.Listen("tcp", ":1234")
-	CheckError(err, "RPC listen failed: %v\n", err)
+	if err := rpcServer.Register(m); err != nil {
+		return fmt.Errorf("RPC registration failed: %v", err)
+	}
+	rpcListener, err := listen(m.rpcAddr, m.tlsConfig)
+	if err != nil {
+		return fmt.Errorf("RPC listen failed: %v", err)
+	}
+	httpListener, err := listen(m.dashboardAddr, m.tlsConfig)
+	if err != nil {
+		rpcListener.Close()
+		return fmt.Errorf("dashboard listen failed: %v", err)
+	}
+	httpServer := &http.Server{Handler: m.Handler()}
+	m.mu.Lock()
+	m.rpcListener, m.httpListener, m.httpServer = rpcListener, httpListener, httpServer
+	m.mu.Unlock()
 
 	go func() {
 		for {
-			conn, err := listener.Accept()
+			conn, err := rpcListener.Accept()
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 			if err != nil {
 				log.Printf("RPC accept error: %v\n", err)
 				continue
 			}
-			go rpcServer.ServeConn(conn)
+			m.mu.Lock()
+			m.rpcConns[conn] = struct{}{}
+			m.mu.Unlock()
+			go func() {
+				rpcServer.ServeConn(conn)
+				m.mu.Lock()
+				delete(m.rpcConns, conn)
+				m.mu.Unlock()
+			}()
 		}
 	}()
 
-	// Start dashboard HTTP server in goroutine
-	go m.StartDashboard()
+	scheme := "http"
+	if m.tlsConfig != nil {
+		scheme = "https"
+	}
+	fmt.Printf("RPC server listening on %s\n", rpcListener.Addr())
+	fmt.Printf("Dashboard running at: %s://%s\n", scheme, httpListener.Addr())
+	go func() {
+		err := httpServer.Serve(httpListener)
+		if !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, net.ErrClosed) {
+			log.Fatal("Dashboard failed:", err)
+		}
+	}()
+	if m.onServe != nil {
+		m.onServe(rpcListener.Addr().String(), httpListener.Addr().String())
+	}
+	return nil
+}
 
-	// Wait for all tasks to complete
-	for m.completed < m.totalTasks {
-		time.Sleep(time.Second)
+// RPCAddr returns the address workers connect to, once Serve was called.
+func (m *Master) RPCAddr() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.rpcListener == nil {
+		return ""
 	}
+	return m.rpcListener.Addr().String()
+}
 
-	// Merge reduce output files
-	resFiles := make([]string, nReduce)
-	for i := 0; i < nReduce; i++ {
-		resFiles[i] = MergeName(jobName, i)
+// DashboardAddr returns the address of the dashboard, once Serve was
+// called.
+func (m *Master) DashboardAddr() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.httpListener == nil {
+		return ""
+	}
+	return m.httpListener.Addr().String()
+}
+
+// Close stops the servers started by Serve, closing their connections.
+func (m *Master) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var err error
+	if m.rpcListener != nil {
+		err = m.rpcListener.Close()
 	}
-	err = concatFiles(AnsName(jobName), resFiles)
-	CheckError(err, "Failed to merge results: %v\n", err)
-	select {}
+	if m.httpServer != nil {
+		if cerr := m.httpServer.Close(); err == nil {
+			err = cerr
+		}
+	}
+	for conn := range m.rpcConns {
+		conn.Close()
+	}
+	return err
+}
+
+// ShutdownGrace is how long Shutdown waits for the workers to learn that
+// they must exit.
+const ShutdownGrace = 5 * time.Second
+
+// Shutdown tells the workers to exit through their next GetTask call,
+// waits up to ShutdownGrace for all of them to be told, then closes the
+// servers.
+func (m *Master) Shutdown() error {
+	m.mu.Lock()
+	m.exiting = true
+	m.mu.Unlock()
+	for deadline := time.Now().Add(ShutdownGrace); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if m.workersExited() {
+			break
+		}
+	}
+	return m.Close()
+}
+
+// workersExited reports whether every worker still alive was told to exit.
+func (m *Master) workersExited() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, status := range m.workers {
+		if status != WorkerExited && status != "Lost" {
+			return false
+		}
+	}
+	return true
+}
+
+// JobResult is the outcome of a job run by StartDistributed.
+type JobResult struct {
+	Job      string
+	Output   string            // merged result file, see AnsName
+	Outputs  map[string]string // merged named outputs, see OutputName
+	Export   string            // result in the output format, see WithOutputFormat
+	Counters map[string]int64
+	Duration time.Duration
+	Err      error // why the job failed, the other fields being partial
+}
+
+// StartDistributed runs a job on a distributed MapReduce master: it serves
+// the master, waits for its workers to run the tasks, merges the results,
+// tells the workers to exit and shuts the servers down. Like Sequential,
+// files may hold directories and glob patterns, and nReduce may be 0 for a
+// map-only job.
+func StartDistributed(jobName string, files []string, nReduce int,
+	mapF func(string) []KeyValue, reduceF func(string, []string) string, opts ...Option) *JobResult {
+
+	start := time.Now()
+	res := &JobResult{Job: jobName}
+	m := NewMaster(opts...)
+	if err := m.Serve(); err != nil {
+		res.Err = fmt.Errorf("master failed to start: %w", err)
+		return res
+	}
+	defer m.Shutdown()
+
+	// Wait for all tasks to complete, then merge the results
+	cfg := newConfig(opts)
+	job, err := m.runJob(jobName, files, nReduce, cfg)
+	if job != nil {
+		res.Counters = job.counters.Snapshot()
+	}
+	res.Duration = time.Since(start)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	res.Output = AnsName(jobName)
+	if len(cfg.outputs) > 0 {
+		res.Outputs = make(map[string]string)
+		for _, output := range cfg.outputs {
+			res.Outputs[output] = OutputName(jobName, output)
+		}
+	}
+	if cfg.outputFormat != "" && cfg.outputFormat != "jsonl" {
+		res.Export = ExportName(jobName, cfg.outputFormat)
+	}
+	return res
+}
+
+// StartDistributedPipeline runs a pipeline on the distributed master, then
+// shuts it down like StartDistributed. The functions of its stages are
+// registered as apps, see Pipeline.Register.
+func StartDistributedPipeline(p *Pipeline) error {
+	m := NewMaster(p.Options...)
+	if err := m.Serve(); err != nil {
+		return fmt.Errorf("master failed to start: %w", err)
+	}
+	defer m.Shutdown()
+	p.Register()
+	return p.Run(m.runStage)
+}
+
+// runStage is the StageRunner of the distributed master.
+func (m *Master) runStage(jobName string, files []string, nReduce int,
+	mapF func(string) []KeyValue, reduceF func(string, []string) string, opts ...Option) error {
+	return m.RunJob(jobName, files, nReduce, append(opts, WithApp(jobName))...)
 }
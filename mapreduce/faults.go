@@ -0,0 +1,160 @@
+package mapreduce
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultFaultDelay is how long a worker waits before running a task when
+// it injects a delay, see Faults.
+const DefaultFaultDelay = 5 * time.Second
+
+// Faults are the faults workers inject, to check that jobs survive them.
+// Each fault happens with its probability, between 0 and 1. The zero
+// Faults, the default, injects none.
+type Faults struct {
+	// Seed of the random choices of the workers, each worker mixing in its
+	// ID. Workers pick a seed at random when it is 0.
+	Seed int64
+	// CrashBeforeOutput is the probability that a worker crashes when it
+	// gets a task, and CrashAfterOutput that it crashes once it wrote the
+	// outputs of a task, before reporting it. A crashed worker loses its
+	// tasks and joins the master again with a new session.
+	CrashBeforeOutput float64
+	CrashAfterOutput  float64
+	// Delay is the probability that a worker waits DelayDuration
+	// (DefaultFaultDelay when 0) before running a task.
+	Delay         float64
+	DelayDuration time.Duration
+	// CorruptIntermediate is the probability that a map task corrupts one
+	// of its intermediate files after writing it.
+	CorruptIntermediate float64
+	// DropReply is the probability that the reply to an RPC call of a
+	// worker is lost after the master handled the call. The worker then
+	// calls again.
+	DropReply float64
+	// DuplicateReport is the probability that a worker reports a task done
+	// twice.
+	DuplicateReport float64
+}
+
+// WithFaults makes workers inject faults, see Faults.
+func WithFaults(f Faults) Option {
+	return func(c *config) {
+		c.faults = f
+	}
+}
+
+// ParseFaults reads Faults from comma-separated key=value pairs, e.g.
+// "seed=1,crash-before=0.1,delay=0.2,delay-duration=2s". The keys are seed,
+// crash-before, crash-after, delay, delay-duration, corrupt, drop-reply
+// and duplicate-report.
+func ParseFaults(s string) (Faults, error) {
+	var f Faults
+	probabilities := map[string]*float64{
+		"crash-before":     &f.CrashBeforeOutput,
+		"crash-after":      &f.CrashAfterOutput,
+		"delay":            &f.Delay,
+		"corrupt":          &f.CorruptIntermediate,
+		"drop-reply":       &f.DropReply,
+		"duplicate-report": &f.DuplicateReport,
+	}
+	for _, pair := range strings.Split(s, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return Faults{}, fmt.Errorf("fault %q: missing value", pair)
+		}
+		var err error
+		switch key {
+		case "seed":
+			f.Seed, err = strconv.ParseInt(value, 10, 64)
+		case "delay-duration":
+			f.DelayDuration, err = time.ParseDuration(value)
+		default:
+			p, ok := probabilities[key]
+			if !ok {
+				return Faults{}, fmt.Errorf("unknown fault %q", key)
+			}
+			if *p, err = strconv.ParseFloat(value, 64); err == nil && (*p < 0 || *p > 1) {
+				err = fmt.Errorf("probability out of [0, 1]")
+			}
+		}
+		if err != nil {
+			return Faults{}, fmt.Errorf("fault %s: %v", key, err)
+		}
+	}
+	return f, nil
+}
+
+// errCrashed ends the session of a worker crashing, see Faults.
+var errCrashed = errors.New("injected crash")
+
+// sessionEnd returns errCrashed when the session of a worker, whose context
+// is ctx, ended with a crash, and nil when the worker was stopped.
+func sessionEnd(ctx context.Context) error {
+	if errors.Is(context.Cause(ctx), errCrashed) {
+		return errCrashed
+	}
+	return nil
+}
+
+// faultInjector draws the faults of a worker.
+type faultInjector struct {
+	Faults
+	mu  sync.Mutex
+	rng *rand.Rand
+}
+
+// newFaultInjector returns the fault injector of a worker.
+func newFaultInjector(f Faults, workerID string) *faultInjector {
+	seed := f.Seed
+	if seed == 0 {
+		seed = rand.Int63()
+	}
+	if f.DelayDuration == 0 {
+		f.DelayDuration = DefaultFaultDelay
+	}
+	return &faultInjector{Faults: f, rng: rand.New(rand.NewSource(seed ^ int64(ihash(workerID))))}
+}
+
+// happens reports whether a fault of probability p happens now.
+func (fi *faultInjector) happens(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	return fi.rng.Float64() < p
+}
+
+// intn returns a random number in [0, n).
+func (fi *faultInjector) intn(n int) int {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	return fi.rng.Intn(n)
+}
+
+// corrupt overwrites one of the intermediate files of a map task.
+func (fi *faultInjector) corrupt(store Storage, task Task) error {
+	name := ReduceName(task.JobName, task.MapNum, fi.intn(task.NReduce))
+	log.Printf("Corrupting intermediate file %s\n", name)
+	f, err := store.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write([]byte("\x00corrupted by fault injection\x00")); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
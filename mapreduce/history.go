@@ -0,0 +1,240 @@
+package mapreduce
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultHistoryDir is where the master writes the history of the jobs it
+// runs, relative to their storage, unless set with WithHistoryDir.
+const DefaultHistoryDir = "history"
+
+// Types of the events of a job history.
+const (
+	EventJobSubmitted = "job-submitted"
+	EventJobCompleted = "job-completed"
+	EventTaskAssigned = "task-assigned"
+	EventTaskTimeout  = "task-timeout"
+	EventTaskComplete = "task-completed"
+	EventTaskFailed   = "task-failed"
+	EventWorkerJoined = "worker-joined"
+	EventWorkerLost   = "worker-lost" // its task timed out
+)
+
+// Event is a scheduling event recorded in the history of a job, one JSON
+// object per line.
+type Event struct {
+	Time     time.Time        `json:"Time"`
+	Type     string           `json:"Type"`
+	Job      string           `json:"Job"`
+	Task     *EventTask       `json:"Task,omitempty"`
+	Worker   string           `json:"Worker,omitempty"`
+	Error    string           `json:"Error,omitempty"`
+	Config   *JobConfig       `json:"Config,omitempty"`   // job-submitted only
+	Counters map[string]int64 `json:"Counters,omitempty"` // task-completed and job-completed
+}
+
+// EventTask identifies the attempt of a task an event is about.
+type EventTask struct {
+	ID      int    `json:"ID"`
+	Type    string `json:"Type"`
+	File    string `json:"File"`
+	Attempt int    `json:"Attempt"`
+}
+
+// JobConfig is the configuration of a job as recorded in its history.
+type JobConfig struct {
+	Inputs  []string `json:"Inputs"` // resolved input files
+	NReduce int      `json:"NReduce"`
+	Outputs []string `json:"Outputs,omitempty"`
+	App     string   `json:"App,omitempty"`
+}
+
+// HistoryName is the name of the history file of a job in dir.
+func HistoryName(dir, jobName string) string {
+	return path.Join(dir, jobName+".jsonl")
+}
+
+// WithHistoryDir makes the master write the history of the job in dir of
+// its storage instead of DefaultHistoryDir. An empty dir disables it.
+func WithHistoryDir(dir string) Option {
+	return func(c *config) {
+		c.historyDir = dir
+	}
+}
+
+// MaxJobEvents bounds the events of a job the master keeps in memory for
+// the jobs API, see Master.JobEvents. Older ones are only in the history
+// file of the job.
+const MaxJobEvents = 10000
+
+// historyLog keeps the last events of a job, for the jobs API, and appends
+// them all to its history file unless the history is disabled.
+type historyLog struct {
+	events  []Event
+	dropped int            // events recorded before events[0]
+	w       io.WriteCloser // nil when disabled
+	enc     *json.Encoder
+}
+
+func newHistoryLog(store Storage, dir, jobName string) (*historyLog, error) {
+	if dir == "" {
+		return &historyLog{}, nil
+	}
+	w, err := store.Create(HistoryName(dir, jobName))
+	if err != nil {
+		return nil, err
+	}
+	return &historyLog{w: w, enc: json.NewEncoder(w)}, nil
+}
+
+// record keeps e and writes it, the history file being best effort.
+func (h *historyLog) record(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	h.events = append(h.events, e)
+	if len(h.events) > MaxJobEvents {
+		// Drop the oldest half at once rather than one event at a time
+		n := len(h.events) - MaxJobEvents/2
+		h.events = append([]Event(nil), h.events[n:]...)
+		h.dropped += n
+	}
+	if h.w == nil {
+		return
+	}
+	if err := h.enc.Encode(e); err != nil {
+		log.Printf("Cannot record %s event of job %s: %v\n", e.Type, e.Job, err)
+	}
+}
+
+func (h *historyLog) close() {
+	if h.w == nil {
+		return
+	}
+	if err := h.w.Close(); err != nil {
+		log.Printf("Cannot write job history: %v\n", err)
+	}
+}
+
+// JobHistory is the history of a job read back from its file.
+type JobHistory struct {
+	Name      string           `json:"Name"`
+	Status    string           `json:"Status"` // "completed", "cancelled" or "incomplete"
+	Submitted time.Time        `json:"Submitted"`
+	Finished  time.Time        `json:"Finished"` // zero if incomplete
+	Config    *JobConfig       `json:"Config,omitempty"`
+	Counters  map[string]int64 `json:"Counters,omitempty"`
+	Events    []Event          `json:"Events,omitempty"`
+}
+
+// ReadHistory reads the history of a job from dir of store. Events after a
+// truncated line, e.g. when the master died while writing it, are lost.
+func ReadHistory(store Storage, dir, jobName string) (*JobHistory, error) {
+	r, err := store.Open(HistoryName(dir, jobName))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	h := &JobHistory{Name: jobName, Status: "incomplete"}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<24)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			break
+		}
+		switch e.Type {
+		case EventJobSubmitted:
+			h.Submitted = e.Time
+			h.Config = e.Config
+		case EventJobCompleted:
+			h.Status = "completed"
+			h.Finished = e.Time
+			h.Counters = e.Counters
+		case EventJobCancelled:
+			h.Status = "cancelled"
+			h.Finished = e.Time
+			h.Counters = e.Counters
+		case EventJobFailed:
+			h.Status = "failed"
+			h.Finished = e.Time
+			h.Counters = e.Counters
+		}
+		h.Events = append(h.Events, e)
+	}
+	return h, scanner.Err()
+}
+
+// ListHistory returns the histories found in dir of store, without their
+// events, in the order the jobs were submitted.
+func ListHistory(store Storage, dir string) ([]JobHistory, error) {
+	names, err := store.List(dir + "/")
+	if err != nil {
+		return nil, err
+	}
+	var res []JobHistory
+	for _, name := range names {
+		jobName, ok := strings.CutSuffix(path.Base(name), ".jsonl")
+		if !ok || name != HistoryName(dir, jobName) {
+			continue
+		}
+		h, err := ReadHistory(store, dir, jobName)
+		if err != nil {
+			return nil, err
+		}
+		h.Events = nil
+		res = append(res, *h)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].Submitted.Before(res[j].Submitted)
+	})
+	return res, nil
+}
+
+// HistoryHandler serves the history UI of the jobs recorded in dir of
+// store, with the JSON it is built from at /jobs and /jobs/{name}.
+func HistoryHandler(store Storage, dir string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/{$}", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFileFS(w, r, uiFiles, "ui/history.html")
+	})
+	mux.Handle("/static/", staticHandler())
+	mux.HandleFunc("/jobs", func(w http.ResponseWriter, r *http.Request) {
+		jobs, err := ListHistory(store, dir)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Cannot list jobs: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if jobs == nil {
+			jobs = []JobHistory{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(jobs)
+	})
+	mux.HandleFunc("/jobs/{name}", func(w http.ResponseWriter, r *http.Request) {
+		h, err := ReadHistory(store, dir, r.PathValue("name"))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Cannot read job history: %v", err), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h)
+	})
+	return mux
+}
+
+// ServeHistory serves HistoryHandler on addr. It only returns on error.
+func ServeHistory(addr string, store Storage, dir string) error {
+	fmt.Printf("History server listening on %s\n", addr)
+	return http.ListenAndServe(addr, HistoryHandler(store, dir))
+}
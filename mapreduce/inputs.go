@@ -0,0 +1,222 @@
+package mapreduce
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"log"
+	"path"
+	"strings"
+)
+
+// Sizer is implemented by storages that can report the size of a file
+// without reading it.
+type Sizer interface {
+	Size(name string) (int64, error)
+}
+
+// ResolveInputs expands the job inputs into the list of files to map.
+// Each input is either a file, a directory (listed recursively) or a glob
+// pattern; "**" matches any number of directories. Files found in
+// directories or through patterns are kept only if they match one of the
+// include patterns (when any) and none of the exclude patterns. Patterns
+// without a '/' are matched against the base name of the file, the others
+// against its whole name.
+func ResolveInputs(store Storage, inputs, include, exclude []string) ([]string, error) {
+	seen := make(map[string]bool)
+	var files []string
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			files = append(files, name)
+		}
+	}
+
+	for _, input := range inputs {
+		input = strings.TrimPrefix(path.Clean(input), "./")
+		var matches []string
+		if isGlob(input) {
+			names, err := store.List(globPrefix(input))
+			if err != nil {
+				return nil, err
+			}
+			for _, name := range names {
+				if matchGlob(input, name) {
+					matches = append(matches, name)
+				}
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("input %q matches no file", input)
+			}
+		} else {
+			dir := input + "/"
+			if input == "." {
+				dir = ""
+			}
+			names, err := store.List(dir)
+			if err != nil {
+				return nil, err
+			}
+			if len(names) == 0 {
+				// Not a directory: a single file, always kept
+				if _, err := inputSize(store, input); err != nil {
+					return nil, fmt.Errorf("input %q: %w", input, err)
+				}
+				add(input)
+				continue
+			}
+			matches = names
+		}
+		for _, name := range matches {
+			if isTaskScratch(name) || !filterInput(name, include, exclude) {
+				continue
+			}
+			add(name)
+		}
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no input files in %v", inputs)
+	}
+	return files, nil
+}
+
+// planInputs resolves the inputs of a job, checks that they are in its
+// input roots and logs what was found.
+func planInputs(jobName string, store Storage, inputs []string, cfg *config) ([]string, error) {
+	files, err := ResolveInputs(store, inputs, cfg.include, cfg.exclude)
+	if err != nil {
+		return nil, err
+	}
+	var total int64
+	for _, f := range files {
+		if err := checkInputPath(f, cfg.inputRoots); err != nil {
+			return nil, err
+		}
+		size, err := inputSize(store, f)
+		if err != nil {
+			return nil, err
+		}
+		log.Printf("Job %s: input %s (%d bytes)\n", jobName, f, size)
+		total += size
+	}
+	log.Printf("Job %s: %d input files, %d bytes in total\n", jobName, len(files), total)
+	return files, nil
+}
+
+// inputSize returns the size of the named file as stored (compressed).
+func inputSize(store Storage, name string) (int64, error) {
+	if s, ok := store.(Sizer); ok {
+		return s.Size(name)
+	}
+	r, err := store.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	return io.Copy(io.Discard, r)
+}
+
+// openInput opens an input file, transparently decompressing .gz and .bz2
+// files.
+func openInput(store Storage, name string) (io.ReadCloser, error) {
+	r, err := store.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return decompressInput(name, r)
+}
+
+// decompressInput decompresses r, the content of the named input file, as
+// its extension tells.
+func decompressInput(name string, r io.ReadCloser) (io.ReadCloser, error) {
+	switch {
+	case strings.HasSuffix(name, ".gz"):
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			r.Close()
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		return readCloser{gz, func() error { gz.Close(); return r.Close() }}, nil
+	case strings.HasSuffix(name, ".bz2"):
+		return readCloser{bzip2.NewReader(r), r.Close}, nil
+	}
+	return r, nil
+}
+
+type readCloser struct {
+	io.Reader
+	close func() error
+}
+
+func (rc readCloser) Close() error {
+	return rc.close()
+}
+
+// isTaskScratch tells whether name is a temporary file left by a task.
+func isTaskScratch(name string) bool {
+	return strings.Contains(path.Base(name), ".tmp-")
+}
+
+func filterInput(name string, include, exclude []string) bool {
+	for _, p := range exclude {
+		if matchFilter(p, name) {
+			return false
+		}
+	}
+	if len(include) == 0 {
+		return true
+	}
+	for _, p := range include {
+		if matchFilter(p, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchFilter(pattern, name string) bool {
+	if !strings.Contains(pattern, "/") {
+		ok, _ := path.Match(pattern, path.Base(name))
+		return ok
+	}
+	return matchGlob(pattern, name)
+}
+
+func isGlob(p string) bool {
+	return strings.ContainsAny(p, "*?[")
+}
+
+// globPrefix returns the directory part of pattern that holds no wildcard.
+func globPrefix(pattern string) string {
+	i := strings.IndexAny(pattern, "*?[")
+	j := strings.LastIndex(pattern[:i], "/")
+	return pattern[:j+1]
+}
+
+// matchGlob is path.Match extended with "**" segments, which match any
+// number of directories.
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
@@ -0,0 +1,83 @@
+package mapreduce
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// Iterative runs the same job repeatedly, each iteration reading the result
+// of the previous one, until Converged holds or MaxIterations is reached.
+// The result of the last iteration ends up in AnsName(Name).
+type Iterative struct {
+	Name    string
+	Inputs  []string // input of the first iteration
+	NReduce int
+	MapF    func(string) []KeyValue
+	ReduceF func(string, []string) string
+	Options []Option
+
+	// MaxIterations bounds the number of iterations (required).
+	MaxIterations int
+	// Converged is called after each iteration with the counters of that
+	// iteration; returning true stops the loop. It may be nil.
+	Converged func(iteration int, counters *Counters) bool
+}
+
+// IterationJobName returns the name of the job run for an iteration.
+func IterationJobName(name string, iteration int) string {
+	return name + "-iter-" + strconv.Itoa(iteration)
+}
+
+// Run runs the iterations with runner and returns how many were run.
+func (it *Iterative) Run(runner StageRunner) (int, error) {
+	if it.MaxIterations <= 0 {
+		return 0, errors.New("iterative job needs a positive MaxIterations")
+	}
+	cfg := newConfig(it.Options)
+	store := cfg.storage
+
+	inputs := it.Inputs
+	prev := ""
+	for i := 0; i < it.MaxIterations; i++ {
+		jobName := IterationJobName(it.Name, i)
+		counters := NewCounters()
+		opts := append(append([]Option(nil), it.Options...), WithCounters(counters))
+		if err := runner(jobName, inputs, it.NReduce, it.MapF, it.ReduceF, opts...); err != nil {
+			return i, fmt.Errorf("iteration %d: %w", i, err)
+		}
+
+		// Only keep the result of the last iteration
+		if files, err := ResolveInputs(store, inputs, cfg.include, cfg.exclude); err == nil {
+			CleanIntermediary(jobName, len(files), it.NReduce, opts...)
+		}
+		if prev != "" {
+			for _, name := range resultFiles(prev, cfg.outputs) {
+				store.Remove(name)
+			}
+		}
+		prev = jobName
+		inputs = []string{AnsName(jobName)}
+
+		log.Printf("Job %s: iteration %d done, counters %v\n", it.Name, i, counters.Snapshot())
+		if it.Converged != nil && it.Converged(i, counters) {
+			return i + 1, it.finish(store, prev, cfg.outputs)
+		}
+	}
+	log.Printf("Job %s: stopped after %d iterations without converging\n", it.Name, it.MaxIterations)
+	return it.MaxIterations, it.finish(store, prev, cfg.outputs)
+}
+
+// finish moves the result of the last iteration to the job result.
+func (it *Iterative) finish(store Storage, last string, outputs []string) error {
+	if err := store.Rename(AnsName(last), AnsName(it.Name)); err != nil {
+		return err
+	}
+	for _, output := range outputs {
+		if err := store.Rename(OutputName(last, output), OutputName(it.Name, output)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package mapreduce
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+)
+
+// Statuses of a job, see JobStatus.
+const (
+	JobRunning   = "running"
+	JobMerging   = "merging" // all its tasks are completed
+	JobCompleted = "completed"
+	JobCancelled = "cancelled"
+	JobFailed    = "failed"
+)
+
+// JobStatus is the state of a job of the master.
+type JobStatus struct {
+	Name      string           `json:"Name"`
+	Status    string           `json:"Status"` // JobRunning, JobMerging, ...
+	Completed int              `json:"Completed"`
+	Total     int              `json:"Total"`
+	Tasks     map[string]int   `json:"Tasks"` // number of tasks by status
+	Counters  map[string]int64 `json:"Counters"`
+	Result    string           `json:"Result,omitempty"` // merged result file, once completed
+	Error     string           `json:"Error,omitempty"`
+}
+
+// JobEvents are events of a job, as recorded in its history.
+type JobEvents struct {
+	Job    string  `json:"Job"`
+	Status string  `json:"Status"`
+	Events []Event `json:"Events"`
+	First  int     `json:"First"` // index of the first event, past the one asked for when it was dropped
+	Next   int     `json:"Next"`  // index of the event following the last one
+}
+
+// StartJob submits the job spec describes, with the storage and input roots
+// the master was created with, and merges its results once its tasks are
+// completed. It returns once the job is submitted.
+func (m *Master) StartJob(spec JobSpec) error {
+	if err := spec.Validate(); err != nil {
+		return fmt.Errorf("%w: %v", errInvalid, err)
+	}
+	opts := append([]Option{WithStorage(m.storage), WithInputRoots(m.inputRoots...)}, spec.Options()...)
+	job, err := m.submit(spec.Name, spec.Inputs, spec.NReduce, newConfig(opts), true)
+	if err != nil {
+		if !errors.Is(err, errConflict) {
+			err = fmt.Errorf("%w: %v", errInvalid, err)
+		}
+		return err
+	}
+	go func() {
+		if err := m.finish(job); err != nil {
+			log.Printf("Job %s failed: %v\n", job.name, err)
+		}
+	}()
+	return nil
+}
+
+// status returns the status of job. The caller must hold m.mu.
+func (job *distJob) status() JobStatus {
+	s := JobStatus{
+		Name:      job.name,
+		Completed: job.completed,
+		Total:     job.totalTasks,
+		Tasks:     make(map[string]int),
+		Counters:  job.counters.Snapshot(),
+		Error:     job.err,
+	}
+	for _, task := range job.tasks {
+		s.Tasks[task.Status]++
+	}
+	switch {
+	case job.err != "":
+		s.Status = JobFailed
+	case job.cancelled:
+		s.Status = JobCancelled
+	case job.running():
+		s.Status = JobRunning
+	case job.merges && !job.merged:
+		s.Status = JobMerging
+	default:
+		s.Status = JobCompleted
+	}
+	if job.merged {
+		s.Result = AnsName(job.name)
+	}
+	return s
+}
+
+// Jobs returns the status of the jobs, in the order they were submitted.
+func (m *Master) Jobs() []JobStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := make([]JobStatus, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		res = append(res, job.status())
+	}
+	return res
+}
+
+// JobStatus returns the status of a job.
+func (m *Master) JobStatus(jobName string) (JobStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job := m.job(jobName)
+	if job == nil {
+		return JobStatus{}, fmt.Errorf("job %s: %w", jobName, errNotFound)
+	}
+	return job.status(), nil
+}
+
+// JobEvents returns the events of a job from the after-th one. Only the
+// last MaxJobEvents ones, at most, are kept: the history file of the job
+// has the others.
+func (m *Master) JobEvents(jobName string, after int) (JobEvents, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job := m.job(jobName)
+	if job == nil {
+		return JobEvents{}, fmt.Errorf("job %s: %w", jobName, errNotFound)
+	}
+	events, first := job.history.events, job.history.dropped
+	after = min(max(after-first, 0), len(events))
+	return JobEvents{
+		Job:    jobName,
+		Status: job.status().Status,
+		Events: append([]Event{}, events[after:]...),
+		First:  first + after,
+		Next:   first + len(events),
+	}, nil
+}
+
+// jobsHandler serves a JSON document read by get, with the status matching
+// the error it returns.
+func jobsHandler(get func(r *http.Request) (any, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		v, err := get(r)
+		if err != nil {
+			status := http.StatusInternalServerError
+			switch {
+			case errors.Is(err, errNotFound):
+				status = http.StatusNotFound
+			case errors.Is(err, errInvalid):
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(v)
+	}
+}
+
+// handleJobs registers the jobs API on mux. Reading is open like the
+// dashboard; submitting needs the admin token like the control API.
+func (m *Master) handleJobs(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/jobs", jobsHandler(func(r *http.Request) (any, error) {
+		return m.Jobs(), nil
+	}))
+	mux.HandleFunc("GET /api/jobs/{job}", jobsHandler(func(r *http.Request) (any, error) {
+		return m.JobStatus(r.PathValue("job"))
+	}))
+	mux.HandleFunc("GET /api/jobs/{job}/events", jobsHandler(func(r *http.Request) (any, error) {
+		after := 0
+		if s := r.URL.Query().Get("after"); s != "" {
+			var err error
+			if after, err = strconv.Atoi(s); err != nil {
+				return nil, fmt.Errorf("after %q: %w", s, errInvalid)
+			}
+		}
+		return m.JobEvents(r.PathValue("job"), after)
+	}))
+	mux.HandleFunc("POST /api/jobs", m.controlHandler(func(r *http.Request) error {
+		var spec JobSpec
+		if err := json.NewDecoder(r.Body).Decode(&spec); err != nil {
+			return fmt.Errorf("job spec: %w: %v", errInvalid, err)
+		}
+		return m.StartJob(spec)
+	}))
+}
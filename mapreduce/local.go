@@ -0,0 +1,72 @@
+package mapreduce
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// localIdleWait is how long the workers of LocalParallel wait for a task,
+// e.g. for the reduce tasks while the last map tasks run.
+const localIdleWait = 10 * time.Millisecond
+
+// LocalParallel returns a StageRunner running jobs in this process on a
+// pool of nWorkers goroutines, the number of CPUs when nWorkers <= 0. Like
+// with StartDistributed, a master schedules, retries and commits the tasks,
+// but its workers call it directly: no server is started and no port is
+// used, so that it can run in unit tests.
+func LocalParallel(nWorkers int) StageRunner {
+	if nWorkers <= 0 {
+		nWorkers = runtime.NumCPU()
+	}
+	return func(jobName string, files []string, nReduce int,
+		mapF func(string) []KeyValue, reduceF func(string, []string) string, opts ...Option) error {
+
+		m := NewMaster(opts...)
+		ctx, stop := context.WithCancel(newConfig(opts).ctx)
+		workerOpts := append(opts[:len(opts):len(opts)], WithContext(ctx), WithHeartbeatInterval(localIdleWait))
+		var wg sync.WaitGroup
+		for i := range nWorkers {
+			w := NewWorker(fmt.Sprintf("local-%d", i), "", mapF, reduceF, workerOpts...)
+			w.cfg = newConfig(w.opts)
+			w.client = localMaster{m}
+			w.idleWait = localIdleWait
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := w.run(); err != nil {
+					log.Printf("Worker %s stopped: %v\n", w.id, err)
+				}
+			}()
+		}
+
+		err := m.RunJob(jobName, files, nReduce, opts...)
+		stop()
+		wg.Wait()
+		return err
+	}
+}
+
+// localMaster calls the RPC methods of a master in this process.
+type localMaster struct {
+	m *Master
+}
+
+func (l localMaster) Call(serviceMethod string, args any, reply any) error {
+	switch serviceMethod {
+	case "Master.Register":
+		return l.m.Register(args.(*RegisterArgs), reply.(*RegisterReply))
+	case "Master.GetTask":
+		return l.m.GetTask(args.(*TaskArgs), reply.(*TaskReply))
+	case "Master.ReportTaskDone":
+		return l.m.ReportTaskDone(args.(*ReportArgs), reply.(*struct{}))
+	case "Master.ReportTaskFailed":
+		return l.m.ReportTaskFailed(args.(*ReportArgs), reply.(*struct{}))
+	case "Master.Heartbeat":
+		return l.m.Heartbeat(args.(*HeartbeatArgs), reply.(*HeartbeatReply))
+	}
+	return fmt.Errorf("unknown method %s", serviceMethod)
+}
@@ -1,17 +1,22 @@
 package mapreduce
 
 import (
+	"compress/gzip"
 	"encoding/json"
+	"fmt"
 	"hash/fnv"
-	"io/ioutil"
-	"log"
-	"os"
+	"io"
 	"sort"
 	"strconv"
+	"strings"
 )
 
 const prefix = "mrtmp."
 
+// cancelCheckRecords is how many records map and reduce tasks process
+// between two checks of their context.
+const cancelCheckRecords = 1024
+
 // KeyValue is a type used to hold the key/value pairs passed to the map and
 // reduce functions.
 type KeyValue struct {
@@ -36,13 +41,22 @@ func AnsName(jobName string) string {
 }
 
 // clean all intermediary files generated for a job
-func CleanIntermediary(jobName string, nMap, nReduce int) {
+func CleanIntermediary(jobName string, nMap, nReduce int, opts ...Option) {
+	cfg := newConfig(opts)
+	store := cfg.storage
 	// Supprimer les fichiers intermédiaires produits les tâches map
 	for reduceTNbr := 0; reduceTNbr < nReduce; reduceTNbr++ {
 		for mapTNbr := 0; mapTNbr < nMap; mapTNbr++ {
-			os.Remove(ReduceName(jobName, mapTNbr, reduceTNbr))
+			store.Remove(ReduceName(jobName, mapTNbr, reduceTNbr))
+		}
+	}
+	for _, part := range resultParts(jobName, nMap, nReduce) {
+		store.Remove(part)
+	}
+	for _, output := range cfg.outputs {
+		for _, part := range outputParts(jobName, output, nMap, nReduce) {
+			store.Remove(part)
 		}
-		os.Remove(MergeName(jobName, reduceTNbr))
 	}
 }
 
@@ -54,6 +68,12 @@ func ihash(s string) uint32 {
 }
 
 // doMap applique la fonction mapF, et sauvegarde les résultats.
+// With nReduce == 0 the job is map-only: there is no shuffle and the
+// output of map task <mapTaskNumber> is its part of the final result.
+// It returns an error when it cannot run or write its outputs, e.g. when
+// the storage fails, the partitioner sends a key out of [0, nReduce) or an
+// app its settings name is not registered, and when stopped by its context,
+// see WithContext.
 // A COMPLETER
 func DoMap(
 	jobName string,
@@ -61,72 +81,288 @@ func DoMap(
 	inFile string,
 	nReduce int,
 	mapF func(contents string) []KeyValue,
-) {
-	content, err := ioutil.ReadFile(inFile)
+	opts ...Option,
+) error {
+	cfg := newConfig(opts)
+	ctx := cfg.ctx
+	store := cfg.storage
+	fail := func(format string, args ...any) error {
+		return fmt.Errorf("map task %d of job %s: %w", mapTaskNumber, jobName, fmt.Errorf(format, args...))
+	}
+	if err := checkTask(Task{Type: "map", JobName: jobName, File: inFile, Outputs: cfg.outputs}, cfg); err != nil {
+		return fail("%w", err)
+	}
+	in, err := openInput(store, inFile)
+	if err != nil {
+		return fail("cannot read file: %w", err)
+	}
+	content, err := io.ReadAll(in)
+	in.Close()
+	if err != nil {
+		return fail("cannot read file %s: %w", inFile, err)
+	}
+
+	if ctx.Err() != nil {
+		return taskCancelled(ctx, "map", jobName, mapTaskNumber)
+	}
+
+	tc, err := newTaskContext(store, jobName, "map", mapTaskNumber, cfg.outputs)
+	if err != nil {
+		return fail("cannot create outputs: %w", err)
+	}
+	tc.ctx = ctx
+	// A task stopped before committing its outputs removes what it wrote
+	var files []*taskOutput
+	committed := false
+	defer func() {
+		if !committed {
+			for _, f := range files {
+				if f != nil {
+					f.Abort()
+				}
+			}
+			tc.abort()
+		}
+	}()
+	mapTask, err := cfg.mapFunc(mapF)
 	if err != nil {
-		log.Fatalf("DoMap: cannot read file %v", err)
+		return fail("%w", err)
 	}
+	var kvs []KeyValue
+	if cfg.settings.InputFormat == InputLines {
+		lines := strings.SplitAfter(string(content), "\n")
+		for _, line := range lines {
+			if ctx.Err() != nil {
+				return taskCancelled(ctx, "map", jobName, mapTaskNumber)
+			}
+			if line = strings.TrimSuffix(line, "\n"); line != "" {
+				kvs = append(kvs, mapTask(tc, line)...)
+				tc.AddCounter(CounterMapInputRecords, 1)
+			}
+		}
+	} else {
+		kvs = mapTask(tc, string(content))
+		tc.AddCounter(CounterMapInputRecords, 1)
+	}
+	tc.AddCounter(CounterMapInputBytes, int64(len(content)))
+	tc.AddCounter(CounterMapOutputRecords, int64(len(kvs)))
 
-	kvs := mapF(string(content))
+	// Spread the records over the reduce tasks (or keep them all for the
+	// single result file of a map-only task), combining them if asked to
+	partition := func(string) (int, error) { return 0, nil }
+	if nReduce > 0 {
+		name := cfg.settings.Partitioner
+		if name == "" {
+			name = PartitionHash
+		}
+		p, ok := lookupPartitioner(name)
+		if !ok {
+			return fail("unknown partitioner %q", name)
+		}
+		partition = func(key string) (int, error) { return partitionOf(p, name, key, nReduce) }
+	}
+	if cfg.settings.Combiner != "" && nReduce > 0 {
+		_, combine, ok := appTaskFuncs(cfg.settings.Combiner)
+		if !ok {
+			return fail("unknown combiner %q", cfg.settings.Combiner)
+		}
+		kvs = combineRecords(tc, kvs, combine)
+	}
+	if nReduce > 0 {
+		tc.AddCounter(CounterSpilledRecords, int64(len(kvs)))
+	}
+	if ctx.Err() != nil {
+		return taskCancelled(ctx, "map", jobName, mapTaskNumber)
+	}
 
-	// Create encoders for each reduce file
-	files := make([]*os.File, nReduce)
-	encoders := make([]*json.Encoder, nReduce)
-	for i := 0; i < nReduce; i++ {
-		fileName := ReduceName(jobName, mapTaskNumber, i)
-		file, err := os.Create(fileName)
+	// Create encoders for each reduce file (or for the single result file
+	// of a map-only task). They are written under a temporary name and
+	// renamed once complete.
+	fileNames := make([]string, nReduce)
+	for i := range fileNames {
+		fileNames[i] = ReduceName(jobName, mapTaskNumber, i)
+	}
+	if nReduce == 0 {
+		fileNames = []string{MergeName(jobName, mapTaskNumber)}
+	}
+	files = make([]*taskOutput, len(fileNames))
+	writers := make([]*gzip.Writer, len(fileNames))
+	encoders := make([]*json.Encoder, len(fileNames))
+	for i, fileName := range fileNames {
+		file, err := createTaskOutput(store, fileName)
 		if err != nil {
-			log.Fatalf("DoMap: cannot create file %s: %v", fileName, err)
+			return fail("cannot create file %s: %w", fileName, err)
 		}
 		files[i] = file
 		encoders[i] = json.NewEncoder(file)
+		if nReduce > 0 && cfg.settings.Compression == CompressionGzip {
+			writers[i] = gzip.NewWriter(file)
+			encoders[i] = json.NewEncoder(writers[i])
+		}
 	}
 
-	for _, kv := range kvs {
-		r := int(ihash(kv.Key)) % nReduce
-		err := encoders[r].Encode(&kv)
+	for i, kv := range kvs {
+		if i%cancelCheckRecords == 0 && ctx.Err() != nil {
+			return taskCancelled(ctx, "map", jobName, mapTaskNumber)
+		}
+		p, err := partition(kv.Key)
+		if err != nil {
+			return fail("%w", err)
+		}
+		err = encoders[p].Encode(&kv)
 		if err != nil {
-			log.Fatalf("DoMap: encode error: %v", err)
+			return fail("encode error: %w", err)
 		}
 	}
+	if ctx.Err() != nil {
+		return taskCancelled(ctx, "map", jobName, mapTaskNumber)
+	}
+
+	for i, f := range files {
+		if writers[i] != nil {
+			if err := writers[i].Close(); err != nil {
+				return fail("cannot compress file %s: %w", f.name, err)
+			}
+		}
+		if err := f.Commit(); err != nil {
+			return fail("cannot write file %s: %w", f.name, err)
+		}
+	}
+	committed = true
+	if err := tc.commit(cfg.counters); err != nil {
+		return fail("cannot write outputs: %w", err)
+	}
+	return nil
+}
 
-	for _, f := range files {
-		f.Close()
+// combineRecords groups kvs by key, replacing the values of each key with
+// the result of combine on them, in the order the keys first appear.
+func combineRecords(tc *TaskContext, kvs []KeyValue, combine ReduceTaskFunc) []KeyValue {
+	var keys []string
+	groups := make(map[string][]string)
+	for _, kv := range kvs {
+		if _, ok := groups[kv.Key]; !ok {
+			keys = append(keys, kv.Key)
+		}
+		groups[kv.Key] = append(groups[kv.Key], kv.Value)
 	}
+	res := make([]KeyValue, 0, len(keys))
+	for _, key := range keys {
+		res = append(res, KeyValue{Key: key, Value: combine(tc, key, groups[key])})
+	}
+	tc.AddCounter(CounterCombineInputRecords, int64(len(kvs)))
+	tc.AddCounter(CounterCombineOutputRecords, int64(len(res)))
+	return res
+}
+
+// IntermediateError reports an intermediate file a reduce task cannot read,
+// e.g. because it is missing or corrupted. The map task writing it must run
+// again.
+type IntermediateError struct {
+	File    string
+	MapTask int
+	Err     error
+}
+
+func (e *IntermediateError) Error() string {
+	return fmt.Sprintf("cannot read intermediate file %s: %v", e.File, e.Err)
+}
+
+func (e *IntermediateError) Unwrap() error {
+	return e.Err
 }
 
 // doReduce effectue une tâche de réduction en lisant les fichiers
 // intermédiaires, en regroupant les valeurs par clé, et en appliquant
 // la fonction reduceF.
+// It returns an error when it cannot run or write its output, e.g. when the
+// storage fails or the reduce app its settings name is not registered, when
+// it cannot read an intermediate file, see IntermediateError, and when
+// stopped by its context, see WithContext.
 // A COMPLETER
 func DoReduce(
 	jobName string,
 	reduceTaskNumber int,
 	nMap int,
 	reduceF func(key string, values []string) string,
-) {
+	opts ...Option,
+) error {
+	cfg := newConfig(opts)
+	ctx := cfg.ctx
+	store := cfg.storage
+	fail := func(format string, args ...any) error {
+		return fmt.Errorf("reduce task %d of job %s: %w", reduceTaskNumber, jobName, fmt.Errorf(format, args...))
+	}
+	if err := checkTask(Task{Type: "reduce", JobName: jobName, Outputs: cfg.outputs}, cfg); err != nil {
+		return fail("%w", err)
+	}
 	keyGroups := make(map[string][]string)
+	var records, shuffleBytes int64
 
 	// Read intermediate files
 	for i := 0; i < nMap; i++ {
+		if ctx.Err() != nil {
+			return taskCancelled(ctx, "reduce", jobName, reduceTaskNumber)
+		}
 		fileName := ReduceName(jobName, i, reduceTaskNumber)
-		file, err := os.Open(fileName)
+		file, err := store.Open(fileName)
 		if err != nil {
-			log.Fatalf("DoReduce: cannot open %s: %v", fileName, err)
+			return &IntermediateError{File: fileName, MapTask: i, Err: err}
 		}
-		decoder := json.NewDecoder(file)
-		var kv KeyValue
-		for decoder.Decode(&kv) == nil {
+		in := &countingReader{r: file}
+		var r io.Reader = in
+		if cfg.settings.Compression == CompressionGzip {
+			if r, err = gzip.NewReader(in); err != nil {
+				file.Close()
+				return &IntermediateError{File: fileName, MapTask: i, Err: err}
+			}
+		}
+		decoder := json.NewDecoder(r)
+		for {
+			var kv KeyValue
+			if err := decoder.Decode(&kv); err == io.EOF {
+				break
+			} else if err != nil {
+				file.Close()
+				return &IntermediateError{File: fileName, MapTask: i, Err: err}
+			}
 			keyGroups[kv.Key] = append(keyGroups[kv.Key], kv.Value)
+			records++
 		}
 		file.Close()
+		shuffleBytes += in.n
+	}
+
+	tc, err := newTaskContext(store, jobName, "reduce", reduceTaskNumber, cfg.outputs)
+	if err != nil {
+		return fail("cannot create outputs: %w", err)
+	}
+	tc.ctx = ctx
+	// A task stopped before committing its outputs removes what it wrote
+	var outFile *taskOutput
+	committed := false
+	defer func() {
+		if !committed {
+			if outFile != nil {
+				outFile.Abort()
+			}
+			tc.abort()
+		}
+	}()
+	tc.AddCounter(CounterShuffleBytes, shuffleBytes)
+	tc.AddCounter(CounterReduceInputRecords, records)
+	tc.AddCounter(CounterReduceInputGroups, int64(len(keyGroups)))
+	tc.AddCounter(CounterReduceOutputRecords, int64(len(keyGroups)))
+	reduce, err := cfg.reduceFunc(reduceF)
+	if err != nil {
+		return fail("%w", err)
 	}
 
 	// Open output file
 	outFileName := MergeName(jobName, reduceTaskNumber)
-	outFile, err := os.Create(outFileName)
+	outFile, err = createTaskOutput(store, outFileName)
 	if err != nil {
-		log.Fatalf("DoReduce: cannot create %s: %v", outFileName, err)
+		return fail("cannot create %s: %w", outFileName, err)
 	}
 	encoder := json.NewEncoder(outFile)
 
@@ -137,9 +373,24 @@ func DoReduce(
 	}
 	sort.Strings(keys)
 
-	for _, k := range keys {
-		result := reduceF(k, keyGroups[k])
-		encoder.Encode(&KeyValue{Key: k, Value: result})
+	for i, k := range keys {
+		if i%cancelCheckRecords == 0 && ctx.Err() != nil {
+			return taskCancelled(ctx, "reduce", jobName, reduceTaskNumber)
+		}
+		result := reduce(tc, k, keyGroups[k])
+		if err := encoder.Encode(&KeyValue{Key: k, Value: result}); err != nil {
+			return fail("encode error: %w", err)
+		}
+	}
+	if ctx.Err() != nil {
+		return taskCancelled(ctx, "reduce", jobName, reduceTaskNumber)
+	}
+	if err := outFile.Commit(); err != nil {
+		return fail("cannot write %s: %w", outFileName, err)
+	}
+	committed = true
+	if err := tc.commit(cfg.counters); err != nil {
+		return fail("cannot write outputs: %w", err)
 	}
-	outFile.Close()
+	return nil
 }
@@ -1,19 +1,58 @@
 package mapreduce
 
+import (
+	"fmt"
+	"log"
+)
+
 // Sequential runs map and reduce tasks sequentially, waiting for each task to
-// complete before scheduling the next.
+// complete before scheduling the next. files may hold directories and glob
+// patterns, see ResolveInputs. With nReduce == 0 the job is map-only.
+// It returns an error when stopped by its context, see WithContext, having
+// removed the files of the job, or when the job fails: its configuration or
+// inputs are invalid, a counter is over its limit, see WithCounterLimits, or
+// the results cannot be merged or exported.
 func Sequential(jobName string, files []string, nReduce int,
 	mapF func(string) []KeyValue,
 	reduceF func(string, []string) string,
-) {
+	opts ...Option,
+) error {
+	cfg := newConfig(opts)
+	if err := cfg.check(jobName); err != nil {
+		return err
+	}
+	files, err := planInputs(jobName, cfg.storage, files, cfg)
+	if err != nil {
+		return err
+	}
+
+	counters := cfg.counters
+	if counters == nil {
+		counters = NewCounters()
+		opts = append(opts[:len(opts):len(opts)], WithCounters(counters))
+	}
+
 	for i, f := range files {
-		DoMap(jobName, i, f, nReduce, mapF)
+		if err := DoMap(jobName, i, f, nReduce, mapF, opts...); err != nil {
+			CleanIntermediary(jobName, len(files), nReduce, opts...)
+			return err
+		}
 	}
-	resFiles := []string{}
 	for i := 0; i < nReduce; i++ {
-		DoReduce(jobName, i, len(files), reduceF)
-		resFiles=append(resFiles, MergeName(jobName,i))
+		if err := DoReduce(jobName, i, len(files), reduceF, opts...); err != nil {
+			CleanIntermediary(jobName, len(files), nReduce, opts...)
+			return err
+		}
+	}
+	if err := checkCounterLimits(counters.Snapshot(), cfg.counterLimits); err != nil {
+		return fmt.Errorf("job %s failed: %v", jobName, err)
+	}
+	if err := mergeResults(cfg.storage, jobName, len(files), nReduce, cfg.outputs); err != nil {
+		return fmt.Errorf("job %s: cannot merge results: %w", jobName, err)
+	}
+	if err := exportResult(jobName, cfg); err != nil {
+		return fmt.Errorf("job %s: cannot export results: %w", jobName, err)
 	}
-	concatFiles(AnsName(jobName),resFiles)
-	return 
+	log.Printf("Job %s counters: %v\n", jobName, counters)
+	return nil
 }
@@ -0,0 +1,280 @@
+package mapreduce
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// This file implements the little of the Prometheus text exposition format
+// the master and the workers need: counters and histograms with labels,
+// plus gauges computed when the metrics are scraped.
+
+// defaultBuckets are the upper bounds, in seconds, of duration histograms.
+var defaultBuckets = []float64{0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// label is a metric label.
+type label struct {
+	name, value string
+}
+
+func formatLabels(labels []label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	parts := make([]string, len(labels))
+	for i, l := range labels {
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(l.value)
+		parts[i] = l.name + `="` + v + `"`
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func writeHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func writeSample(w io.Writer, name string, labels []label, v float64) {
+	fmt.Fprintf(w, "%s%s %s\n", name, formatLabels(labels), formatValue(v))
+}
+
+// gaugeSample is a sample of a gauge computed at scrape time.
+type gaugeSample struct {
+	labels []label
+	value  float64
+}
+
+func writeGauge(w io.Writer, name, help string, samples []gaugeSample) {
+	writeHeader(w, name, help, "gauge")
+	for _, s := range samples {
+		writeSample(w, name, s.labels, s.value)
+	}
+}
+
+// metricDesc describes a metric partitioned by label values. Series are
+// keyed by their label values joined with \xff.
+type metricDesc struct {
+	name, help string
+	labels     []string
+	mu         sync.Mutex
+}
+
+func (d *metricDesc) labelsOf(key string) []label {
+	if len(d.labels) == 0 {
+		return nil
+	}
+	values := strings.Split(key, "\xff")
+	labels := make([]label, len(d.labels))
+	for i, name := range d.labels {
+		labels[i] = label{name, values[i]}
+	}
+	return labels
+}
+
+// counterVec is a counter partitioned by label values.
+type counterVec struct {
+	metricDesc
+	values map[string]float64
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{
+		metricDesc: metricDesc{name: name, help: help, labels: labels},
+		values:     make(map[string]float64),
+	}
+}
+
+// Add adds delta to the counter with the given label values.
+func (c *counterVec) Add(delta float64, values ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.values[strings.Join(values, "\xff")] += delta
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	writeHeader(w, c.name, c.help, "counter")
+	for _, key := range sortedKeys(c.values) {
+		writeSample(w, c.name, c.labelsOf(key), c.values[key])
+	}
+}
+
+// histogramVec is a histogram partitioned by label values.
+type histogramVec struct {
+	metricDesc
+	buckets []float64
+	series  map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+	count  uint64
+}
+
+func newHistogramVec(name, help string, labels ...string) *histogramVec {
+	return &histogramVec{
+		metricDesc: metricDesc{name: name, help: help, labels: labels},
+		buckets:    defaultBuckets,
+		series:     make(map[string]*histogram),
+	}
+}
+
+// Observe records v in the histogram with the given label values.
+func (h *histogramVec) Observe(v float64, values ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := strings.Join(values, "\xff")
+	s := h.series[key]
+	if s == nil {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.sum += v
+	s.count++
+}
+
+// ObserveSince records the time elapsed since start, in seconds.
+func (h *histogramVec) ObserveSince(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	writeHeader(w, h.name, h.help, "histogram")
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		labels := h.labelsOf(key)
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += s.counts[i]
+			writeSample(w, h.name+"_bucket", append(labels[:len(labels):len(labels)], label{"le", formatValue(le)}), float64(cumulative))
+		}
+		writeSample(w, h.name+"_bucket", append(labels[:len(labels):len(labels)], label{"le", "+Inf"}), float64(s.count))
+		writeSample(w, h.name+"_sum", labels, s.sum)
+		writeSample(w, h.name+"_count", labels, float64(s.count))
+	}
+}
+
+// masterMetrics are the metrics the master keeps track of; the others are
+// computed from its state when scraped.
+type masterMetrics struct {
+	taskDuration  *histogramVec
+	timeouts      *counterVec
+	reassignments *counterVec
+	rpcDuration   *histogramVec
+	rejected      *counterVec
+}
+
+func newMasterMetrics() *masterMetrics {
+	return &masterMetrics{
+		taskDuration: newHistogramVec("mapreduce_task_duration_seconds",
+			"Duration of the task attempts that completed their task.", "type"),
+		timeouts: newCounterVec("mapreduce_task_timeouts_total",
+			"Task attempts that timed out.", "type"),
+		reassignments: newCounterVec("mapreduce_task_reassignments_total",
+			"Tasks assigned again after a failed attempt.", "type"),
+		rpcDuration: newHistogramVec("mapreduce_rpc_duration_seconds",
+			"Time spent handling worker RPCs.", "method"),
+		rejected: newCounterVec("mapreduce_rpc_rejected_total",
+			"Worker RPCs rejected for lack of authentication.", "method"),
+	}
+}
+
+// metricsHandler serves the master metrics in the Prometheus text format.
+func (m *Master) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	m.mu.Lock()
+	var tasks, progress []gaugeSample
+	for _, job := range m.jobs {
+		counts := make(map[[2]string]int)
+		for _, task := range job.tasks {
+			counts[[2]string{task.Type, task.Status}]++
+		}
+		for _, typ := range []string{"map", "reduce"} {
+			for _, status := range []string{"pending", "in-progress", "completed", "cancelled"} {
+				tasks = append(tasks, gaugeSample{
+					labels: []label{{"job", job.name}, {"type", typ}, {"status", status}},
+					value:  float64(counts[[2]string{typ, status}]),
+				})
+			}
+		}
+		ratio := 1.0
+		if job.totalTasks > 0 {
+			ratio = float64(job.completed) / float64(job.totalTasks)
+		}
+		progress = append(progress, gaugeSample{labels: []label{{"job", job.name}}, value: ratio})
+	}
+	workerCounts := make(map[string]int)
+	for _, status := range m.workers {
+		workerCounts[status]++
+	}
+	m.mu.Unlock()
+
+	var workers []gaugeSample
+	for _, status := range sortedKeys(workerCounts) {
+		workers = append(workers, gaugeSample{labels: []label{{"status", status}}, value: float64(workerCounts[status])})
+	}
+
+	writeGauge(w, "mapreduce_tasks", "Tasks by job, type and status.", tasks)
+	writeGauge(w, "mapreduce_job_progress_ratio", "Fraction of the tasks of a job that are completed.", progress)
+	writeGauge(w, "mapreduce_workers", "Workers by status.", workers)
+	m.metrics.taskDuration.write(w)
+	m.metrics.timeouts.write(w)
+	m.metrics.reassignments.write(w)
+	m.metrics.rejected.write(w)
+	m.metrics.rpcDuration.write(w)
+}
+
+// workerMetrics are shared by all the workers of the process, and labelled
+// with the worker ID.
+var workerMetrics = struct {
+	tasks        *counterVec
+	taskDuration *histogramVec
+	rpcDuration  *histogramVec
+}{
+	tasks: newCounterVec("mapreduce_worker_tasks_total",
+		"Tasks run by the worker.", "worker", "type"),
+	taskDuration: newHistogramVec("mapreduce_worker_task_duration_seconds",
+		"Time spent running tasks.", "worker", "type"),
+	rpcDuration: newHistogramVec("mapreduce_worker_rpc_duration_seconds",
+		"Duration of the RPCs to the master.", "worker", "method"),
+}
+
+// WorkerMetricsHandler serves the metrics of the workers running in this
+// process in the Prometheus text format.
+func WorkerMetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		workerMetrics.tasks.write(w)
+		workerMetrics.taskDuration.write(w)
+		workerMetrics.rpcDuration.write(w)
+	})
+}
+
+// ServeWorkerMetrics serves WorkerMetricsHandler at /metrics on addr. It
+// only returns on error.
+func ServeWorkerMetrics(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", WorkerMetricsHandler())
+	return http.ListenAndServe(addr, mux)
+}
@@ -0,0 +1,175 @@
+package mapreduce
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/fs"
+	"path"
+	"time"
+)
+
+// Option configures optional behaviour of the job entry points (Sequential,
+// StartDistributed, DoMap, DoReduce) and of workers.
+type Option func(*config)
+
+// config holds the settings collected from a list of Options.
+type config struct {
+	ctx        context.Context
+	storage    Storage
+	include    []string
+	exclude    []string
+	outputs    []string
+	app        string
+	counters   *Counters
+	mapTask    MapTaskFunc
+	reduceTask ReduceTaskFunc
+	historyDir string
+	inputRoots []string
+	settings   TaskSettings
+	// job settings applied by the master, and by Sequential when relevant
+	taskTimeout   time.Duration
+	maxAttempts   int
+	counterLimits map[string]int64
+	outputFormat  string
+	// master settings, and tlsConfig and clusterToken for workers as well
+	adminToken    string
+	clusterToken  string
+	sessionIdle   time.Duration
+	rpcAddr       string
+	dashboardAddr string
+	tlsConfig     *tls.Config
+	onServe       func(rpcAddr, dashboardAddr string)
+	// worker settings
+	heartbeatInterval time.Duration
+	masterAddrs       []string
+	reconnectTimeout  time.Duration
+	slots             Slots
+	faults            Faults
+}
+
+func newConfig(opts []Option) *config {
+	c := &config{
+		ctx:           context.Background(),
+		storage:       DefaultStorage,
+		historyDir:    DefaultHistoryDir,
+		sessionIdle:   SessionIdleTimeout,
+		rpcAddr:       DefaultRPCAddr,
+		dashboardAddr: DefaultDashboardAddr,
+
+		heartbeatInterval: DefaultHeartbeatInterval,
+		reconnectTimeout:  DefaultReconnectTimeout,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// check reports the settings that cannot be used for job jobName.
+func (c *config) check(jobName string) error {
+	if err := checkJobName(jobName); err != nil {
+		return err
+	}
+	for _, root := range c.inputRoots {
+		if !fs.ValidPath(path.Clean(root)) {
+			return fmt.Errorf("input root %q is outside of the storage", root)
+		}
+	}
+	if err := c.checkJobSettings(); err != nil {
+		return err
+	}
+	for _, output := range c.outputs {
+		if !validOutputName(output) {
+			return fmt.Errorf("invalid output name %q", output)
+		}
+	}
+	return nil
+}
+
+// mapFunc returns the map function to use, given the one passed to the
+// entry point. The app named by the settings, if any, must be registered.
+func (c *config) mapFunc(mapF func(string) []KeyValue) (MapTaskFunc, error) {
+	if c.settings.Map != "" {
+		f, _, ok := appTaskFuncs(c.settings.Map)
+		if !ok {
+			return nil, fmt.Errorf("unknown map app %q", c.settings.Map)
+		}
+		return f, nil
+	}
+	if c.mapTask != nil {
+		return c.mapTask, nil
+	}
+	return func(_ *TaskContext, contents string) []KeyValue {
+		return mapF(contents)
+	}, nil
+}
+
+// reduceFunc returns the reduce function to use, given the one passed to
+// the entry point. The app named by the settings, if any, must be
+// registered.
+func (c *config) reduceFunc(reduceF func(string, []string) string) (ReduceTaskFunc, error) {
+	if c.settings.Reduce != "" {
+		_, f, ok := appTaskFuncs(c.settings.Reduce)
+		if !ok {
+			return nil, fmt.Errorf("unknown reduce app %q", c.settings.Reduce)
+		}
+		return f, nil
+	}
+	if c.reduceTask != nil {
+		return c.reduceTask, nil
+	}
+	return func(_ *TaskContext, key string, values []string) string {
+		return reduceF(key, values)
+	}, nil
+}
+
+// WithStorage makes the job read its inputs and write its intermediate and
+// output files through s instead of DefaultStorage.
+func WithStorage(s Storage) Option {
+	return func(c *config) {
+		c.storage = s
+	}
+}
+
+// WithInputFilter restricts the files picked from input directories and
+// glob patterns to those matching one of include (when not empty) and none
+// of exclude. See ResolveInputs.
+func WithInputFilter(include, exclude []string) Option {
+	return func(c *config) {
+		c.include = include
+		c.exclude = exclude
+	}
+}
+
+// WithNamedOutputs declares named outputs map and reduce functions can write
+// to with TaskContext.Emit. Each one becomes its own result file, see
+// OutputName.
+func WithNamedOutputs(outputs ...string) Option {
+	return func(c *config) {
+		c.outputs = append(c.outputs, outputs...)
+	}
+}
+
+// withOutputs sets the named outputs of a task received from the master.
+func withOutputs(outputs []string) Option {
+	return func(c *config) {
+		c.outputs = outputs
+	}
+}
+
+// WithMapTask replaces the map function of the job with f, which gets the
+// TaskContext of the running task.
+func WithMapTask(f MapTaskFunc) Option {
+	return func(c *config) {
+		c.mapTask = f
+	}
+}
+
+// WithReduceTask replaces the reduce function of the job with f, which gets
+// the TaskContext of the running task.
+func WithReduceTask(f ReduceTaskFunc) Option {
+	return func(c *config) {
+		c.reduceTask = f
+	}
+}
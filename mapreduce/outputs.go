@@ -0,0 +1,154 @@
+package mapreduce
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// MapTaskFunc is a map function that also receives the context of the task
+// running it, through which it can write to named outputs and update
+// counters.
+type MapTaskFunc func(tc *TaskContext, contents string) []KeyValue
+
+// ReduceTaskFunc is the TaskContext-aware counterpart of a reduce function.
+type ReduceTaskFunc func(tc *TaskContext, key string, values []string) string
+
+// TaskContext gives map and reduce functions access to the task they run in.
+type TaskContext struct {
+	JobName    string
+	TaskType   string // "map" or "reduce"
+	TaskNumber int
+
+	outputs  map[string]*taskOutput
+	encoders map[string]*json.Encoder
+	counters *Counters
+	ctx      context.Context // see Context
+}
+
+// AddCounter adds delta to the named counter of the task. The counters of
+// all the tasks of a job are summed up, see WithCounters.
+func (tc *TaskContext) AddCounter(name string, delta int64) {
+	tc.counters.Add(name, delta)
+}
+
+// Emit writes kv to the named output, declared with WithNamedOutputs. Each
+// named output ends up in its own set of result files, see OutputName.
+func (tc *TaskContext) Emit(output string, kv KeyValue) error {
+	enc, ok := tc.encoders[output]
+	if !ok {
+		return fmt.Errorf("unknown output %q", output)
+	}
+	return enc.Encode(&kv)
+}
+
+// newTaskContext creates the part files of every named output for the task.
+func newTaskContext(store Storage, jobName, taskType string, taskNumber int, outputs []string) (*TaskContext, error) {
+	tc := &TaskContext{
+		JobName:    jobName,
+		TaskType:   taskType,
+		TaskNumber: taskNumber,
+		outputs:    make(map[string]*taskOutput),
+		encoders:   make(map[string]*json.Encoder),
+		counters:   NewCounters(),
+	}
+	for _, output := range outputs {
+		f, err := createTaskOutput(store, OutputPartName(jobName, output, taskType, taskNumber))
+		if err != nil {
+			tc.abort()
+			return nil, err
+		}
+		tc.outputs[output] = f
+		tc.encoders[output] = json.NewEncoder(f)
+	}
+	return tc, nil
+}
+
+// commit publishes the named output parts written by the task, and adds
+// its counters to the job ones.
+func (tc *TaskContext) commit(jobCounters *Counters) error {
+	for _, f := range tc.outputs {
+		if err := f.Commit(); err != nil {
+			return err
+		}
+	}
+	if jobCounters != nil {
+		jobCounters.Merge(tc.counters.Snapshot())
+	}
+	return nil
+}
+
+func (tc *TaskContext) abort() {
+	for _, f := range tc.outputs {
+		f.Abort()
+	}
+}
+
+// OutputPartName constructs the name of the part of the named output
+// <output> written by map or reduce task <task>.
+func OutputPartName(jobName, output, taskType string, task int) string {
+	return prefix + jobName + "-" + output + "-" + taskType[:1] + "-" + strconv.Itoa(task)
+}
+
+// OutputName constructs the name of the file merging all the parts of the
+// named output <output>.
+func OutputName(jobName, output string) string {
+	return prefix + jobName + "-" + output
+}
+
+// validOutputName tells whether name can be used for a named output: it
+// must be made of letters, digits and underscores, start with a letter and
+// not clash with the files of the job itself.
+func validOutputName(name string) bool {
+	if name == "" || name == "res" {
+		return false
+	}
+	for i, r := range name {
+		letter := 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z'
+		if !letter && (i == 0 || r != '_' && (r < '0' || r > '9')) {
+			return false
+		}
+	}
+	return true
+}
+
+// resultParts returns the files making up the main result of a job: the
+// reduce outputs, or the map outputs for a map-only job.
+func resultParts(jobName string, nMap, nReduce int) []string {
+	n := nReduce
+	if nReduce == 0 {
+		n = nMap
+	}
+	parts := make([]string, n)
+	for i := range parts {
+		parts[i] = MergeName(jobName, i)
+	}
+	return parts
+}
+
+// outputParts returns the parts of a named output, map parts first.
+func outputParts(jobName, output string, nMap, nReduce int) []string {
+	var parts []string
+	for i := 0; i < nMap; i++ {
+		parts = append(parts, OutputPartName(jobName, output, "map", i))
+	}
+	for i := 0; i < nReduce; i++ {
+		parts = append(parts, OutputPartName(jobName, output, "reduce", i))
+	}
+	return parts
+}
+
+// mergeResults concatenates the parts of the job result and of every named
+// output into their final files.
+func mergeResults(store Storage, jobName string, nMap, nReduce int, outputs []string) error {
+	if err := concatFiles(store, AnsName(jobName), resultParts(jobName, nMap, nReduce)); err != nil {
+		return err
+	}
+	for _, output := range outputs {
+		if err := concatFiles(store, OutputName(jobName, output), outputParts(jobName, output, nMap, nReduce)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package mapreduce
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// PageRankDamping is the probability to follow a link rather than jump to a
+// random node.
+const PageRankDamping = 0.85
+
+// PageRankDeltaCounter is the counter holding the sum of the rank changes of
+// an iteration, in millionths.
+const PageRankDeltaCounter = "pagerank.delta.micros"
+
+// MapPageRank sends each node's rank, split evenly, to the nodes it links
+// to. A node without links keeps its rank, as if it linked to itself. It
+// also forwards the node's links and previous rank.
+func MapPageRank(value string) (res []KeyValue) {
+	for node, entry := range parsePageRankInput(value) {
+		links := strings.Join(entry.links, ",")
+		res = append(res, KeyValue{Key: node, Value: "links|" + links})
+		res = append(res, KeyValue{Key: node, Value: "prev|" + formatRank(entry.rank)})
+		if len(entry.links) == 0 {
+			res = append(res, KeyValue{Key: node, Value: "rank|" + formatRank(entry.rank)})
+		}
+		for _, link := range entry.links {
+			share := entry.rank / float64(len(entry.links))
+			res = append(res, KeyValue{Key: link, Value: "rank|" + formatRank(share)})
+		}
+	}
+	return
+}
+
+// ReducePageRank computes the new rank of a node and adds the change of
+// rank to PageRankDeltaCounter.
+func ReducePageRank(tc *TaskContext, key string, values []string) string {
+	sum, prev, links := 0.0, 1.0, ""
+	listed := false
+	for _, val := range values {
+		kind, v, _ := strings.Cut(val, "|")
+		switch kind {
+		case "rank":
+			share, _ := strconv.ParseFloat(v, 64)
+			sum += share
+		case "prev":
+			prev, _ = strconv.ParseFloat(v, 64)
+			listed = true
+		case "links":
+			links = v
+		}
+	}
+	// A node only found as a link target in the adjacency lists has the
+	// initial rank and no links: it keeps that rank
+	if !listed {
+		sum += prev
+	}
+	rank := 1 - PageRankDamping + PageRankDamping*sum
+	tc.AddCounter(PageRankDeltaCounter, int64(math.Abs(rank-prev)*1e6))
+	return formatRank(rank) + "|" + links
+}
+
+// PageRankConverged is a convergence check for Iterative: it holds once the
+// ranks changed by less than tolerance in total during an iteration.
+func PageRankConverged(tolerance float64) func(int, *Counters) bool {
+	return func(_ int, counters *Counters) bool {
+		return float64(counters.Get(PageRankDeltaCounter))/1e6 < tolerance
+	}
+}
+
+// NewPageRank returns the iterative job computing the rank of the nodes of
+// a graph, stopping once the ranks changed by less than tolerance or after
+// maxIterations. The inputs are adjacency lists, one node per line followed
+// by the nodes it links to:
+//
+//	A B C
+//	B C
+//
+// Every iteration outputs, for each node, its rank and its links as
+// "rank|link,link". This is also the input of the next iteration. Nodes
+// without links, like C above, keep their rank instead of sharing it.
+// Ranks are not normalized: they start at 1 and sum up to the number of
+// nodes.
+func NewPageRank(name string, inputs []string, nReduce, maxIterations int, tolerance float64, opts ...Option) *Iterative {
+	return &Iterative{
+		Name:          name,
+		Inputs:        inputs,
+		NReduce:       nReduce,
+		MapF:          MapPageRank,
+		Options:       append(opts, WithReduceTask(ReducePageRank)),
+		MaxIterations: maxIterations,
+		Converged:     PageRankConverged(tolerance),
+	}
+}
+
+type pageRankEntry struct {
+	rank  float64
+	links []string
+}
+
+// parsePageRankInput reads either an adjacency list or the output of a
+// previous iteration.
+func parsePageRankInput(value string) map[string]pageRankEntry {
+	nodes := make(map[string]pageRankEntry)
+	if strings.HasPrefix(strings.TrimSpace(value), "{") {
+		for _, kv := range DecodeKeyValues(value) {
+			rank, links, _ := strings.Cut(kv.Value, "|")
+			r, _ := strconv.ParseFloat(rank, 64)
+			entry := pageRankEntry{rank: r}
+			if links != "" {
+				entry.links = strings.Split(links, ",")
+			}
+			nodes[kv.Key] = entry
+		}
+		return nodes
+	}
+	for _, line := range strings.Split(value, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		nodes[fields[0]] = pageRankEntry{rank: 1, links: fields[1:]}
+	}
+	return nodes
+}
+
+func formatRank(r float64) string {
+	return strconv.FormatFloat(r, 'g', -1, 64)
+}
@@ -0,0 +1,345 @@
+package mapreduce
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Stage is one job of a Pipeline.
+type Stage struct {
+	Name    string   // unique within the pipeline
+	Inputs  []string // input files, directories or glob patterns
+	From    []string // earlier stages whose result is an input; "stage/output" for a named output
+	NReduce int      // 0 for a map-only stage
+	MapF    func(string) []KeyValue
+	ReduceF func(string, []string) string
+	Options []Option
+	Version string // change it when the functions change, to make the stage run again
+	Keep    bool   // keep the result even though later stages consume it
+}
+
+// Pipeline is a DAG of jobs: stages run once the stages they read from are
+// done, independent stages run concurrently. A stage whose inputs and
+// definition did not change since its last successful run is skipped. The
+// results of stages consumed by later stages are removed once the whole
+// pipeline succeeded, unless they are marked Keep.
+type Pipeline struct {
+	Name    string
+	Stages  []Stage
+	Options []Option // apply to every stage, before the stage options
+}
+
+// StageRunner runs the job of a stage, e.g. with Sequential or on the
+// distributed master.
+type StageRunner func(jobName string, files []string, nReduce int,
+	mapF func(string) []KeyValue, reduceF func(string, []string) string, opts ...Option) error
+
+// SequentialRunner runs stages in the current process with Sequential.
+func SequentialRunner(jobName string, files []string, nReduce int,
+	mapF func(string) []KeyValue, reduceF func(string, []string) string, opts ...Option) error {
+	return Sequential(jobName, files, nReduce, mapF, reduceF, opts...)
+}
+
+// StageJobName returns the name of the job run for a stage of a pipeline.
+func StageJobName(pipeline, stage string) string {
+	return pipeline + "-" + stage
+}
+
+// stampName constructs the name of the file recording the fingerprint of
+// the last successful run of a job.
+func stampName(jobName string) string {
+	return AnsName(jobName) + ".stamp"
+}
+
+// DecodeKeyValues parses the content of a job result, as read by the map
+// function of a stage consuming an earlier stage.
+func DecodeKeyValues(contents string) []KeyValue {
+	var kvs []KeyValue
+	dec := json.NewDecoder(strings.NewReader(contents))
+	for {
+		var kv KeyValue
+		if err := dec.Decode(&kv); err != nil {
+			break
+		}
+		kvs = append(kvs, kv)
+	}
+	return kvs
+}
+
+// stageState is the planning information kept for each stage during Run.
+type stageState struct {
+	stage     *Stage
+	jobName   string
+	opts      []Option
+	cfg       *config
+	deps      []*stageState
+	consumers int
+	fp        string
+	run       bool
+	done      chan struct{}
+	err       error
+}
+
+// Register registers the functions of every stage as apps, under the name
+// of the stage job. Remote worker processes must call it to be able to run
+// the tasks of a distributed pipeline.
+func (p *Pipeline) Register() {
+	for i := range p.Stages {
+		st := &p.Stages[i]
+		RegisterApp(StageJobName(p.Name, st.Name), App{Map: st.MapF, Reduce: st.ReduceF, Options: st.Options})
+	}
+}
+
+// Run runs the stages that are not up to date with runner.
+func (p *Pipeline) Run(runner StageRunner) error {
+	states, err := p.plan()
+	if err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
+	for _, s := range states {
+		wg.Add(1)
+		go func(s *stageState) {
+			defer wg.Done()
+			defer close(s.done)
+			for _, dep := range s.deps {
+				<-dep.done
+				if dep.err != nil {
+					s.err = fmt.Errorf("stage %s: input stage %s failed", s.stage.Name, dep.stage.Name)
+					return
+				}
+			}
+			if !s.run {
+				log.Printf("Pipeline %s: stage %s is up to date, skipping\n", p.Name, s.stage.Name)
+				return
+			}
+			s.err = p.runStage(s, runner)
+		}(s)
+	}
+	wg.Wait()
+
+	var errs []error
+	for _, s := range states {
+		if s.err != nil {
+			errs = append(errs, s.err)
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	// Clean up the results only used by later stages
+	for _, s := range states {
+		if s.consumers > 0 && !s.stage.Keep {
+			for _, name := range resultFiles(s.jobName, s.cfg.outputs) {
+				s.cfg.storage.Remove(name)
+			}
+		}
+	}
+	return nil
+}
+
+func (p *Pipeline) runStage(s *stageState, runner StageRunner) error {
+	log.Printf("Pipeline %s: running stage %s\n", p.Name, s.stage.Name)
+	store := s.cfg.storage
+	// Forget the previous run until this one succeeded
+	store.Remove(stampName(s.jobName))
+
+	files := append([]string(nil), s.stage.Inputs...)
+	for _, ref := range s.stage.From {
+		files = append(files, p.refFile(ref))
+	}
+	if err := runner(s.jobName, files, s.stage.NReduce, s.stage.MapF, s.stage.ReduceF, s.opts...); err != nil {
+		return fmt.Errorf("stage %s: %w", s.stage.Name, err)
+	}
+
+	resolved, err := ResolveInputs(store, files, s.cfg.include, s.cfg.exclude)
+	if err == nil {
+		CleanIntermediary(s.jobName, len(resolved), s.stage.NReduce, s.opts...)
+	}
+	w, err := store.Create(stampName(s.jobName))
+	if err != nil {
+		return fmt.Errorf("stage %s: %w", s.stage.Name, err)
+	}
+	io.WriteString(w, s.fp)
+	return w.Close()
+}
+
+// refFile returns the result file a From reference designates.
+func (p *Pipeline) refFile(ref string) string {
+	stage, output, named := strings.Cut(ref, "/")
+	if named {
+		return OutputName(StageJobName(p.Name, stage), output)
+	}
+	return AnsName(StageJobName(p.Name, stage))
+}
+
+// plan checks the DAG, computes the fingerprint of every stage and decides
+// which ones must run. States are returned in topological order.
+func (p *Pipeline) plan() ([]*stageState, error) {
+	byName := make(map[string]*stageState)
+	for i := range p.Stages {
+		st := &p.Stages[i]
+		if st.Name == "" {
+			return nil, fmt.Errorf("pipeline %s: stage %d has no name", p.Name, i)
+		}
+		if byName[st.Name] != nil {
+			return nil, fmt.Errorf("pipeline %s: duplicate stage %s", p.Name, st.Name)
+		}
+		opts := append(append([]Option(nil), p.Options...), st.Options...)
+		cfg := newConfig(opts)
+		jobName := StageJobName(p.Name, st.Name)
+		if err := cfg.check(jobName); err != nil {
+			return nil, fmt.Errorf("stage %s: %w", st.Name, err)
+		}
+		byName[st.Name] = &stageState{
+			stage:   st,
+			jobName: jobName,
+			opts:    opts,
+			cfg:     cfg,
+			done:    make(chan struct{}),
+		}
+	}
+
+	for _, s := range byName {
+		for _, ref := range s.stage.From {
+			name, output, named := strings.Cut(ref, "/")
+			dep := byName[name]
+			if dep == nil {
+				return nil, fmt.Errorf("stage %s: unknown input stage %s", s.stage.Name, name)
+			}
+			if named && !slices.Contains(dep.cfg.outputs, output) {
+				return nil, fmt.Errorf("stage %s: stage %s has no output %s", s.stage.Name, name, output)
+			}
+			s.deps = append(s.deps, dep)
+			dep.consumers++
+		}
+	}
+
+	// Topological sort (Kahn), in the order stages were declared
+	var order []*stageState
+	indegree := make(map[*stageState]int)
+	for _, s := range byName {
+		indegree[s] = len(s.deps)
+	}
+	for len(order) < len(byName) {
+		progress := false
+		for i := range p.Stages {
+			s := byName[p.Stages[i].Name]
+			if indegree[s] != 0 {
+				continue
+			}
+			indegree[s] = -1
+			order = append(order, s)
+			progress = true
+			for _, other := range byName {
+				for _, dep := range other.deps {
+					if dep == s {
+						indegree[other]--
+					}
+				}
+			}
+		}
+		if !progress {
+			return nil, fmt.Errorf("pipeline %s: stages form a cycle", p.Name)
+		}
+	}
+
+	for _, s := range order {
+		fp, err := s.fingerprint()
+		if err != nil {
+			return nil, err
+		}
+		s.fp = fp
+	}
+
+	// A stage runs when it changed since its last run, or when its result
+	// is missing but needed: to be kept, or by a stage that runs.
+	for i := len(order) - 1; i >= 0; i-- {
+		s := order[i]
+		store := s.cfg.storage
+		stamp, err := readAll(store, stampName(s.jobName))
+		if err != nil || string(stamp) != s.fp {
+			s.run = true
+			continue
+		}
+		needed := s.consumers == 0 || s.stage.Keep
+		for _, other := range order[i+1:] {
+			for _, dep := range other.deps {
+				if dep == s && other.run {
+					needed = true
+				}
+			}
+		}
+		if needed && !filesExist(store, resultFiles(s.jobName, s.cfg.outputs)) {
+			s.run = true
+		}
+	}
+	return order, nil
+}
+
+// fingerprint hashes everything a stage result depends on: its definition,
+// including the app and job settings its tasks run with, the content of its
+// input files and the fingerprints of its input stages.
+func (s *stageState) fingerprint() (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%d\n%s\n%q\n%q\n%q\n", s.jobName, s.stage.NReduce, s.stage.Version,
+		s.cfg.outputs, s.cfg.include, s.cfg.exclude)
+	fmt.Fprintf(h, "app %s\n%+v\n", s.cfg.app, s.cfg.settings)
+	for i, ref := range s.stage.From {
+		fmt.Fprintf(h, "from %s %s\n", ref, s.deps[i].fp)
+	}
+	if len(s.stage.Inputs) > 0 {
+		store := s.cfg.storage
+		files, err := ResolveInputs(store, s.stage.Inputs, s.cfg.include, s.cfg.exclude)
+		if err != nil {
+			return "", fmt.Errorf("stage %s: %w", s.stage.Name, err)
+		}
+		for _, f := range files {
+			r, err := store.Open(f)
+			if err != nil {
+				return "", fmt.Errorf("stage %s: %w", s.stage.Name, err)
+			}
+			fmt.Fprintf(h, "input %s\n", f)
+			_, err = io.Copy(h, r)
+			r.Close()
+			if err != nil {
+				return "", fmt.Errorf("stage %s: %w", s.stage.Name, err)
+			}
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// resultFiles returns the final files of a job: its result and its named
+// outputs.
+func resultFiles(jobName string, outputs []string) []string {
+	files := []string{AnsName(jobName)}
+	for _, output := range outputs {
+		files = append(files, OutputName(jobName, output))
+	}
+	return files
+}
+
+func filesExist(store Storage, names []string) bool {
+	for _, name := range names {
+		r, err := store.Open(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			return false
+		}
+		if err == nil {
+			r.Close()
+		}
+	}
+	return true
+}
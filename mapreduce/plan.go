@@ -0,0 +1,293 @@
+package mapreduce
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// Limits of the sampling done by PlanJob.
+const (
+	PlanSampleFiles = 16      // input files sampled, spread over the inputs
+	PlanSampleBytes = 1 << 20 // bytes read from the start of a sampled file
+)
+
+// PlanSkewFactor is how much larger than the average a partition must be
+// for PlanJob to report the partitioning as skewed.
+const PlanSkewFactor = 2.0
+
+// JobPlan is what a job would do, as estimated by PlanJob. Sizes are
+// uncompressed, estimated from the sampled inputs unless noted.
+type JobPlan struct {
+	Job         string        `json:"Job"`
+	MapTasks    []MapTaskPlan `json:"MapTasks"` // one per input file
+	ReduceTasks int           `json:"ReduceTasks"`
+
+	InputBytes   int64 `json:"InputBytes"`   // as stored, compressed or not
+	SampledBytes int64 `json:"SampledBytes"` // read from the inputs to run the map function on
+
+	EstimatedInputBytes        int64   `json:"EstimatedInputBytes"`
+	EstimatedIntermediateBytes int64   `json:"EstimatedIntermediateBytes"` // 0 for a map-only job
+	EstimatedOutputRecords     int64   `json:"EstimatedOutputRecords"`
+	EstimatedOutputBytes       int64   `json:"EstimatedOutputBytes"`
+	Partitions                 []int64 `json:"Partitions"` // estimated intermediate bytes of each reduce task
+
+	Warnings []string `json:"Warnings"` // problems found, e.g. missing inputs or skew
+}
+
+// MapTaskPlan is a map task of a JobPlan.
+type MapTaskPlan struct {
+	Task    int    `json:"Task"`
+	File    string `json:"File"`
+	Bytes   int64  `json:"Bytes"` // as stored
+	Sampled bool   `json:"Sampled"`
+}
+
+// PlanJob works out what Sequential or StartDistributed would do with the
+// same arguments, without running the job nor writing any file: it
+// resolves the inputs into map tasks and estimates the size of the
+// intermediate files and of the result by running the map, combiner and
+// reduce functions on samples of the inputs. Missing or empty inputs and a
+// skewed partitioning are reported as warnings of the plan; only an
+// invalid configuration is an error.
+func PlanJob(jobName string, files []string, nReduce int,
+	mapF func(string) []KeyValue,
+	reduceF func(string, []string) string,
+	opts ...Option,
+) (*JobPlan, error) {
+	cfg := newConfig(opts)
+	if err := cfg.check(jobName); err != nil {
+		return nil, err
+	}
+	if nReduce < 0 {
+		return nil, fmt.Errorf("negative number of reduce tasks %d", nReduce)
+	}
+	plan := &JobPlan{Job: jobName, ReduceTasks: nReduce, MapTasks: []MapTaskPlan{}, Warnings: []string{}}
+	warn := func(format string, args ...any) {
+		plan.Warnings = append(plan.Warnings, fmt.Sprintf(format, args...))
+	}
+
+	// Resolve the inputs one by one, to report all the missing ones
+	seen := make(map[string]bool)
+	for _, input := range files {
+		found, err := ResolveInputs(cfg.storage, []string{input}, cfg.include, cfg.exclude)
+		if err != nil {
+			warn("%v", err)
+			continue
+		}
+		for _, f := range found {
+			if seen[f] {
+				continue
+			}
+			seen[f] = true
+			if err := checkInputPath(f, cfg.inputRoots); err != nil {
+				warn("%v", err)
+				continue
+			}
+			size, err := inputSize(cfg.storage, f)
+			if err != nil {
+				warn("input %s: %v", f, err)
+				continue
+			}
+			if size == 0 {
+				warn("input %s is empty", f)
+			}
+			plan.MapTasks = append(plan.MapTasks, MapTaskPlan{Task: len(plan.MapTasks), File: f, Bytes: size})
+			plan.InputBytes += size
+		}
+	}
+	if len(plan.MapTasks) == 0 {
+		warn("no input files: the job would fail")
+		return plan, nil
+	}
+
+	mapTask, err := cfg.mapFunc(mapF)
+	if err != nil {
+		return nil, err
+	}
+	var reduceTask ReduceTaskFunc
+	if nReduce > 0 {
+		if reduceTask, err = cfg.reduceFunc(reduceF); err != nil {
+			return nil, err
+		}
+	}
+	s := &planSample{
+		cfg:        cfg,
+		nReduce:    nReduce,
+		mapTask:    mapTask,
+		reduceTask: reduceTask,
+		groups:     make([]map[string][]string, max(nReduce, 1)),
+		partitions: make([]int64, nReduce),
+	}
+	for i := range s.groups {
+		s.groups[i] = make(map[string][]string)
+	}
+	step := (len(plan.MapTasks) + PlanSampleFiles - 1) / PlanSampleFiles
+	for i := 0; i < len(plan.MapTasks); i += step {
+		task := &plan.MapTasks[i]
+		if err := s.sample(jobName, task.File); err != nil {
+			warn("cannot sample input %s: %v", task.File, err)
+			continue
+		}
+		task.Sampled = true
+	}
+	plan.SampledBytes = s.inputBytes
+	if s.inputBytes == 0 {
+		warn("no data sampled: sizes cannot be estimated")
+		return plan, nil
+	}
+
+	// Extrapolate from the sample to the whole input
+	expansion := float64(s.inputBytes) / float64(max(s.rawBytes, 1))
+	plan.EstimatedInputBytes = int64(float64(plan.InputBytes) * expansion)
+	scale := float64(plan.EstimatedInputBytes) / float64(s.inputBytes)
+	estimate := func(n int64) int64 { return int64(math.Round(float64(n) * scale)) }
+	plan.Partitions = make([]int64, nReduce)
+	for i, n := range s.partitions {
+		plan.Partitions[i] = estimate(n)
+		plan.EstimatedIntermediateBytes += plan.Partitions[i]
+	}
+	records, size := s.reduce()
+	plan.EstimatedOutputRecords = estimate(records)
+	plan.EstimatedOutputBytes = estimate(size)
+
+	if largest, mean := skew(s.partitions); nReduce > 1 && largest > PlanSkewFactor*mean {
+		warn("skewed partitions: the largest holds %.1f times the average; use another partitioner or more reduce tasks",
+			largest/mean)
+	}
+	return plan, nil
+}
+
+// planSample accumulates what the map function produced on the samples.
+type planSample struct {
+	cfg        *config
+	nReduce    int
+	mapTask    MapTaskFunc
+	reduceTask ReduceTaskFunc        // nil for a map-only job
+	rawBytes   int64                 // read from the storage
+	inputBytes int64                 // once decompressed
+	groups     []map[string][]string // values by key, by partition
+	partitions []int64               // encoded bytes written to each partition
+}
+
+// sample runs the map function on the start of the named input, as DoMap
+// would, writing nothing.
+func (s *planSample) sample(jobName, name string) error {
+	r, err := s.cfg.storage.Open(name)
+	if err != nil {
+		return err
+	}
+	raw := &countingReader{r: r}
+	in, err := decompressInput(name, io.NopCloser(raw))
+	if err != nil {
+		r.Close()
+		return err
+	}
+	content, err := io.ReadAll(io.LimitReader(in, PlanSampleBytes+1))
+	in.Close()
+	r.Close()
+	if err != nil {
+		return err
+	}
+	if len(content) > PlanSampleBytes {
+		// Keep whole lines of a truncated file
+		content = content[:PlanSampleBytes]
+		if i := bytes.LastIndexByte(content, '\n'); i > 0 {
+			content = content[:i+1]
+		}
+	}
+	s.rawBytes += raw.n
+	s.inputBytes += int64(len(content))
+
+	// Named outputs are discarded
+	tc := &TaskContext{JobName: jobName, TaskType: "map", encoders: make(map[string]*json.Encoder), counters: NewCounters()}
+	for _, output := range s.cfg.outputs {
+		tc.encoders[output] = json.NewEncoder(io.Discard)
+	}
+	var kvs []KeyValue
+	if s.cfg.settings.InputFormat == InputLines {
+		for _, line := range strings.Split(string(content), "\n") {
+			if line != "" {
+				kvs = append(kvs, s.mapTask(tc, line)...)
+			}
+		}
+	} else {
+		kvs = s.mapTask(tc, string(content))
+	}
+	if s.nReduce == 0 {
+		for _, kv := range kvs {
+			s.groups[0][kv.Key] = append(s.groups[0][kv.Key], kv.Value)
+		}
+		return nil
+	}
+
+	partitioner := s.cfg.settings.Partitioner
+	if partitioner == "" {
+		partitioner = PartitionHash
+	}
+	partition, ok := lookupPartitioner(partitioner)
+	if !ok {
+		return fmt.Errorf("unknown partitioner %q", partitioner)
+	}
+	if s.cfg.settings.Combiner != "" {
+		_, combine, ok := appTaskFuncs(s.cfg.settings.Combiner)
+		if !ok {
+			return fmt.Errorf("unknown combiner %q", s.cfg.settings.Combiner)
+		}
+		kvs = combineRecords(tc, kvs, combine)
+	}
+	for _, kv := range kvs {
+		p, err := partitionOf(partition, partitioner, kv.Key, s.nReduce)
+		if err != nil {
+			return err
+		}
+		s.partitions[p] += encodedSize(kv)
+		s.groups[p][kv.Key] = append(s.groups[p][kv.Key], kv.Value)
+	}
+	return nil
+}
+
+// reduce returns the number and size of the records the reduce tasks would
+// write for the sample, or the map tasks of a map-only job.
+func (s *planSample) reduce() (records, size int64) {
+	tc := &TaskContext{TaskType: "reduce", encoders: make(map[string]*json.Encoder), counters: NewCounters()}
+	for _, output := range s.cfg.outputs {
+		tc.encoders[output] = json.NewEncoder(io.Discard)
+	}
+	for _, group := range s.groups {
+		for key, values := range group {
+			if s.reduceTask == nil {
+				for _, v := range values {
+					records++
+					size += encodedSize(KeyValue{Key: key, Value: v})
+				}
+				continue
+			}
+			records++
+			size += encodedSize(KeyValue{Key: key, Value: s.reduceTask(tc, key, values)})
+		}
+	}
+	return records, size
+}
+
+// encodedSize returns the size of kv in intermediate and result files.
+func encodedSize(kv KeyValue) int64 {
+	data, _ := json.Marshal(&kv)
+	return int64(len(data)) + 1
+}
+
+// skew returns the largest and the average of sizes.
+func skew(sizes []int64) (largest, mean float64) {
+	var total int64
+	for _, n := range sizes {
+		total += n
+		largest = max(largest, float64(n))
+	}
+	if len(sizes) == 0 || total == 0 {
+		return 0, 1
+	}
+	return largest, float64(total) / float64(len(sizes))
+}
@@ -0,0 +1,167 @@
+package mapreduce
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/rpc"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// DefaultReconnectTimeout is how long a worker tries to reach a master
+// before giving up, see WithReconnectTimeout.
+const DefaultReconnectTimeout = time.Minute
+
+// Bounds of the wait between two attempts of a worker to reach a master. It
+// doubles after each failed attempt, and a random part of it is skipped so
+// that the workers of a cluster do not all come back at once.
+const (
+	reconnectMinBackoff = 100 * time.Millisecond
+	reconnectMaxBackoff = 10 * time.Second
+)
+
+// WithMasterAddrs gives workers other addresses of the master, e.g. of
+// standbys, tried in turn after the one they were created with when they
+// cannot reach it.
+func WithMasterAddrs(addrs ...string) Option {
+	return func(c *config) {
+		c.masterAddrs = append(c.masterAddrs, addrs...)
+	}
+}
+
+// WithReconnectTimeout sets how long a worker tries to reach a master, when
+// it starts or after losing its connection, before Worker.Start gives up. A
+// worker with d <= 0 never gives up.
+func WithReconnectTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.reconnectTimeout = d
+	}
+}
+
+// sessionArgs are the arguments of the RPC calls made within a session.
+type sessionArgs interface {
+	setSession(session string)
+}
+
+func (a *TaskArgs) setSession(session string)      { a.Session = session }
+func (a *ReportArgs) setSession(session string)    { a.Session = session }
+func (a *HeartbeatArgs) setSession(session string) { a.Session = session }
+
+// isServerError reports whether err was returned by the master, rather than
+// caused by the connection to it.
+func isServerError(err error) bool {
+	var serverErr rpc.ServerError
+	return errors.As(err, &serverErr)
+}
+
+// call calls a method of the master in the session of the worker. When the
+// connection is lost, the worker connects again, possibly to another
+// address of the master, opens a new session and calls the method again.
+// It also opens a new session once when the master no longer knows its
+// own, e.g. after it expired while the worker was paused.
+func (w *Worker) call(method string, args sessionArgs, reply any) error {
+	renewed := false
+	for {
+		w.mu.Lock()
+		client, session := w.client, w.session
+		args.setSession(session)
+		w.mu.Unlock()
+		reflect.ValueOf(reply).Elem().SetZero() // of a previous call
+		err := client.Call(method, args, reply)
+		if err == nil && w.faults.happens(w.faults.DropReply) {
+			log.Printf("Worker %s drops the reply of %s\n", w.id, method)
+			continue
+		}
+		if isServerError(err) && !renewed && w.cfg.clusterToken != "" &&
+			strings.HasPrefix(err.Error(), errUnauthenticated.Error()) {
+			renewed = true
+			w.mu.Lock()
+			if w.session == session { // not renewed by another call yet
+				log.Printf("Worker %s lost its session: %v\n", w.id, err)
+				err = w.register()
+			} else {
+				err = nil
+			}
+			w.mu.Unlock()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err == nil || isServerError(err) || len(w.addrs) == 0 {
+			return err
+		}
+
+		w.mu.Lock()
+		if w.client == client { // not reconnected by another call yet
+			log.Printf("Worker %s lost the master: %v\n", w.id, err)
+			err = w.connect()
+		}
+		w.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// connect opens a session with the master. Unless the worker runs in the
+// process of the master, it first connects to one of the addresses of the
+// master, trying them in turn with a growing wait in between until
+// cfg.reconnectTimeout passes. The caller must hold w.mu.
+func (w *Worker) connect() error {
+	if len(w.addrs) == 0 {
+		return w.register()
+	}
+	if closer, ok := w.client.(io.Closer); ok {
+		closer.Close()
+	}
+
+	cfg := w.cfg
+	start := time.Now()
+	backoff := reconnectMinBackoff
+	for {
+		addr := w.addrs[w.addr]
+		conn, err := dial(addr, cfg.tlsConfig)
+		if err == nil {
+			w.client = rpc.NewClient(conn)
+			if err = w.register(); err == nil {
+				log.Printf("Worker %s connected to master %s\n", w.id, addr)
+				return nil
+			}
+			if isServerError(err) {
+				return fmt.Errorf("master %s refused worker %s: %w", addr, w.id, err)
+			}
+		}
+		log.Printf("Worker %s cannot reach master %s: %v\n", w.id, addr, err)
+		w.addr = (w.addr + 1) % len(w.addrs)
+
+		wait := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		if cfg.reconnectTimeout > 0 && time.Since(start)+wait > cfg.reconnectTimeout {
+			return fmt.Errorf("worker %s gave up reaching a master after %v: %w",
+				w.id, time.Since(start).Round(time.Millisecond), err)
+		}
+		select {
+		case <-cfg.ctx.Done():
+			return cfg.ctx.Err()
+		case <-time.After(wait):
+		}
+		backoff = min(2*backoff, reconnectMaxBackoff)
+	}
+}
+
+// register opens a session, authenticated when the cluster has a token,
+// ending the previous session of the worker. The caller must hold w.mu.
+func (w *Worker) register() error {
+	args := newRegisterArgs(w.cfg.clusterToken, w.id)
+	args.Session = w.session
+	var reply RegisterReply
+	if err := w.client.Call("Master.Register", args, &reply); err != nil {
+		return err
+	}
+	w.session = reply.Session
+	return nil
+}
@@ -0,0 +1,285 @@
+package mapreduce
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Limits of the number of records of a result page.
+const (
+	DefaultResultLimit = 100
+	MaxResultLimit     = 1000
+)
+
+// ResultFormats are the formats results can be downloaded in.
+var ResultFormats = []string{"jsonl", "json", "csv", "tsv"}
+
+// ResultQuery selects records of a job result.
+type ResultQuery struct {
+	Output string // named output, the main result when empty
+	Prefix string // only keys starting with Prefix
+	Search string // only records whose key or value contains Search
+	Offset int64  // byte offset to scan from, the Next of the previous page
+	Limit  int    // maximum number of records, all of them when 0
+}
+
+func (q ResultQuery) match(kv KeyValue) bool {
+	return strings.HasPrefix(kv.Key, q.Prefix) &&
+		(strings.Contains(kv.Key, q.Search) || strings.Contains(kv.Value, q.Search))
+}
+
+// ResultPage is a page of the records of a job result.
+type ResultPage struct {
+	Job     string     `json:"Job"`
+	Output  string     `json:"Output,omitempty"`
+	Offset  int64      `json:"Offset"`
+	Next    int64      `json:"Next"` // offset of the next page, -1 after the last one
+	Records []KeyValue `json:"Records"`
+}
+
+// resultName is the file holding the result q is about.
+func resultName(jobName string, q ResultQuery) string {
+	if q.Output == "" {
+		return AnsName(jobName)
+	}
+	return OutputName(jobName, q.Output)
+}
+
+// scanResults calls f with the records of the result matching q, from
+// q.Offset, along with the offset following each of them. It stops after
+// q.Limit records, or when f returns false.
+func scanResults(store Storage, jobName string, q ResultQuery, f func(kv KeyValue, next int64) bool) error {
+	if q.Output != "" && !validOutputName(q.Output) {
+		return fmt.Errorf("invalid output name %q", q.Output)
+	}
+	r, err := store.Open(resultName(jobName, q))
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if s, ok := r.(io.Seeker); ok {
+		_, err = s.Seek(q.Offset, io.SeekStart)
+	} else {
+		_, err = io.CopyN(io.Discard, r, q.Offset)
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+
+	br := bufio.NewReader(r)
+	pos, n := q.Offset, 0
+	for q.Limit == 0 || n < q.Limit {
+		line, err := br.ReadBytes('\n')
+		pos += int64(len(line))
+		if len(line) > 0 {
+			var kv KeyValue
+			if json.Unmarshal(line, &kv) == nil && q.match(kv) {
+				n++
+				if !f(kv, pos) {
+					return nil
+				}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadResults returns a page of the records of a job result matching q.
+func ReadResults(store Storage, jobName string, q ResultQuery) (*ResultPage, error) {
+	page := &ResultPage{Job: jobName, Output: q.Output, Offset: q.Offset, Next: -1, Records: []KeyValue{}}
+	limit := q.Limit
+	if q.Limit > 0 {
+		q.Limit++ // to know whether there is a next page
+	}
+	var last int64
+	err := scanResults(store, jobName, q, func(kv KeyValue, next int64) bool {
+		if limit > 0 && len(page.Records) == limit {
+			page.Next = last
+			return false
+		}
+		page.Records = append(page.Records, kv)
+		last = next
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return page, nil
+}
+
+// WriteResults writes all the records of a job result matching q in one of
+// ResultFormats.
+func WriteResults(w io.Writer, store Storage, jobName string, q ResultQuery, format string) error {
+	q.Offset, q.Limit = 0, 0
+	var write func(kv KeyValue) error
+	var flush func() error
+	switch format {
+	case "jsonl":
+		enc := json.NewEncoder(w)
+		write = func(kv KeyValue) error { return enc.Encode(kv) }
+		flush = func() error { return nil }
+	case "json":
+		first := true
+		write = func(kv KeyValue) error {
+			sep := ",\n"
+			if first {
+				sep, first = "[\n", false
+			}
+			data, err := json.Marshal(kv)
+			if err == nil {
+				_, err = fmt.Fprintf(w, "%s%s", sep, data)
+			}
+			return err
+		}
+		flush = func() error {
+			if first {
+				_, err := io.WriteString(w, "[]\n")
+				return err
+			}
+			_, err := io.WriteString(w, "\n]\n")
+			return err
+		}
+	case "csv", "tsv":
+		cw := csv.NewWriter(w)
+		if format == "tsv" {
+			cw.Comma = '\t'
+		}
+		if err := cw.Write([]string{"key", "value"}); err != nil {
+			return err
+		}
+		write = func(kv KeyValue) error { return cw.Write([]string{kv.Key, kv.Value}) }
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	default:
+		return fmt.Errorf("unknown format %q, use one of %s", format, strings.Join(ResultFormats, ", "))
+	}
+
+	var werr error
+	err := scanResults(store, jobName, q, func(kv KeyValue, _ int64) bool {
+		werr = write(kv)
+		return werr == nil
+	})
+	if err != nil {
+		return err
+	}
+	if werr != nil {
+		return werr
+	}
+	return flush()
+}
+
+// resultQuery reads the query parameters of the results API.
+func resultQuery(r *http.Request) (ResultQuery, error) {
+	params := r.URL.Query()
+	q := ResultQuery{
+		Output: params.Get("output"),
+		Prefix: params.Get("prefix"),
+		Search: params.Get("q"),
+		Limit:  DefaultResultLimit,
+	}
+	if s := params.Get("offset"); s != "" {
+		offset, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || offset < 0 {
+			return q, fmt.Errorf("invalid offset %q", s)
+		}
+		q.Offset = offset
+	}
+	if s := params.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit <= 0 {
+			return q, fmt.Errorf("invalid limit %q", s)
+		}
+		q.Limit = min(limit, MaxResultLimit)
+	}
+	return q, nil
+}
+
+// resultStore returns the storage of a job whose results are merged.
+func (m *Master) resultStore(jobName string) (Storage, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job := m.job(jobName)
+	if job == nil || !job.merged {
+		return nil, false
+	}
+	return job.store, true
+}
+
+// resultsHandler serves a page of the result of a job as JSON.
+func (m *Master) resultsHandler(w http.ResponseWriter, r *http.Request) {
+	jobName := r.PathValue("job")
+	store, ok := m.resultStore(jobName)
+	if !ok {
+		http.Error(w, fmt.Sprintf("No result for job %s", jobName), http.StatusNotFound)
+		return
+	}
+	q, err := resultQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, err := ReadResults(store, jobName, q)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Cannot read result: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// downloadHandler serves the records of the result of a job matching the
+// query as a file, in the format given by the format parameter.
+func (m *Master) downloadHandler(w http.ResponseWriter, r *http.Request) {
+	jobName := r.PathValue("job")
+	store, ok := m.resultStore(jobName)
+	if !ok {
+		http.Error(w, fmt.Sprintf("No result for job %s", jobName), http.StatusNotFound)
+		return
+	}
+	q, err := resultQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "jsonl"
+	}
+	contentTypes := map[string]string{
+		"jsonl": "application/x-ndjson",
+		"json":  "application/json",
+		"csv":   "text/csv",
+		"tsv":   "text/tab-separated-values",
+	}
+	contentType, ok := contentTypes[format]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown format %q", format), http.StatusBadRequest)
+		return
+	}
+	name := jobName
+	if q.Output != "" {
+		name += "-" + q.Output
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+"."+format))
+	if err := WriteResults(w, store, jobName, q, format); err != nil {
+		// Too late for an error status, the client gets a truncated file
+		log.Printf("Cannot send result of job %s: %v\n", jobName, err)
+	}
+}
@@ -0,0 +1,85 @@
+package mapreduce
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// MaxJobNameLength bounds the length of job names.
+const MaxJobNameLength = 128
+
+// WithInputRoots restricts the inputs of a job to the given directories of
+// its storage. Given to NewMaster, they restrict the jobs submitted through
+// the API. Workers given this option refuse the map tasks reading
+// elsewhere. Without it, any file of the storage can be read, but never one
+// outside of it (absolute or escaping with "..").
+func WithInputRoots(roots ...string) Option {
+	return func(c *config) {
+		c.inputRoots = append(c.inputRoots, roots...)
+	}
+}
+
+// ValidJobName tells whether name can be used for a job: it must be made of
+// letters, digits, '.', '_' and '-', start with a letter or a digit and be
+// at most MaxJobNameLength long, as it is part of the names of the files of
+// the job.
+func ValidJobName(name string) bool {
+	if name == "" || len(name) > MaxJobNameLength {
+		return false
+	}
+	for i, r := range name {
+		alnum := 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9'
+		if !alnum && (i == 0 || r != '.' && r != '_' && r != '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// checkJobName reports a job name ValidJobName refuses.
+func checkJobName(name string) error {
+	if !ValidJobName(name) {
+		return fmt.Errorf("invalid job name %q", name)
+	}
+	return nil
+}
+
+// checkInputPath reports an input file that escapes the storage, or that is
+// not in one of roots when there are any.
+func checkInputPath(name string, roots []string) error {
+	if path.IsAbs(name) {
+		return fmt.Errorf("input %q is absolute: inputs are paths in the storage of the job", name)
+	}
+	if !fs.ValidPath(name) {
+		return fmt.Errorf("input %q is outside of the storage", name)
+	}
+	if len(roots) == 0 {
+		return nil
+	}
+	for _, root := range roots {
+		root = path.Clean(root)
+		if root == "." || name == root || strings.HasPrefix(name, root+"/") {
+			return nil
+		}
+	}
+	return fmt.Errorf("input %q is outside of the input roots %v", name, roots)
+}
+
+// checkTask reports a task a worker must not run with cfg: one whose job
+// name or input file could make it touch files outside of its sandbox.
+func checkTask(task Task, cfg *config) error {
+	if err := checkJobName(task.JobName); err != nil {
+		return err
+	}
+	for _, output := range task.Outputs {
+		if !validOutputName(output) {
+			return fmt.Errorf("invalid output name %q", output)
+		}
+	}
+	if task.Type == "map" {
+		return checkInputPath(task.File, cfg.inputRoots)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package mapreduce
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Input formats, see WithInputFormat.
+const (
+	InputText  = "text"  // the map function gets the whole file
+	InputLines = "lines" // the map function gets each line of the file
+)
+
+// Compressions of the intermediate files, see WithCompression.
+const (
+	CompressionNone = "none"
+	CompressionGzip = "gzip"
+)
+
+// TaskSettings are the settings of a job its tasks carry to the workers.
+// Functions are named by the registered apps providing them.
+type TaskSettings struct {
+	InputFormat string `json:"InputFormat,omitempty"`
+	Map         string `json:"Map,omitempty"`      // app whose map function runs, instead of the job one
+	Reduce      string `json:"Reduce,omitempty"`   // app whose reduce function runs, instead of the job one
+	Combiner    string `json:"Combiner,omitempty"` // app whose reduce function combines map outputs
+	Partitioner string `json:"Partitioner,omitempty"`
+	Compression string `json:"Compression,omitempty"`
+}
+
+// check reports the settings that cannot be used.
+func (s TaskSettings) check() error {
+	if s.InputFormat != "" && s.InputFormat != InputText && s.InputFormat != InputLines {
+		return fmt.Errorf("unknown input format %q, use %s or %s", s.InputFormat, InputText, InputLines)
+	}
+	if s.Compression != "" && s.Compression != CompressionNone && s.Compression != CompressionGzip {
+		return fmt.Errorf("unknown compression %q, use %s or %s", s.Compression, CompressionNone, CompressionGzip)
+	}
+	if s.Partitioner != "" {
+		if _, ok := lookupPartitioner(s.Partitioner); !ok {
+			return fmt.Errorf("unknown partitioner %q", s.Partitioner)
+		}
+	}
+	for _, name := range []string{s.Map, s.Reduce, s.Combiner} {
+		if name == "" {
+			continue
+		}
+		if _, ok := lookupApp(name); !ok {
+			return fmt.Errorf("unknown app %q", name)
+		}
+	}
+	return nil
+}
+
+// withSettings sets the settings of a task received from the master.
+func withSettings(s TaskSettings) Option {
+	return func(c *config) {
+		c.settings = s
+	}
+}
+
+// WithInputFormat sets how map tasks read their input file: InputText (the
+// default) or InputLines.
+func WithInputFormat(format string) Option {
+	return func(c *config) {
+		c.settings.InputFormat = format
+	}
+}
+
+// WithCombiner makes map tasks combine the values of each key they emit
+// with the reduce function of the app registered under name, before writing
+// them. It must give the same result when applied to partial results.
+func WithCombiner(app string) Option {
+	return func(c *config) {
+		c.settings.Combiner = app
+	}
+}
+
+// WithPartitioner makes map tasks spread keys over reduce tasks with the
+// partitioner registered under name instead of PartitionHash.
+func WithPartitioner(name string) Option {
+	return func(c *config) {
+		c.settings.Partitioner = name
+	}
+}
+
+// WithCompression compresses the intermediate files of the job, with
+// CompressionGzip.
+func WithCompression(compression string) Option {
+	return func(c *config) {
+		c.settings.Compression = compression
+	}
+}
+
+// WithTaskTimeout sets how long the master waits for an attempt of a task
+// before giving it to another worker.
+func WithTaskTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.taskTimeout = d
+	}
+}
+
+// WithMaxAttempts makes a distributed job fail once a task failed or timed
+// out n times. 0, the default, retries tasks forever.
+func WithMaxAttempts(n int) Option {
+	return func(c *config) {
+		c.maxAttempts = n
+	}
+}
+
+// WithCounterLimits makes the job fail when one of its counters goes over
+// its limit, e.g. a counter of malformed records.
+func WithCounterLimits(limits map[string]int64) Option {
+	return func(c *config) {
+		c.counterLimits = limits
+	}
+}
+
+// WithOutputFormat also exports the merged result of the job in format, one
+// of ResultFormats, to the file ExportName returns.
+func WithOutputFormat(format string) Option {
+	return func(c *config) {
+		c.outputFormat = format
+	}
+}
+
+// ExportName constructs the name of the file the result of a job is
+// exported to in format, see WithOutputFormat.
+func ExportName(jobName, format string) string {
+	return AnsName(jobName) + "." + format
+}
+
+// checkCounterLimits reports the first counter over its limit.
+func checkCounterLimits(values, limits map[string]int64) error {
+	for _, name := range sortedKeys(limits) {
+		if values[name] > limits[name] {
+			return fmt.Errorf("counter %s is %d, over its limit of %d", name, values[name], limits[name])
+		}
+	}
+	return nil
+}
+
+// exportResult writes the merged result of a job in the output format of
+// cfg, if it is not the format of the result itself.
+func exportResult(jobName string, cfg *config) error {
+	if cfg.outputFormat == "" || cfg.outputFormat == "jsonl" {
+		return nil
+	}
+	out, err := createTaskOutput(cfg.storage, ExportName(jobName, cfg.outputFormat))
+	if err != nil {
+		return err
+	}
+	if err := WriteResults(out, cfg.storage, jobName, ResultQuery{}, cfg.outputFormat); err != nil {
+		out.Abort()
+		return err
+	}
+	return out.Commit()
+}
+
+// checkJobSettings reports the job settings of cfg that cannot be used.
+func (c *config) checkJobSettings() error {
+	if err := c.settings.check(); err != nil {
+		return err
+	}
+	if c.outputFormat != "" && !slices.Contains(ResultFormats, c.outputFormat) {
+		return fmt.Errorf("unknown output format %q, use one of %v", c.outputFormat, ResultFormats)
+	}
+	if c.taskTimeout < 0 {
+		return fmt.Errorf("negative task timeout %s", c.taskTimeout)
+	}
+	if c.maxAttempts < 0 {
+		return fmt.Errorf("negative number of attempts %d", c.maxAttempts)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package mapreduce
+
+// Slots are numbers of map and reduce tasks, e.g. of the tasks a worker may
+// run at once.
+type Slots struct {
+	Map    int `json:"Map"`
+	Reduce int `json:"Reduce"`
+}
+
+// WithSlots makes workers run up to mapSlots map tasks and reduceSlots
+// reduce tasks at once, asking the master for tasks while they have a free
+// slot. A worker without slots of a type never runs tasks of that type. By
+// default, a worker runs one task of either type at a time.
+func WithSlots(mapSlots, reduceSlots int) Option {
+	return func(c *config) {
+		c.slots = Slots{Map: mapSlots, Reduce: reduceSlots}
+	}
+}
+
+// add returns s with n more tasks of type taskType.
+func (s Slots) add(taskType string, n int) Slots {
+	switch taskType {
+	case "map":
+		s.Map += n
+	case "reduce":
+		s.Reduce += n
+	}
+	return s
+}
+
+// fits reports whether a task of type taskType may run in the free slots
+// s. No slots at all stand for a worker running any one task.
+func (s Slots) fits(taskType string) bool {
+	switch {
+	case s == Slots{}:
+		return true
+	case taskType == "map":
+		return s.Map > 0
+	default:
+		return s.Reduce > 0
+	}
+}
+
+// freeSlots returns the slots of the worker running no task, and whether
+// it may run one more task.
+func (w *Worker) freeSlots() (Slots, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.cfg.slots == (Slots{}) {
+		return Slots{}, w.running == Slots{}
+	}
+	free := Slots{Map: max(w.cfg.slots.Map-w.running.Map, 0), Reduce: max(w.cfg.slots.Reduce-w.running.Reduce, 0)}
+	return free, free != Slots{}
+}
+
+// workerSlots are the slots a worker reported in its last heartbeat.
+type workerSlots struct {
+	slots Slots
+	used  Slots
+}
+
+// workerTasks counts the tasks in progress on a worker. The caller must
+// hold m.mu.
+func (m *Master) workerTasks(workerID string) Slots {
+	return m.running[workerID]
+}
+
+// countTask adds delta tasks of a type to those in progress on a worker, as
+// a task starts or stops running there. The caller must hold m.mu.
+func (m *Master) countTask(workerID, taskType string, delta int) {
+	tasks := m.running[workerID].add(taskType, delta)
+	if tasks == (Slots{}) {
+		delete(m.running, workerID)
+	} else {
+		m.running[workerID] = tasks
+	}
+}
+
+// workerInfo describes a worker on the dashboard. The caller must hold
+// m.mu.
+func (m *Master) workerInfo(workerID string) WorkerInfo {
+	reported := m.slots[workerID]
+	return WorkerInfo{
+		Name:   workerID,
+		Status: m.workers[workerID],
+		Tasks:  m.workerTasks(workerID),
+		Slots:  reported.slots,
+		Used:   reported.used,
+	}
+}
+
+// reportSlots records the slots of a worker from its heartbeat. The caller
+// must hold m.mu.
+func (m *Master) reportSlots(workerID string, slots, used Slots) {
+	reported := workerSlots{slots: slots, used: used}
+	if _, ok := m.workers[workerID]; !ok || m.slots[workerID] == reported {
+		return
+	}
+	m.slots[workerID] = reported
+	m.publish(sseWorker, m.workerInfo(workerID))
+}
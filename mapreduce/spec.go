@@ -0,0 +1,231 @@
+package mapreduce
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+)
+
+// JobSpec describes a job declaratively, e.g. in a spec file loaded with
+// LoadJobSpec or submitted through the API of the master. Functions and
+// partitioners are named by what was registered with RegisterApp and
+// RegisterPartitioner.
+type JobSpec struct {
+	Name    string   `json:"Name"`
+	Inputs  []string `json:"Inputs"` // files, directories or glob patterns
+	NReduce int      `json:"NReduce"`
+	App     string   `json:"App,omitempty"` // see RegisterApp
+	Include []string `json:"Include,omitempty"`
+	Exclude []string `json:"Exclude,omitempty"`
+	Outputs []string `json:"Outputs,omitempty"`
+
+	InputFormat  string           `json:"InputFormat,omitempty"`  // see WithInputFormat
+	Map          string           `json:"Map,omitempty"`          // app whose map function runs instead of the App one
+	Reduce       string           `json:"Reduce,omitempty"`       // app whose reduce function runs instead of the App one
+	Combiner     string           `json:"Combiner,omitempty"`     // see WithCombiner
+	Partitioner  string           `json:"Partitioner,omitempty"`  // see WithPartitioner
+	OutputFormat string           `json:"OutputFormat,omitempty"` // see WithOutputFormat
+	Compression  string           `json:"Compression,omitempty"`  // see WithCompression
+	TaskTimeout  Duration         `json:"TaskTimeout,omitempty"`  // see WithTaskTimeout
+	MaxAttempts  int              `json:"MaxAttempts,omitempty"`  // see WithMaxAttempts
+	Counters     map[string]int64 `json:"Counters,omitempty"`     // limits, see WithCounterLimits
+}
+
+// Duration is a time.Duration written as "30s" or "1m30s" in job specs, or
+// as a number of seconds.
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case float64:
+		*d = Duration(v * float64(time.Second))
+		return nil
+	case string:
+		dur, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q, e.g. use 30s or 5m", v)
+		}
+		*d = Duration(dur)
+		return nil
+	}
+	return fmt.Errorf("invalid duration %s, e.g. use 30s or 5m", data)
+}
+
+// LoadJobSpec reads a job spec file, in JSON or YAML, see ParseJobSpec.
+func LoadJobSpec(path string) (*JobSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := ParseJobSpec(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return spec, nil
+}
+
+// ParseJobSpec parses a job spec in JSON, when it starts with '{', or else
+// in the subset of YAML made of mappings, sequences and scalars. Keys are
+// the names of the JobSpec fields, in any case; unknown keys are errors. The
+// spec is not validated, see JobSpec.Validate.
+func ParseJobSpec(data []byte) (*JobSpec, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(data), []byte("{")) {
+		v, err := parseYAML(data)
+		if err != nil {
+			return nil, err
+		}
+		if data, err = json.Marshal(v); err != nil {
+			return nil, err
+		}
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var spec JobSpec
+	if err := dec.Decode(&spec); err != nil {
+		return nil, fmt.Errorf("invalid job spec: %w", err)
+	}
+	return &spec, nil
+}
+
+// Validate reports all the fields of the spec that cannot be used, naming
+// them.
+func (spec JobSpec) Validate() error {
+	var errs []error
+	field := func(name, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: "+format, append([]any{name}, args...)...))
+	}
+	app := func(name, value string) {
+		if _, ok := lookupApp(value); value != "" && !ok {
+			field(name, "unknown app %q", value)
+		}
+	}
+	oneOf := func(name, value string, values ...string) {
+		if value != "" && !slices.Contains(values, value) {
+			field(name, "unknown value %q, use one of %v", value, values)
+		}
+	}
+
+	if !ValidJobName(spec.Name) {
+		field("Name", "invalid job name %q, use at most %d letters, digits, '.', '_' or '-'", spec.Name, MaxJobNameLength)
+	}
+	if len(spec.Inputs) == 0 {
+		field("Inputs", "no input given")
+	}
+	if spec.NReduce < 0 {
+		field("NReduce", "cannot be negative, got %d", spec.NReduce)
+	}
+	app("App", spec.App)
+	app("Map", spec.Map)
+	app("Reduce", spec.Reduce)
+	app("Combiner", spec.Combiner)
+	if _, ok := lookupPartitioner(spec.Partitioner); spec.Partitioner != "" && !ok {
+		field("Partitioner", "unknown partitioner %q", spec.Partitioner)
+	}
+	oneOf("InputFormat", spec.InputFormat, InputText, InputLines)
+	oneOf("OutputFormat", spec.OutputFormat, ResultFormats...)
+	oneOf("Compression", spec.Compression, CompressionNone, CompressionGzip)
+	if spec.TaskTimeout < 0 {
+		field("TaskTimeout", "cannot be negative, got %s", time.Duration(spec.TaskTimeout))
+	}
+	if spec.MaxAttempts < 0 {
+		field("MaxAttempts", "cannot be negative, got %d", spec.MaxAttempts)
+	}
+	for _, name := range sortedKeys(spec.Counters) {
+		if spec.Counters[name] < 0 {
+			field("Counters", "negative limit %d for %s", spec.Counters[name], name)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Options returns the options of the job spec describes, for Sequential or
+// StartDistributed.
+func (spec JobSpec) Options() []Option {
+	return []Option{
+		WithInputFilter(spec.Include, spec.Exclude),
+		WithApp(spec.App),
+		withOutputs(spec.Outputs),
+		withSettings(TaskSettings{
+			InputFormat: spec.InputFormat,
+			Map:         spec.Map,
+			Reduce:      spec.Reduce,
+			Combiner:    spec.Combiner,
+			Partitioner: spec.Partitioner,
+			Compression: spec.Compression,
+		}),
+		WithTaskTimeout(time.Duration(spec.TaskTimeout)),
+		WithMaxAttempts(spec.MaxAttempts),
+		WithCounterLimits(spec.Counters),
+		WithOutputFormat(spec.OutputFormat),
+	}
+}
+
+// Run validates the spec and runs its job with runner, e.g.
+// SequentialRunner. opts apply before the options of the spec.
+func (spec JobSpec) Run(runner StageRunner, opts ...Option) error {
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+	mapF, reduceF, appOpts, err := spec.funcs()
+	if err != nil {
+		return err
+	}
+	opts = append(append(appOpts, opts...), spec.Options()...)
+	return runner(spec.Name, spec.Inputs, spec.NReduce, mapF, reduceF, opts...)
+}
+
+// Plan validates the spec and plans its job, see PlanJob. opts apply
+// before the options of the spec.
+func (spec JobSpec) Plan(opts ...Option) (*JobPlan, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	mapF, reduceF, appOpts, err := spec.funcs()
+	if err != nil {
+		return nil, err
+	}
+	opts = append(append(appOpts, opts...), spec.Options()...)
+	return PlanJob(spec.Name, spec.Inputs, spec.NReduce, mapF, reduceF, opts...)
+}
+
+// funcs returns the functions of the job, from its App or else from the
+// apps named by its Map and Reduce fields.
+func (spec JobSpec) funcs() (func(string) []KeyValue, func(string, []string) string, []Option, error) {
+	if spec.App != "" {
+		app, _ := lookupApp(spec.App)
+		return app.Map, app.Reduce, slices.Clone(app.Options), nil
+	}
+	if spec.Map == "" || spec.Reduce == "" && spec.NReduce > 0 {
+		return nil, nil, nil, fmt.Errorf("App: no app given, nor Map and Reduce")
+	}
+	mapApp, _ := lookupApp(spec.Map)
+	reduceApp, _ := lookupApp(spec.Reduce)
+	return mapApp.Map, reduceApp.Reduce, nil, nil
+}
+
+// StartDistributedSpec validates the spec and runs its job with
+// StartDistributed. opts, e.g. WithRPCAddr, apply to the master and before
+// the options of the spec.
+func StartDistributedSpec(spec JobSpec, opts ...Option) *JobResult {
+	if err := spec.Validate(); err != nil {
+		return &JobResult{Job: spec.Name, Err: err}
+	}
+	mapF, reduceF, appOpts, err := spec.funcs()
+	if err != nil {
+		return &JobResult{Job: spec.Name, Err: err}
+	}
+	opts = append(append(appOpts, opts...), spec.Options()...)
+	return StartDistributed(spec.Name, spec.Inputs, spec.NReduce, mapF, reduceF, opts...)
+}
@@ -0,0 +1,142 @@
+package mapreduce
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// The dashboard follows the master through Server-Sent Events at /events:
+// a "snapshot" event with the DashboardData when it connects, then deltas as
+// they happen.
+const (
+	sseSnapshot = "snapshot" // DashboardData
+	sseTask     = "task"     // Task
+	sseWorker   = "worker"   // WorkerInfo
+	sseAttempt  = "attempt"  // TaskAttempt, when it starts and ends
+	sseProgress = "progress" // progressDelta
+	ssePaused   = "paused"   // pausedDelta
+	sseCounters = "counters" // countersDelta
+	sseResult   = "result"   // resultDelta
+)
+
+type progressDelta struct {
+	Progress float64 `json:"Progress"`
+}
+
+type pausedDelta struct {
+	Paused bool `json:"Paused"`
+}
+
+type countersDelta struct {
+	Job      string           `json:"Job"`
+	Counters map[string]int64 `json:"Counters"`
+}
+
+type resultDelta struct {
+	Job string `json:"Job"` // whose results can now be browsed
+}
+
+// subscriberBuffer is the number of events a slow dashboard may lag behind
+// before being disconnected. EventSource reconnects by itself, and gets a
+// fresh snapshot.
+const subscriberBuffer = 256
+
+func formatSSE(event string, v any) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Cannot encode %s event: %v\n", event, err)
+		return nil
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "event: %s\ndata: %s\n\n", event, data)
+	return buf.Bytes()
+}
+
+// publish sends an event to every dashboard connected. The caller must hold
+// m.mu.
+func (m *Master) publish(event string, v any) {
+	if len(m.subscribers) == 0 {
+		return
+	}
+	msg := formatSSE(event, v)
+	for ch := range m.subscribers {
+		select {
+		case ch <- msg:
+		default:
+			delete(m.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+// setWorker changes the status of a worker. The caller must hold m.mu.
+func (m *Master) setWorker(workerID, status string) {
+	if m.workers[workerID] == status {
+		return
+	}
+	m.workers[workerID] = status
+	m.publish(sseWorker, m.workerInfo(workerID))
+}
+
+// setTask replaces the i-th task of job. The caller must hold m.mu.
+func (m *Master) setTask(job *distJob, i int, task Task) {
+	old := job.tasks[i]
+	job.tasks[i] = task
+	m.publish(sseTask, task)
+	// The tasks in progress on the workers changed
+	if old.Status == "in-progress" && (task.Status != "in-progress" || task.Worker != old.Worker) {
+		m.countTask(old.Worker, old.Type, -1)
+		m.publish(sseWorker, m.workerInfo(old.Worker))
+	}
+	if task.Status == "in-progress" && (old.Status != "in-progress" || task.Worker != old.Worker) {
+		m.countTask(task.Worker, task.Type, 1)
+		m.publish(sseWorker, m.workerInfo(task.Worker))
+	}
+	m.publish(sseProgress, progressDelta{Progress: m.progressPercent()})
+}
+
+// eventsHandler streams the dashboard events.
+func (m *Master) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	m.mu.Lock()
+	snapshot := m.dashboardData()
+	ch := make(chan []byte, subscriberBuffer)
+	m.subscribers[ch] = struct{}{}
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		if _, ok := m.subscribers[ch]; ok {
+			delete(m.subscribers, ch)
+			close(ch)
+		}
+		m.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(formatSSE(sseSnapshot, snapshot))
+	flusher.Flush()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case msg, ok := <-ch:
+			if !ok {
+				return // too slow, the client reconnects
+			}
+			if _, err := w.Write(msg); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
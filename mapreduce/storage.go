@@ -0,0 +1,163 @@
+package mapreduce
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Storage abstracts the place where inputs, intermediate files and results
+// live. Names are slash-separated paths; how they map to actual files or
+// objects is up to the backend.
+type Storage interface {
+	// Open opens the named file for reading. A missing file is reported
+	// with an error wrapping fs.ErrNotExist.
+	Open(name string) (io.ReadCloser, error)
+	// Create creates or truncates the named file. The content is only
+	// guaranteed to be visible once the writer has been closed.
+	Create(name string) (io.WriteCloser, error)
+	// Rename replaces newName with oldName.
+	Rename(oldName, newName string) error
+	// List returns, sorted, the names of all files starting with prefix.
+	List(prefix string) ([]string, error)
+	// Remove deletes the named file.
+	Remove(name string) error
+}
+
+// DefaultStorage is used when no storage is given with WithStorage.
+var DefaultStorage Storage = LocalStorage{}
+
+// LocalStorage keeps files on the local filesystem, relative to Dir (the
+// working directory when Dir is empty).
+type LocalStorage struct {
+	Dir string
+}
+
+func (s LocalStorage) path(name string) string {
+	return filepath.Join(s.Dir, filepath.FromSlash(name))
+}
+
+func (s LocalStorage) Open(name string) (io.ReadCloser, error) {
+	return os.Open(s.path(name))
+}
+
+func (s LocalStorage) Create(name string) (io.WriteCloser, error) {
+	p := s.path(name)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return nil, err
+	}
+	return os.Create(p)
+}
+
+func (s LocalStorage) Rename(oldName, newName string) error {
+	return os.Rename(s.path(oldName), s.path(newName))
+}
+
+func (s LocalStorage) Size(name string) (int64, error) {
+	info, err := os.Stat(s.path(name))
+	if err != nil {
+		return 0, err
+	}
+	if info.IsDir() {
+		return 0, &fs.PathError{Op: "size", Path: name, Err: fs.ErrInvalid}
+	}
+	return info.Size(), nil
+}
+
+func (s LocalStorage) Remove(name string) error {
+	return os.Remove(s.path(name))
+}
+
+// List walks the directory holding prefix, only descending into the
+// subdirectories that can contain matching names.
+func (s LocalStorage) List(prefix string) ([]string, error) {
+	root := path.Dir(prefix)
+	if strings.HasSuffix(prefix, "/") {
+		root = strings.TrimSuffix(prefix, "/")
+	}
+	var names []string
+	err := filepath.WalkDir(s.path(root), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(s.path(root), p)
+		if err != nil {
+			return err
+		}
+		name := path.Join(root, filepath.ToSlash(rel))
+		if d.IsDir() {
+			if name != root && !strings.HasPrefix(name+"/", prefix) && !strings.HasPrefix(prefix, name+"/") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	sort.Strings(names)
+	return names, err
+}
+
+// tmpName returns a unique scratch name next to name. Tasks write their
+// output under such a name and rename it into place once it is complete,
+// so that a crashed or duplicated attempt never leaves a partial file
+// behind under the final name.
+func tmpName(name string) string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return name + ".tmp-" + hex.EncodeToString(b)
+}
+
+// readAll returns the whole content of the named file.
+func readAll(store Storage, name string) ([]byte, error) {
+	r, err := store.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// taskOutput is a file being written by a task. Writes go to a temporary
+// name; Commit closes it and renames it to its final name.
+type taskOutput struct {
+	io.WriteCloser
+	store Storage
+	name  string
+	tmp   string
+}
+
+func createTaskOutput(store Storage, name string) (*taskOutput, error) {
+	tmp := tmpName(name)
+	w, err := store.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	return &taskOutput{WriteCloser: w, store: store, name: name, tmp: tmp}, nil
+}
+
+// Commit publishes the file under its final name.
+func (o *taskOutput) Commit() error {
+	if err := o.Close(); err != nil {
+		o.store.Remove(o.tmp)
+		return err
+	}
+	return o.store.Rename(o.tmp, o.name)
+}
+
+// Abort discards the file.
+func (o *taskOutput) Abort() {
+	o.Close()
+	o.store.Remove(o.tmp)
+}
@@ -0,0 +1,113 @@
+package mapreduce
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemStorage keeps files in memory. It is mainly meant for tests, and for
+// running whole jobs in a single process without touching the disk.
+type MemStorage struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+func NewMemStorage() *MemStorage {
+	return &MemStorage{files: make(map[string][]byte)}
+}
+
+// WriteFile stores data under name, replacing any previous content.
+func (s *MemStorage) WriteFile(name string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[name] = append([]byte(nil), data...)
+}
+
+// ReadFile returns the content stored under name.
+func (s *MemStorage) ReadFile(name string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return append([]byte(nil), data...), nil
+}
+
+func (s *MemStorage) Open(name string) (io.ReadCloser, error) {
+	data, err := s.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *MemStorage) Create(name string) (io.WriteCloser, error) {
+	s.WriteFile(name, nil)
+	return &memFile{store: s, name: name}, nil
+}
+
+func (s *MemStorage) Rename(oldName, newName string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.files[oldName]
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrNotExist}
+	}
+	delete(s.files, oldName)
+	s.files[newName] = data
+	return nil
+}
+
+func (s *MemStorage) List(prefix string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var names []string
+	for name := range s.files {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *MemStorage) Size(name string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.files[name]
+	if !ok {
+		return 0, &fs.PathError{Op: "size", Path: name, Err: fs.ErrNotExist}
+	}
+	return int64(len(data)), nil
+}
+
+func (s *MemStorage) Remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	delete(s.files, name)
+	return nil
+}
+
+// memFile buffers writes and publishes them to the store on Close.
+type memFile struct {
+	store *MemStorage
+	name  string
+	buf   bytes.Buffer
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	return f.buf.Write(p)
+}
+
+func (f *memFile) Close() error {
+	f.store.WriteFile(f.name, f.buf.Bytes())
+	return nil
+}
@@ -0,0 +1,305 @@
+package mapreduce
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Storage keeps files as objects of a bucket on an S3-compatible server
+// (AWS S3, MinIO, Ceph...). Requests use path-style addressing and are
+// signed with AWS signature version 4.
+type S3Storage struct {
+	Endpoint  string // e.g. "http://localhost:9000"
+	Bucket    string
+	Region    string // defaults to "us-east-1"
+	AccessKey string
+	SecretKey string
+	Prefix    string       // prepended to every object name
+	Client    *http.Client // defaults to http.DefaultClient
+}
+
+func (s *S3Storage) Open(name string) (io.ReadCloser, error) {
+	resp, err := s.do("GET", name, nil, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if err := checkS3Response(resp); err != nil {
+		return nil, fmt.Errorf("s3 open %s: %w", name, err)
+	}
+	return resp.Body, nil
+}
+
+// Create buffers the object in memory and uploads it when the writer is
+// closed.
+func (s *S3Storage) Create(name string) (io.WriteCloser, error) {
+	return &s3Object{store: s, name: name}, nil
+}
+
+// Rename copies the object server-side, then deletes the original.
+func (s *S3Storage) Rename(oldName, newName string) error {
+	header := http.Header{}
+	header.Set("x-amz-copy-source", "/"+s.Bucket+"/"+s3Escape(s.Prefix+oldName))
+	resp, err := s.do("PUT", newName, nil, header, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return &fs.PathError{Op: "rename", Path: oldName, Err: fs.ErrNotExist}
+	}
+	if err := checkS3Response(resp); err != nil {
+		return fmt.Errorf("s3 rename %s: %w", oldName, err)
+	}
+	resp.Body.Close()
+	return s.Remove(oldName)
+}
+
+// Size asks the server for the object length with a HEAD request.
+func (s *S3Storage) Size(name string) (int64, error) {
+	resp, err := s.do("HEAD", name, nil, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return 0, &fs.PathError{Op: "size", Path: name, Err: fs.ErrNotExist}
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("s3 size %s: %s", name, resp.Status)
+	}
+	return resp.ContentLength, nil
+}
+
+func (s *S3Storage) Remove(name string) error {
+	resp, err := s.do("DELETE", name, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	if err := checkS3Response(resp); err != nil {
+		return fmt.Errorf("s3 remove %s: %w", name, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// listBucketResult is the part of a ListObjectsV2 response we use.
+type listBucketResult struct {
+	Contents []struct {
+		Key string
+	}
+	IsTruncated           bool
+	NextContinuationToken string
+}
+
+func (s *S3Storage) List(prefix string) ([]string, error) {
+	var names []string
+	token := ""
+	for {
+		query := url.Values{}
+		query.Set("list-type", "2")
+		query.Set("prefix", s.Prefix+prefix)
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := s.do("GET", "", query, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := checkS3Response(resp); err != nil {
+			return nil, fmt.Errorf("s3 list %s: %w", prefix, err)
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("s3 list %s: %w", prefix, err)
+		}
+		for _, c := range result.Contents {
+			names = append(names, strings.TrimPrefix(c.Key, s.Prefix))
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			break
+		}
+		token = result.NextContinuationToken
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// s3Object is the writer returned by S3Storage.Create.
+type s3Object struct {
+	store *S3Storage
+	name  string
+	buf   bytes.Buffer
+}
+
+func (o *s3Object) Write(p []byte) (int, error) {
+	return o.buf.Write(p)
+}
+
+func (o *s3Object) Close() error {
+	resp, err := o.store.do("PUT", o.name, nil, nil, o.buf.Bytes())
+	if err != nil {
+		return err
+	}
+	if err := checkS3Response(resp); err != nil {
+		return fmt.Errorf("s3 create %s: %w", o.name, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do sends a signed request for the object name (or for the bucket itself
+// when name is empty).
+func (s *S3Storage) do(method, name string, query url.Values, header http.Header, body []byte) (*http.Response, error) {
+	u, err := url.Parse(strings.TrimSuffix(s.Endpoint, "/"))
+	if err != nil {
+		return nil, err
+	}
+	u.Path = "/" + s.Bucket
+	if name != "" {
+		u.Path += "/" + s.Prefix + name
+	}
+	u.RawPath = "/" + s.Bucket
+	if name != "" {
+		u.RawPath += "/" + s3Escape(s.Prefix+name)
+	}
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	s.sign(req, body, time.Now().UTC())
+
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+// sign adds the AWS signature version 4 headers to req.
+func (s *S3Storage) sign(req *http.Request, body []byte, now time.Time) {
+	region := s.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	amzDate := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+	}
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var canonicalHeaders strings.Builder
+	for _, k := range keys {
+		canonicalHeaders.WriteString(k + ":" + headers[k] + "\n")
+	}
+	signedHeaders := strings.Join(keys, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKey+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func checkS3Response(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	resp.Body.Close()
+	return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+}
+
+// s3Escape escapes an object name as required by the signature: every byte
+// but the unreserved characters and '/' is percent-encoded.
+func s3Escape(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// canonicalQuery encodes query with its keys sorted, as the signature
+// expects.
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		for _, v := range query[k] {
+			parts = append(parts, strings.ReplaceAll(s3Escape(k), "/", "%2F")+"="+strings.ReplaceAll(s3Escape(v), "/", "%2F"))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,62 @@
+package mapreduce
+
+import "time"
+
+// Statuses of a task attempt.
+const (
+	AttemptRunning   = "running"
+	AttemptCompleted = "completed"
+	AttemptTimeout   = "timeout"
+	AttemptFailed    = "failed"
+)
+
+// TaskAttempt is a run of a task by a worker, as shown on the timeline of
+// the dashboard.
+type TaskAttempt struct {
+	JobName string    `json:"JobName"`
+	TaskID  int       `json:"TaskID"`
+	Type    string    `json:"Type"` // "map" or "reduce"
+	Attempt int       `json:"Attempt"`
+	Worker  string    `json:"Worker"`
+	Start   time.Time `json:"Start"`
+	End     time.Time `json:"End,omitzero"` // zero while running
+	Status  string    `json:"Status"`
+	Backup  bool      `json:"Backup"` // an earlier attempt timed out and may still be running
+}
+
+// startAttempt records that task was just assigned. The caller must hold
+// m.mu.
+func (m *Master) startAttempt(job *distJob, task Task) {
+	backup := false
+	for _, a := range job.attempts {
+		if a.TaskID == task.TaskID && a.Status == AttemptTimeout {
+			backup = true
+		}
+	}
+	a := TaskAttempt{
+		JobName: job.name,
+		TaskID:  task.TaskID,
+		Type:    task.Type,
+		Attempt: task.Attempts,
+		Worker:  task.Worker,
+		Start:   task.StartTime,
+		Status:  AttemptRunning,
+		Backup:  backup,
+	}
+	job.attempts = append(job.attempts, a)
+	m.publish(sseAttempt, a)
+}
+
+// endAttempt records the end of the running attempt of task. The caller
+// must hold m.mu.
+func (m *Master) endAttempt(job *distJob, task Task, status string) {
+	for i := len(job.attempts) - 1; i >= 0; i-- {
+		a := &job.attempts[i]
+		if a.TaskID == task.TaskID && a.Status == AttemptRunning {
+			a.End = time.Now()
+			a.Status = status
+			m.publish(sseAttempt, *a)
+			return
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package mapreduce
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+// Default addresses of the master.
+const (
+	DefaultRPCAddr       = ":1234"
+	DefaultDashboardAddr = ":8080"
+)
+
+// WithRPCAddr sets the address the master accepts worker connections on.
+// With port 0 a free port is picked, see Master.RPCAddr.
+func WithRPCAddr(addr string) Option {
+	return func(c *config) {
+		c.rpcAddr = addr
+	}
+}
+
+// WithDashboardAddr sets the address the master serves its dashboard, API
+// and metrics on. With port 0 a free port is picked, see
+// Master.DashboardAddr.
+func WithDashboardAddr(addr string) Option {
+	return func(c *config) {
+		c.dashboardAddr = addr
+	}
+}
+
+// WithOnServe makes the master call f once it serves, with the addresses it
+// listens on, e.g. to start workers for StartDistributed on a free port.
+func WithOnServe(f func(rpcAddr, dashboardAddr string)) Option {
+	return func(c *config) {
+		c.onServe = f
+	}
+}
+
+// WithTLS secures the connections between the master and the workers, and
+// the dashboard, with TLS. The master uses tlsConfig as server
+// configuration and workers as client configuration; LoadTLSConfig builds
+// one that fits both.
+func WithTLS(tlsConfig *tls.Config) Option {
+	return func(c *config) {
+		c.tlsConfig = tlsConfig
+	}
+}
+
+// LoadTLSConfig returns a TLS configuration presenting the certificate in
+// certFile and keyFile. When caFile is not empty, the certificates of the
+// peers must be signed by the CAs it holds, in both directions: workers and
+// dashboard clients then need a certificate as well.
+func LoadTLSConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", caFile)
+		}
+		cfg.RootCAs = pool
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// listen listens on addr, with TLS when tlsConfig is not nil.
+func listen(addr string, tlsConfig *tls.Config) (net.Listener, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		l = tls.NewListener(l, tlsConfig)
+	}
+	return l, nil
+}
+
+// dialTimeout is how long dial waits for a connection to be established.
+const dialTimeout = 10 * time.Second
+
+// dial connects to addr, with TLS when tlsConfig is not nil.
+func dial(addr string, tlsConfig *tls.Config) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	if tlsConfig != nil {
+		return tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	}
+	return dialer.Dial("tcp", addr)
+}
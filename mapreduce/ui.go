@@ -0,0 +1,26 @@
+package mapreduce
+
+import (
+	"embed"
+	"html/template"
+	"io/fs"
+	"net/http"
+)
+
+// The pages of the dashboard and of the history server, with their scripts
+// and styles, are built into the binary so that they are served from any
+// working directory and without network access.
+//
+//go:embed ui
+var uiFiles embed.FS
+
+var dashboardTemplate = template.Must(template.ParseFS(uiFiles, "ui/dashboard.html"))
+
+// staticHandler serves the scripts and styles of the pages at /static/.
+func staticHandler() http.Handler {
+	static, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix("/static/", http.FileServerFS(static))
+}
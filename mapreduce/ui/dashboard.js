@@ -0,0 +1,356 @@
+// State of the dashboard, updated by the events of /events
+const taskRows = new Map(); // "job/taskID" -> table row
+const workerRows = new Map(); // worker name -> table row
+const attempts = new Map(); // "job/taskID/attempt" -> task attempt
+const jobs = new Set(); // names of the jobs with tasks shown
+let paused = false;
+let counters = {};
+
+function taskClass(task) {
+  return task.Status === "completed"
+    ? "bg-green-100"
+    : task.Status === "in-progress"
+    ? "bg-yellow-100"
+    : "bg-red-100";
+}
+
+function workerClass(worker) {
+  return worker.Status === "Idle"
+    ? "bg-green-100"
+    : worker.Status === "Lost" || worker.Status === "Blacklisted"
+    ? "bg-red-100"
+    : "bg-yellow-100";
+}
+
+// control sends a control action to the master, with the admin token the
+// user is asked for the first time
+async function control(path) {
+  let token = sessionStorage.getItem("adminToken");
+  if (!token) {
+    token = prompt("Admin token of the master:");
+    if (!token) {
+      return;
+    }
+    sessionStorage.setItem("adminToken", token);
+  }
+  try {
+    const response = await fetch(path, {
+      method: "POST",
+      headers: { Authorization: `Bearer ${token}` },
+    });
+    if (response.status === 401) {
+      sessionStorage.removeItem("adminToken");
+    }
+    if (!response.ok) {
+      alert(`${path}: ${await response.text()}`);
+    }
+  } catch (error) {
+    console.error("Error sending control action:", error);
+  }
+}
+
+function togglePause() {
+  control(paused ? "/api/resume" : "/api/pause");
+}
+
+function updatePaused(value) {
+  paused = value;
+  const button = document.getElementById("pause-button");
+  button.textContent = paused ? "Resume scheduling" : "Pause scheduling";
+  button.className = `btn ${paused ? "bg-blue-600" : "bg-gray-600"}`;
+}
+
+function updateJobs(job) {
+  if (jobs.has(job)) {
+    return;
+  }
+  jobs.add(job);
+  const button = document.createElement("button");
+  button.className = "btn bg-red-600";
+  button.textContent = `Cancel ${job}`;
+  button.onclick = () => {
+    if (confirm(`Cancel job ${job}?`)) {
+      control(`/api/jobs/${encodeURIComponent(job)}/cancel`);
+    }
+  };
+  document.getElementById("jobs-controls").appendChild(button);
+}
+
+function actionButton(label, color, path) {
+  return `<button class="btn ${color}" onclick="control('${path}')">${label}</button>`;
+}
+
+function updateProgress(progress) {
+  const progressBar = document.getElementById("progress-bar");
+  progress = Math.round(progress);
+  progressBar.style.width = `${progress}%`;
+  progressBar.textContent = `${progress}%`;
+}
+
+function updateTask(task) {
+  const key = `${task.JobName}/${task.TaskID}`;
+  let row = taskRows.get(key);
+  if (!row) {
+    row = document.createElement("tr");
+    document.getElementById("tasks-table").appendChild(row);
+    taskRows.set(key, row);
+  }
+  updateJobs(task.JobName);
+  const job = encodeURIComponent(task.JobName);
+  row.className = taskClass(task);
+  row.innerHTML = `
+              <td class="py-2 px-4 border-b">${task.TaskID}</td>
+              <td class="py-2 px-4 border-b">${task.Type}</td>
+              <td class="py-2 px-4 border-b">${task.File}</td>
+              <td class="py-2 px-4 border-b">${task.Status}</td>
+              <td class="py-2 px-4 border-b">${task.Worker || "None"}</td>
+              <td class="py-2 px-4 border-b">${
+                task.Status === "in-progress"
+                  ? actionButton("Retry", "bg-yellow-500", `/api/jobs/${job}/tasks/${task.TaskID}/retry`)
+                  : ""
+              }</td>
+          `;
+}
+
+// workerTasks shows the tasks in progress on a worker, out of its slots
+function workerTasks(worker) {
+  const { Tasks: tasks, Slots: slots } = worker;
+  if (!slots.Map && !slots.Reduce) {
+    return `${tasks.Map + tasks.Reduce}`;
+  }
+  return `map ${tasks.Map}/${slots.Map}, reduce ${tasks.Reduce}/${slots.Reduce}`;
+}
+
+function updateWorker(worker) {
+  let row = workerRows.get(worker.Name);
+  if (!row) {
+    row = document.createElement("tr");
+    document.getElementById("workers-table").appendChild(row);
+    workerRows.set(worker.Name, row);
+  }
+  row.className = workerClass(worker);
+  row.innerHTML = `
+              <td class="py-2 px-4 border-b">${worker.Name}</td>
+              <td class="py-2 px-4 border-b">${worker.Status}</td>
+              <td class="py-2 px-4 border-b">${workerTasks(worker)}</td>
+              <td class="py-2 px-4 border-b">${workerActions(worker)}</td>
+          `;
+}
+
+function updateAttempt(attempt) {
+  attempts.set(`${attempt.JobName}/${attempt.TaskID}/${attempt.Attempt}`, attempt);
+  renderTimeline();
+}
+
+// renderTimeline draws the attempts as a Gantt chart with a row per worker,
+// from the start of the first attempt to now, or to the end of the last one
+function renderTimeline() {
+  const timeline = document.getElementById("timeline");
+  const all = [...attempts.values()];
+  if (all.length === 0) {
+    return;
+  }
+  const running = all.some((a) => a.Status === "running");
+  const start = Math.min(...all.map((a) => Date.parse(a.Start)));
+  const end = running
+    ? Date.now()
+    : Math.max(...all.map((a) => Date.parse(a.End)));
+  const span = Math.max(end - start, 1);
+
+  const byWorker = new Map();
+  all.forEach((a) => {
+    if (!byWorker.has(a.Worker)) {
+      byWorker.set(a.Worker, []);
+    }
+    byWorker.get(a.Worker).push(a);
+  });
+
+  timeline.innerHTML = "";
+  [...byWorker.keys()].sort().forEach((worker) => {
+    const row = document.createElement("div");
+    row.className = "gantt-row";
+    row.innerHTML = `<div class="gantt-label">${worker}</div>`;
+    const track = document.createElement("div");
+    track.className = "gantt-track";
+    byWorker.get(worker).forEach((a) => {
+      const from = Date.parse(a.Start);
+      const to = a.Status === "running" ? end : Date.parse(a.End);
+      const bar = document.createElement("div");
+      bar.className = `gantt-bar gantt-${a.Type}`;
+      if (a.Status !== "completed") {
+        bar.classList.add(`gantt-${a.Status}`);
+      }
+      if (a.Backup) {
+        bar.classList.add("gantt-backup");
+      }
+      bar.style.left = `${((from - start) / span) * 100}%`;
+      bar.style.width = `${Math.max(((to - from) / span) * 100, 0.5)}%`;
+      bar.title =
+        `${a.JobName} ${a.Type} task ${a.TaskID}, attempt ${a.Attempt}` +
+        `${a.Backup ? " (backup)" : ""}: ${a.Status} after ` +
+        `${((to - from) / 1000).toFixed(2)}s`;
+      track.appendChild(bar);
+    });
+    row.appendChild(track);
+    timeline.appendChild(row);
+  });
+
+  const axis = document.createElement("div");
+  axis.className = "gantt-axis text-gray-600";
+  axis.innerHTML = `<span>0s</span><span>${(span / 1000).toFixed(1)}s</span>`;
+  timeline.appendChild(axis);
+}
+
+function workerActions(worker) {
+  const path = `/api/workers/${encodeURIComponent(worker.Name)}`;
+  if (["Blacklisted", "Draining", "Drained"].includes(worker.Status)) {
+    return actionButton("Reinstate", "bg-blue-600", `${path}/reinstate`);
+  }
+  return (
+    actionButton("Drain", "bg-yellow-500", `${path}/drain`) +
+    actionButton("Blacklist", "bg-red-600", `${path}/blacklist`)
+  );
+}
+
+function updateCounters() {
+  const countersTable = document.getElementById("counters-table");
+  countersTable.innerHTML = "";
+  Object.keys(counters).sort().forEach((job) => {
+    const jobCounters = counters[job];
+    Object.keys(jobCounters).sort().forEach((name) => {
+      const row = document.createElement("tr");
+      row.innerHTML = `
+              <td class="py-2 px-4 border-b">${job}</td>
+              <td class="py-2 px-4 border-b">${name}</td>
+              <td class="py-2 px-4 border-b">${jobCounters[name]}</td>
+          `;
+      countersTable.appendChild(row);
+    });
+  });
+}
+
+// Offset of the next page of results, -1 after the last one
+let resultsNext = 0;
+
+// addResultJob makes the results of a job available, and shows them
+function addResultJob(job) {
+  const select = document.getElementById("results-job");
+  if (![...select.options].some((option) => option.value === job)) {
+    select.appendChild(new Option(job, job));
+  }
+  select.value = job;
+  document.getElementById("results-pending").classList.add("hidden");
+  document.getElementById("results-browser").classList.remove("hidden");
+  searchResults();
+}
+
+function resultParams() {
+  return new URLSearchParams({
+    prefix: document.getElementById("results-prefix").value,
+    q: document.getElementById("results-search").value,
+  });
+}
+
+function resultsPath() {
+  const job = document.getElementById("results-job").value;
+  return `/api/results/${encodeURIComponent(job)}`;
+}
+
+// searchResults shows the first page of results matching the search
+function searchResults(event) {
+  if (event) {
+    event.preventDefault();
+  }
+  document.getElementById("results-table").innerHTML = "";
+  resultsNext = 0;
+  ["jsonl", "json", "csv", "tsv"].forEach((format) => {
+    const params = resultParams();
+    params.set("format", format);
+    document.getElementById(`download-${format}`).href =
+      `${resultsPath()}/download?${params}`;
+  });
+  loadResults();
+}
+
+// loadResults appends the next page of results to the table
+async function loadResults() {
+  if (resultsNext < 0) {
+    return;
+  }
+  const params = resultParams();
+  params.set("offset", resultsNext);
+  try {
+    const response = await fetch(`${resultsPath()}?${params}`);
+    if (!response.ok) {
+      throw new Error(await response.text());
+    }
+    const page = await response.json();
+    const resultsTable = document.getElementById("results-table");
+    page.Records.forEach((record) => {
+      const row = document.createElement("tr");
+      [record.Key, record.Value].forEach((text) => {
+        const cell = document.createElement("td");
+        cell.className = "py-2 px-4 border-b whitespace-pre-wrap";
+        cell.textContent = text;
+        row.appendChild(cell);
+      });
+      resultsTable.appendChild(row);
+    });
+    resultsNext = page.Next;
+    document
+      .getElementById("results-more")
+      .classList.toggle("hidden", resultsNext < 0);
+  } catch (error) {
+    console.error("Error fetching results:", error);
+  }
+}
+
+// A snapshot is sent on every (re)connection and replaces everything
+function loadSnapshot(data) {
+  taskRows.clear();
+  workerRows.clear();
+  attempts.clear();
+  jobs.clear();
+  document.getElementById("jobs-controls").innerHTML = "";
+  document.getElementById("tasks-table").innerHTML = "";
+  document.getElementById("workers-table").innerHTML = "";
+  data.Tasks.forEach(updateTask);
+  data.Workers.forEach(updateWorker);
+  data.Attempts.forEach((a) =>
+    attempts.set(`${a.JobName}/${a.TaskID}/${a.Attempt}`, a)
+  );
+  renderTimeline();
+  counters = data.Counters || {};
+  updateCounters();
+  updateProgress(data.Progress);
+  updatePaused(data.Paused);
+  document.getElementById("results-job").innerHTML = "";
+  if (data.Results.length > 0) {
+    data.Results.forEach((job) =>
+      document.getElementById("results-job").appendChild(new Option(job, job))
+    );
+    addResultJob(data.Results[data.Results.length - 1]);
+  }
+}
+
+const events = new EventSource("/events");
+events.addEventListener("snapshot", (e) => loadSnapshot(JSON.parse(e.data)));
+events.addEventListener("task", (e) => updateTask(JSON.parse(e.data)));
+events.addEventListener("worker", (e) => updateWorker(JSON.parse(e.data)));
+events.addEventListener("attempt", (e) => updateAttempt(JSON.parse(e.data)));
+events.addEventListener("paused", (e) => updatePaused(JSON.parse(e.data).Paused));
+events.addEventListener("progress", (e) => updateProgress(JSON.parse(e.data).Progress));
+events.addEventListener("counters", (e) => {
+  const data = JSON.parse(e.data);
+  counters[data.Job] = data.Counters;
+  updateCounters();
+});
+events.addEventListener("result", (e) => addResultJob(JSON.parse(e.data).Job));
+events.onerror = (error) => console.error("Event stream error:", error);
+
+// Running attempts grow with time
+setInterval(() => {
+  if ([...attempts.values()].some((a) => a.Status === "running")) {
+    renderTimeline();
+  }
+}, 1000);
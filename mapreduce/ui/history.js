@@ -0,0 +1,85 @@
+function formatTime(time) {
+  return time && !time.startsWith("0001") ? new Date(time).toLocaleString() : "-";
+}
+
+async function fetchJobs() {
+  try {
+    const response = await fetch("/jobs");
+    const jobs = await response.json();
+    const jobsTable = document.getElementById("jobs-table");
+    jobsTable.innerHTML = "";
+    jobs.forEach((job) => {
+      const row = document.createElement("tr");
+      row.className =
+        (job.Status === "completed" ? "bg-green-100" : "bg-red-100") +
+        " cursor-pointer";
+      row.innerHTML = `
+              <td class="py-2 px-4 border-b">${job.Name}</td>
+              <td class="py-2 px-4 border-b">${job.Status}</td>
+              <td class="py-2 px-4 border-b">${formatTime(job.Submitted)}</td>
+              <td class="py-2 px-4 border-b">${formatTime(job.Finished)}</td>
+              <td class="py-2 px-4 border-b">${job.Config ? job.Config.Inputs.length : "-"}</td>
+              <td class="py-2 px-4 border-b">${job.Config ? job.Config.NReduce : "-"}</td>
+          `;
+      row.onclick = () => fetchJob(job.Name);
+      jobsTable.appendChild(row);
+    });
+  } catch (error) {
+    console.error("Error fetching jobs:", error);
+  }
+}
+
+async function fetchJob(name) {
+  try {
+    const response = await fetch(`/jobs/${encodeURIComponent(name)}`);
+    showJob(await response.json());
+  } catch (error) {
+    console.error("Error fetching job:", error);
+  }
+}
+
+function showJob(job) {
+  document.getElementById("job-section").classList.remove("hidden");
+  document.getElementById("job-title").textContent = `Job ${job.Name}`;
+  document.getElementById("job-config").textContent = JSON.stringify(
+    job.Config,
+    null,
+    2
+  );
+
+  const countersTable = document.getElementById("counters-table");
+  countersTable.innerHTML = "";
+  const counters = job.Counters || {};
+  Object.keys(counters).sort().forEach((name) => {
+    const row = document.createElement("tr");
+    row.innerHTML = `
+              <td class="py-2 px-4 border-b">${name}</td>
+              <td class="py-2 px-4 border-b">${counters[name]}</td>
+          `;
+    countersTable.appendChild(row);
+  });
+
+  const eventsTable = document.getElementById("events-table");
+  eventsTable.innerHTML = "";
+  (job.Events || []).forEach((event) => {
+    const row = document.createElement("tr");
+    row.className =
+      event.Type === "task-completed" || event.Type === "job-completed"
+        ? "bg-green-100"
+        : ["task-timeout", "task-failed", "worker-lost", "job-cancelled"].includes(event.Type)
+        ? "bg-red-100"
+        : "";
+    const task = event.Task;
+    row.innerHTML = `
+              <td class="py-2 px-4 border-b">${formatTime(event.Time)}</td>
+              <td class="py-2 px-4 border-b">${event.Type}</td>
+              <td class="py-2 px-4 border-b">${task ? `${task.Type} ${task.ID} (${task.File})` : ""}</td>
+              <td class="py-2 px-4 border-b">${task ? task.Attempt : ""}</td>
+              <td class="py-2 px-4 border-b">${event.Worker || ""}</td>
+              <td class="py-2 px-4 border-b">${event.Error || ""}</td>
+          `;
+    eventsTable.appendChild(row);
+  });
+}
+
+fetchJobs();
@@ -1,10 +1,12 @@
 package mapreduce
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
-	"math/rand"
-	"net/rpc"
+	"os"
+	"sync"
 	"time"
 )
 
@@ -12,78 +14,298 @@ import (
 type Worker struct {
 	id         string
 	masterAddr string
-	client     *rpc.Client
 	mapF       func(string) []KeyValue
 	reduceF    func(string, []string) string
+	opts       []Option
+	cfg        *config
+
+	mu      sync.Mutex // guards client, session, addr and running, see call
+	client  rpcClient
+	session string   // see Master.Register
+	addrs   []string // of the master, none when it runs in this process
+	addr    int      // index in addrs of the address connected to
+	running Slots    // tasks running, see WithSlots
+
+	idleWait time.Duration  // between two GetTask calls when there is no task
+	faults   *faultInjector // see WithFaults
+}
+
+// rpcClient calls the RPC methods of the master: an *rpc.Client, or a
+// localMaster in this process.
+type rpcClient interface {
+	Call(serviceMethod string, args any, reply any) error
 }
 
 func NewWorker(id string, masterAddr string,
 	mapF func(string) []KeyValue,
-	reduceF func(string, []string) string) *Worker {
+	reduceF func(string, []string) string,
+	opts ...Option) *Worker {
 	return &Worker{
 		id:         id,
 		masterAddr: masterAddr,
 		mapF:       mapF,
 		reduceF:    reduceF,
+		opts:       opts,
+		idleWait:   time.Second,
 	}
 }
 
-// Start begins the worker's task execution loop
-func (w *Worker) Start() {
-	// Connect to the Master
-	client, err := rpc.Dial("tcp", w.masterAddr)
-	CheckError(err, "Failed to connect to Master at %s: %v\n", w.masterAddr, err)
-	w.client = client
+// Start begins the worker's task execution loop. It returns when the master
+// tells the worker to exit, or once the context of the worker is done, see
+// WithContext, stopping the task it runs. When it loses the master, the
+// worker connects again, see WithMasterAddrs, and returns an error only if
+// it cannot for WithReconnectTimeout or if the master refuses it.
+func (w *Worker) Start() error {
+	w.cfg = newConfig(w.opts)
+	w.addrs = append([]string{w.masterAddr}, w.cfg.masterAddrs...)
+	return w.run()
+}
 
+// run connects to the master and runs the tasks it gives until the worker
+// is stopped. After an injected crash, see Faults, it starts again.
+func (w *Worker) run() error {
+	w.faults = newFaultInjector(w.cfg.faults, w.id)
 	for {
+		err := w.runSession()
+		if !errors.Is(err, errCrashed) {
+			return err
+		}
+		log.Printf("Worker %s restarts after a crash\n", w.id)
+	}
+}
+
+// runSession opens a session with the master and runs the tasks it gives,
+// as many at once as the worker has slots for, until the worker is stopped
+// or crashes.
+func (w *Worker) runSession() error {
+	ctx, crash := context.WithCancelCause(w.cfg.ctx)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	defer crash(nil)
+	w.mu.Lock()
+	err := w.connect()
+	w.mu.Unlock()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+
+	taskDone := make(chan struct{}, 1)
+	for {
+		if ctx.Err() != nil {
+			return sessionEnd(ctx)
+		}
+		free, ok := w.freeSlots()
+		if !ok {
+			select {
+			case <-ctx.Done():
+			case <-taskDone:
+			}
+			continue
+		}
+
 		// Request a task
-		args := &TaskArgs{WorkerID: w.id}
+		args := &TaskArgs{WorkerID: w.id, Free: free}
 		var reply TaskReply
-		err := w.client.Call("Master.GetTask", args, &reply)
-		CheckError(err, "Failed to call GetTask: %v\n", err)
+		start := time.Now()
+		err := w.call("Master.GetTask", args, &reply)
+		workerMetrics.rpcDuration.ObserveSince(start, w.id, "GetTask")
+		if err != nil {
+			if ctx.Err() != nil {
+				return sessionEnd(ctx)
+			}
+			return fmt.Errorf("worker %s cannot get a task: %w", w.id, err)
+		}
 
+		if reply.Exit {
+			log.Printf("Worker %s exiting: the master shuts down\n", w.id)
+			return nil
+		}
 		if !reply.Available {
-			time.Sleep(1 * time.Second)
+			select {
+			case <-ctx.Done():
+			case <-taskDone:
+			case <-time.After(w.idleWait):
+			}
 			continue
 		}
+		task := reply.Task
 
-		if w.simulateFailure() {
-			log.Printf("Worker %s simulating crash for task %d\n", w.id, reply.Task.TaskID)
-			return
+		// Refuse the tasks reaching outside of the sandbox
+		if err := checkTask(task, w.cfg); err != nil {
+			log.Printf("Worker %s refuses task %d: %v\n", w.id, task.TaskID, err)
+			w.reportFailed(task, err.Error())
+			continue
 		}
-		if w.simulateDelay() {
-			log.Printf("Worker %s simulating delay for task %d\n", w.id, reply.Task.TaskID)
-			time.Sleep(5 * time.Second)
+
+		if w.faults.happens(w.faults.CrashBeforeOutput) {
+			log.Printf("Worker %s crashes before running task %d\n", w.id, task.TaskID)
+			crash(errCrashed)
+			continue
 		}
 
-		if reply.Task.Type == "map" {
-			DoMap(reply.Task.JobName, reply.Task.MapNum, reply.Task.File, reply.Task.NReduce, w.mapF)
-		} else if reply.Task.Type == "reduce" {
-			DoReduce(reply.Task.JobName, reply.Task.ReduceNum, reply.Task.NMap, w.reduceF)
+		mapF, reduceF, opts, err := w.funcs(task)
+		if err != nil {
+			log.Printf("Worker %s cannot run task %d: %v\n", w.id, task.TaskID, err)
+			w.reportFailed(task, err.Error())
+			continue
 		}
+		w.mu.Lock()
+		w.running = w.running.add(task.Type, 1)
+		w.mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w.runTask(ctx, crash, task, mapF, reduceF, opts)
+			w.mu.Lock()
+			w.running = w.running.add(task.Type, -1)
+			w.mu.Unlock()
+			select {
+			case taskDone <- struct{}{}:
+			default:
+			}
+		}()
+	}
+}
+
+// runTask runs a task until it completes, or the master or the worker stops
+// it, then reports it done. It injects the faults of the worker on the way.
+func (w *Worker) runTask(ctx context.Context, crash context.CancelCauseFunc, task Task,
+	mapF func(string) []KeyValue, reduceF func(string, []string) string, opts []Option) {
+	counters := NewCounters()
+	taskCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go w.heartbeat(taskCtx, cancel, task, w.cfg.heartbeatInterval)
+	if w.faults.happens(w.faults.Delay) {
+		log.Printf("Worker %s delays task %d by %v\n", w.id, task.TaskID, w.faults.DelayDuration)
+		select {
+		case <-taskCtx.Done():
+		case <-time.After(w.faults.DelayDuration):
+		}
+	}
+	opts = append(opts, WithCounters(counters), WithContext(taskCtx))
+	start := time.Now()
+	var err error
+	if task.Type == "map" {
+		err = DoMap(task.JobName, task.MapNum, task.File, task.NReduce, mapF, opts...)
+	} else if task.Type == "reduce" {
+		err = DoReduce(task.JobName, task.ReduceNum, task.NMap, reduceF, opts...)
+	}
+	cancel()
+	if err != nil {
+		log.Printf("Worker %s: %v\n", w.id, err)
+		// Have the master run again the map tasks whose output is unreadable,
+		// and count the failure of a task that did not just stop
+		var interErr *IntermediateError
+		if errors.As(err, &interErr) {
+			w.reportFailed(task, err.Error(), interErr.MapTask)
+		} else if !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) {
+			w.reportFailed(task, err.Error())
+		}
+		return
+	}
+
+	if task.Type == "map" && task.NReduce > 0 && w.faults.happens(w.faults.CorruptIntermediate) {
+		if err := w.faults.corrupt(newConfig(opts).storage, task); err != nil {
+			log.Printf("Worker %s cannot corrupt the output of task %d: %v\n", w.id, task.TaskID, err)
+		}
+	}
+	if w.faults.happens(w.faults.CrashAfterOutput) {
+		log.Printf("Worker %s crashes before reporting task %d\n", w.id, task.TaskID)
+		crash(errCrashed)
+		return
+	}
+	workerMetrics.taskDuration.ObserveSince(start, w.id, task.Type)
+	workerMetrics.tasks.Add(1, w.id, task.Type)
 
-		reportArgs := &ReportArgs{TaskID: reply.Task.TaskID, WorkerID: w.id}
-		var reportReply struct{}
-		err = w.client.Call("Master.ReportTaskDone", reportArgs, &reportReply)
-		CheckError(err, "Failed to call ReportTaskDone: %v\n", err)
+	reportArgs := &ReportArgs{
+		JobName:  task.JobName,
+		TaskID:   task.TaskID,
+		WorkerID: w.id,
+		Counters: counters.Snapshot(),
+	}
+	var reportReply struct{}
+	start = time.Now()
+	err = w.call("Master.ReportTaskDone", reportArgs, &reportReply)
+	workerMetrics.rpcDuration.ObserveSince(start, w.id, "ReportTaskDone")
+	if err != nil {
+		// The task times out on the master and runs again
+		log.Printf("Worker %s cannot report task %d: %v\n", w.id, task.TaskID, err)
+	} else if w.faults.happens(w.faults.DuplicateReport) {
+		log.Printf("Worker %s reports task %d again\n", w.id, task.TaskID)
+		w.call("Master.ReportTaskDone", reportArgs, &reportReply)
 	}
 }
 
-func (w *Worker) simulateFailure() bool {
-	return rand.Float64() < 0.1 // 10% chance of failure
+// reportFailed gives back a task the worker cannot run, so that another
+// worker gets it, along with the map tasks whose output it could not read.
+func (w *Worker) reportFailed(task Task, reason string, badInputs ...int) {
+	failArgs := &ReportArgs{
+		JobName:   task.JobName,
+		TaskID:    task.TaskID,
+		WorkerID:  w.id,
+		Error:     reason,
+		BadInputs: badInputs,
+	}
+	var failReply struct{}
+	if err := w.call("Master.ReportTaskFailed", failArgs, &failReply); err != nil {
+		log.Printf("Worker %s cannot report task %d: %v\n", w.id, task.TaskID, err)
+	}
+	select {
+	case <-w.cfg.ctx.Done():
+	case <-time.After(w.idleWait):
+	}
 }
 
-func (w *Worker) simulateDelay() bool {
-	return rand.Float64() < 0.2 // 20% chance of delay
+// funcs returns the functions and options to run task with: those of the
+// app it names, or those the worker was created with. The master only
+// checked the apps, combiner and partitioner of the task against its own
+// registry: they must be registered in this process too.
+func (w *Worker) funcs(task Task) (func(string) []KeyValue, func(string, []string) string, []Option, error) {
+	mapF, reduceF := w.mapF, w.reduceF
+	var opts []Option
+	if task.App != "" {
+		app, ok := lookupApp(task.App)
+		if !ok {
+			return nil, nil, nil, fmt.Errorf("no app %q", task.App)
+		}
+		mapF, reduceF = app.Map, app.Reduce
+		opts = append(opts, app.Options...)
+	}
+	if err := task.Settings.check(); err != nil {
+		return nil, nil, nil, err
+	}
+	opts = append(opts, w.opts...)
+	opts = append(opts, withOutputs(task.Outputs), withSettings(task.Settings))
+	return mapF, reduceF, opts, nil
+}
+
+// DefaultWorkerID returns an ID for the workers of this process, unique in
+// a cluster: the host name and the process ID.
+func DefaultWorkerID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "worker"
+	}
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
 }
 
-// RunWorkers starts multiple workers concurrently
+// RunWorkers starts multiple workers concurrently, named after
+// DefaultWorkerID
 func RunWorkers(masterAddr string, numWorkers int,
 	mapF func(string) []KeyValue,
-	reduceF func(string, []string) string) {
+	reduceF func(string, []string) string,
+	opts ...Option) {
+	prefix := DefaultWorkerID()
 	for i := 0; i < numWorkers; i++ {
-		workerID := fmt.Sprintf("worker-%d", i)
-		worker := NewWorker(workerID, masterAddr, mapF, reduceF)
-		go worker.Start()
+		workerID := fmt.Sprintf("%s-%d", prefix, i)
+		worker := NewWorker(workerID, masterAddr, mapF, reduceF, opts...)
+		go func() {
+			if err := worker.Start(); err != nil {
+				log.Printf("Worker %s stopped: %v\n", workerID, err)
+			}
+		}()
 	}
 }
@@ -0,0 +1,276 @@
+package mapreduce
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// This file parses the subset of YAML job specs are written in: block
+// mappings and sequences nested by indentation, flow sequences and mappings
+// of scalars, quoted and plain scalars, and comments. Anchors, tags,
+// multi-line scalars and multiple documents are not supported.
+
+// yamlLine is a line holding more than a comment, without its indentation.
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// parseYAML returns the value of a YAML document: map[string]any, []any,
+// string, int64, float64, bool or nil.
+func parseYAML(data []byte) (any, error) {
+	p := &yamlParser{}
+	for i, raw := range strings.Split(string(data), "\n") {
+		text := strings.TrimRight(stripYAMLComment(raw), " \t\r")
+		content := strings.TrimLeft(text, " ")
+		if content == "" || content == "---" && i == 0 {
+			continue
+		}
+		if strings.HasPrefix(content, "\t") {
+			return nil, fmt.Errorf("line %d: tabs cannot indent YAML", i+1)
+		}
+		p.lines = append(p.lines, yamlLine{num: i + 1, indent: len(text) - len(content), text: content})
+	}
+	if len(p.lines) == 0 {
+		return map[string]any{}, nil
+	}
+	v, err := p.block(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].num)
+	}
+	return v, nil
+}
+
+// block parses the mapping or sequence starting at the current line.
+func (p *yamlParser) block(indent int) (any, error) {
+	if isYAMLItem(p.lines[p.pos].text) {
+		return p.sequence(indent)
+	}
+	return p.mapping(indent)
+}
+
+func (p *yamlParser) mapping(indent int) (map[string]any, error) {
+	m := make(map[string]any)
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent {
+		l := p.lines[p.pos]
+		key, rest, ok := splitYAMLKey(l.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"key: value\", got %q", l.num, l.text)
+		}
+		if _, dup := m[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", l.num, key)
+		}
+		p.pos++
+		v, err := p.value(rest, indent, l.num)
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].num)
+	}
+	return m, nil
+}
+
+func (p *yamlParser) sequence(indent int) ([]any, error) {
+	s := []any{}
+	for p.pos < len(p.lines) && p.lines[p.pos].indent == indent && isYAMLItem(p.lines[p.pos].text) {
+		l := p.lines[p.pos]
+		rest := strings.TrimSpace(l.text[1:])
+		if _, _, ok := splitYAMLKey(rest); ok {
+			return nil, fmt.Errorf("line %d: mappings in sequences are not supported", l.num)
+		}
+		p.pos++
+		v, err := p.value(rest, indent, l.num)
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, v)
+	}
+	if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+		return nil, fmt.Errorf("line %d: unexpected indentation", p.lines[p.pos].num)
+	}
+	return s, nil
+}
+
+// value parses the value following a key or a sequence item at indent: rest
+// of its line, or else the block below it.
+func (p *yamlParser) value(rest string, indent, num int) (any, error) {
+	if rest != "" {
+		return parseYAMLFlow(rest, num)
+	}
+	if p.pos == len(p.lines) {
+		return nil, nil
+	}
+	// A sequence may be indented like the key it is the value of
+	next := p.lines[p.pos]
+	if next.indent > indent || next.indent == indent && isYAMLItem(next.text) && !isYAMLItem(p.lines[p.pos-1].text) {
+		return p.block(next.indent)
+	}
+	return nil, nil
+}
+
+func isYAMLItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// splitYAMLKey splits "key: value" outside of quotes.
+func splitYAMLKey(text string) (key, rest string, ok bool) {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && i == 0:
+			quote = c
+		case c == '[' || c == '{':
+			if i == 0 {
+				return "", "", false
+			}
+		case c == ':' && (i+1 == len(text) || text[i+1] == ' '):
+			key = strings.TrimSpace(text[:i])
+			if strings.HasPrefix(key, "\"") || strings.HasPrefix(key, "'") {
+				v, err := parseYAMLFlow(key, 0)
+				s, isString := v.(string)
+				if err != nil || !isString {
+					return "", "", false
+				}
+				key = s
+			}
+			return key, strings.TrimSpace(text[i+1:]), key != ""
+		}
+	}
+	return "", "", false
+}
+
+// parseYAMLFlow parses a scalar, or a flow sequence or mapping of them.
+func parseYAMLFlow(s string, num int) (any, error) {
+	switch {
+	case strings.HasPrefix(s, "["):
+		if !strings.HasSuffix(s, "]") {
+			return nil, fmt.Errorf("line %d: unterminated sequence %s", num, s)
+		}
+		items, err := splitYAMLFlow(s[1:len(s)-1], num)
+		if err != nil {
+			return nil, err
+		}
+		seq := []any{}
+		for _, item := range items {
+			v, err := parseYAMLFlow(item, num)
+			if err != nil {
+				return nil, err
+			}
+			seq = append(seq, v)
+		}
+		return seq, nil
+	case strings.HasPrefix(s, "{"):
+		if !strings.HasSuffix(s, "}") {
+			return nil, fmt.Errorf("line %d: unterminated mapping %s", num, s)
+		}
+		items, err := splitYAMLFlow(s[1:len(s)-1], num)
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[string]any)
+		for _, item := range items {
+			key, rest, ok := splitYAMLKey(item)
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected \"key: value\", got %q", num, item)
+			}
+			if m[key], err = parseYAMLFlow(rest, num); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	case strings.HasPrefix(s, "\""):
+		v, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid string %s", num, s)
+		}
+		return v, nil
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return nil, fmt.Errorf("line %d: invalid string %s", num, s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	case s == "|" || s == ">" || strings.HasPrefix(s, "&") || strings.HasPrefix(s, "*") || strings.HasPrefix(s, "!"):
+		return nil, fmt.Errorf("line %d: unsupported YAML %s", num, s)
+	case s == "" || s == "~" || s == "null":
+		return nil, nil
+	case s == "true" || s == "false":
+		return s == "true", nil
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f, nil
+	}
+	return s, nil
+}
+
+// splitYAMLFlow splits the items of a flow collection on the commas outside
+// of quotes and nested collections.
+func splitYAMLFlow(s string, num int) ([]string, error) {
+	var items []string
+	var quote byte
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '[' || c == '{':
+			depth++
+		case c == ']' || c == '}':
+			depth--
+		case c == ',' && depth == 0:
+			items = append(items, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if quote != 0 || depth != 0 {
+		return nil, fmt.Errorf("line %d: unbalanced quotes or brackets in %s", num, s)
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" || len(items) > 0 {
+		items = append(items, last)
+	}
+	return items, nil
+}
+
+// stripYAMLComment removes a comment, starting with '#' at the beginning of
+// the line or after a space, outside of quotes.
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || strings.ContainsRune(" \t:[{,-", rune(line[i-1])) {
+				quote = c
+			}
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
@@ -0,0 +1,194 @@
+package tests
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"mr/mapreduce"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// signedRegistration builds the registration of a worker as documented in
+// RegisterArgs
+func signedRegistration(token, workerID string, at time.Time, nonce string) *mapreduce.RegisterArgs {
+	mac := hmac.New(sha256.New, []byte(token))
+	fmt.Fprintf(mac, "%s\n%d\n%s", workerID, at.UnixNano(), nonce)
+	return &mapreduce.RegisterArgs{WorkerID: workerID, Time: at.UnixNano(), Nonce: nonce,
+		MAC: hex.EncodeToString(mac.Sum(nil))}
+}
+
+func TestWorkerAuthentication(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in", []byte("a"))
+	m := mapreduce.NewMaster(mapreduce.WithClusterToken("secret"))
+	err := m.Submit("guarded", []string{"in"}, 0, mapreduce.WithStorage(store))
+	checkErrFatal(t, err, "submit failed: %v", err)
+
+	// Registrations with a wrong token, too old or replayed
+	now := time.Now()
+	var reg mapreduce.RegisterReply
+	for name, args := range map[string]*mapreduce.RegisterArgs{
+		"wrong token": signedRegistration("guess", "w1", now, "n1"),
+		"too old":     signedRegistration("secret", "w1", now.Add(-time.Hour), "n2"),
+	} {
+		if err := m.Register(args, &reg); err == nil {
+			t.Errorf("registration with %s accepted", name)
+		}
+	}
+	checkErrFatal(t, m.Register(signedRegistration("secret", "w1", now, "n3"), &reg), "valid registration refused")
+	session := reg.Session
+	if err := m.Register(signedRegistration("secret", "w1", now, "n3"), &reg); err == nil {
+		t.Errorf("replayed registration accepted")
+	}
+
+	// Calls must carry the session of their worker
+	var reply mapreduce.TaskReply
+	for _, args := range []*mapreduce.TaskArgs{
+		{WorkerID: "w1"},
+		{WorkerID: "w2", Session: session},
+	} {
+		if err := m.GetTask(args, &reply); err == nil {
+			t.Errorf("GetTask accepted from %+v", args)
+		}
+	}
+	err = m.GetTask(&mapreduce.TaskArgs{WorkerID: "w1", Session: session}, &reply)
+	checkErrFatal(t, err, "GetTask failed: %v", err)
+	if !reply.Available {
+		t.Fatalf("no task for a registered worker")
+	}
+
+	// A forged report does not complete the task
+	report := &mapreduce.ReportArgs{JobName: "guarded", TaskID: reply.Task.TaskID, WorkerID: "w1"}
+	if err := m.ReportTaskDone(report, &struct{}{}); err == nil {
+		t.Errorf("ReportTaskDone accepted without a session")
+	}
+	srv := httptest.NewServer(m.Handler())
+	defer srv.Close()
+	if status := workerStatus(t, srv.URL, "w1"); status != "Working" {
+		t.Errorf("w1 is %s after a forged report, want Working", status)
+	}
+
+	// Another worker cannot take the ID of a live one, while the worker
+	// registering again with its session ends it
+	if err := m.Register(signedRegistration("secret", "w1", now, "n4"), &reg); err == nil {
+		t.Errorf("registration with the ID of a live worker accepted")
+	}
+	again := signedRegistration("secret", "w1", now, "n5")
+	again.Session = session
+	checkErrFatal(t, m.Register(again, &reg), "new registration refused")
+	report.Session = session
+	if err := m.ReportTaskDone(report, &struct{}{}); err == nil {
+		t.Errorf("ReportTaskDone accepted with an ended session")
+	}
+	report.Session = reg.Session
+	checkErrFatal(t, m.ReportTaskDone(report, &struct{}{}), "ReportTaskDone failed")
+	if status := workerStatus(t, srv.URL, "w1"); status != "Idle" {
+		t.Errorf("w1 is %s after its report, want Idle", status)
+	}
+
+	metrics := scrape(t, srv.URL+"/metrics")
+	for _, line := range []string{
+		`mapreduce_rpc_rejected_total{method="Register"} 4`,
+		`mapreduce_rpc_rejected_total{method="GetTask"} 2`,
+		`mapreduce_rpc_rejected_total{method="ReportTaskDone"} 2`,
+	} {
+		if !strings.Contains(metrics, line) {
+			t.Errorf("no %q in metrics", line)
+		}
+	}
+}
+
+func TestWorkerAuthenticationDisabled(t *testing.T) {
+	m := mapreduce.NewMaster()
+	var reg mapreduce.RegisterReply
+	checkErrFatal(t, m.Register(&mapreduce.RegisterArgs{WorkerID: "w1"}, &reg), "registration refused")
+	var reply mapreduce.TaskReply
+	checkErrFatal(t, m.GetTask(&mapreduce.TaskArgs{WorkerID: "w2"}, &reply), "GetTask without session refused")
+}
+
+func TestDuplicateWorkerID(t *testing.T) {
+	m := mapreduce.NewMaster(mapreduce.WithClusterToken("secret"), mapreduce.WithRPCAddr("127.0.0.1:0"),
+		mapreduce.WithDashboardAddr("127.0.0.1:0"))
+	checkErrFatal(t, m.Serve(), "master failed to start")
+	defer m.Close()
+
+	// Two hosts start a worker with the same ID: the second one is refused,
+	// and the first one keeps its session
+	ctx, stop := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		first <- mapreduce.NewWorker("w1", m.RPCAddr(), mapF, reduceF, mapreduce.WithClusterToken("secret"),
+			mapreduce.WithContext(ctx)).Start()
+	}()
+	srv := httptest.NewServer(m.Handler())
+	defer srv.Close()
+	for deadline := time.Now().Add(5 * time.Second); workerStatus(t, srv.URL, "w1") == ""; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("the first worker did not join")
+		}
+	}
+	err := mapreduce.NewWorker("w1", m.RPCAddr(), mapF, reduceF, mapreduce.WithClusterToken("secret")).Start()
+	if err == nil || !strings.Contains(err.Error(), "already registered") {
+		t.Errorf("second worker with the same ID: %v", err)
+	}
+
+	time.Sleep(1500 * time.Millisecond) // the first worker asks for a task again
+	stop()
+	if err := <-first; err != nil {
+		t.Errorf("first worker failed: %v", err)
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	m := mapreduce.NewMaster(mapreduce.WithClusterToken("secret"),
+		mapreduce.WithSessionIdleTimeout(100*time.Millisecond))
+	var reg mapreduce.RegisterReply
+	checkErrFatal(t, m.Register(signedRegistration("secret", "w1", time.Now(), "n1"), &reg), "registration refused")
+
+	// An unused session ends, and the ID of its worker is free again
+	time.Sleep(150 * time.Millisecond)
+	var reply mapreduce.TaskReply
+	if err := m.GetTask(&mapreduce.TaskArgs{WorkerID: "w1", Session: reg.Session}, &reply); err == nil {
+		t.Errorf("GetTask accepted with an expired session")
+	}
+	checkErrFatal(t, m.Register(signedRegistration("secret", "w1", time.Now(), "n2"), &reg), "registration refused")
+
+	// A worker whose session expired during a task without heartbeats
+	// registers again, and goes on
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in/a", []byte("foo bar"))
+	store.WriteFile("in/b", []byte("foo"))
+	slowMapF := func(contents string) []mapreduce.KeyValue {
+		time.Sleep(200 * time.Millisecond)
+		return mapF(contents)
+	}
+	done := make(chan error, 1)
+	onServe := func(rpcAddr, dashboardAddr string) {
+		w := mapreduce.NewWorker("w1", rpcAddr, slowMapF, reduceF, mapreduce.WithStorage(store),
+			mapreduce.WithClusterToken("secret"))
+		go func() { done <- w.Start() }()
+	}
+	results := make(chan *mapreduce.JobResult)
+	go func() {
+		results <- mapreduce.StartDistributed("expiry", []string{"in"}, 1, mapF, reduceF, mapreduce.WithStorage(store),
+			mapreduce.WithRPCAddr("127.0.0.1:0"), mapreduce.WithDashboardAddr("127.0.0.1:0"),
+			mapreduce.WithClusterToken("secret"), mapreduce.WithSessionIdleTimeout(100*time.Millisecond),
+			mapreduce.WithOnServe(onServe))
+	}()
+	var res *mapreduce.JobResult
+	select {
+	case res = <-results:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("StartDistributed did not return")
+	}
+	if err := waitWorker(t, done); err != nil {
+		t.Errorf("worker failed: %v", err)
+	}
+	checkErrFatal(t, res.Err, "job failed: %v", res.Err)
+	assertEqualMaps(t, decodeMapFromStorage(t, store, res.Output), map[string]string{"foo": "2", "bar": "1"})
+}
@@ -0,0 +1,174 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"mr/mapreduce"
+	"testing"
+	"time"
+)
+
+// assertNoFiles checks that no file of the jobs is left in store
+func assertNoFiles(t *testing.T, store *mapreduce.MemStorage) {
+	t.Helper()
+	names, err := store.List("mrtmp.")
+	checkErrFatal(t, err, "cannot list files: %v", err)
+	if len(names) > 0 {
+		t.Errorf("files left: %v", names)
+	}
+}
+
+func TestCancelledTasks(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in", []byte("foo bar foo"))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := mapreduce.DoMap("cancelled", 0, "in", 2, mapF, mapreduce.WithStorage(store), mapreduce.WithContext(ctx))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("DoMap with a cancelled context: %v", err)
+	}
+	err = mapreduce.DoReduce("cancelled", 0, 1, reduceF, mapreduce.WithStorage(store), mapreduce.WithContext(ctx))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("DoReduce with a cancelled context: %v", err)
+	}
+	err = mapreduce.Sequential("cancelled", []string{"in"}, 2, mapF, reduceF,
+		mapreduce.WithStorage(store), mapreduce.WithContext(ctx))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Sequential with a cancelled context: %v", err)
+	}
+	assertNoFiles(t, store)
+
+	err = mapreduce.Sequential("uncancelled", []string{"in"}, 2, mapF, reduceF, mapreduce.WithStorage(store))
+	checkErrFatal(t, err, "job failed: %v", err)
+	assertEqualMaps(t, decodeMapFromStorage(t, store, mapreduce.AnsName("uncancelled")), map[string]string{"foo": "2", "bar": "1"})
+}
+
+func TestHeartbeat(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in", []byte("foo"))
+	m := mapreduce.NewMaster()
+	err := m.Submit("beating", []string{"in"}, 1, mapreduce.WithStorage(store))
+	checkErrFatal(t, err, "submit failed: %v", err)
+	task := getTask(t, m, "w1").Task
+
+	heartbeat := func(workerID string) bool {
+		t.Helper()
+		var reply mapreduce.HeartbeatReply
+		err := m.Heartbeat(&mapreduce.HeartbeatArgs{WorkerID: workerID, JobName: "beating", TaskID: task.TaskID}, &reply)
+		checkErrFatal(t, err, "Heartbeat failed: %v", err)
+		return reply.Cancel
+	}
+	if heartbeat("w1") {
+		t.Errorf("the task of w1 is cancelled")
+	}
+	if !heartbeat("w2") {
+		t.Errorf("w2 may run a task of w1")
+	}
+	err = m.CancelJob("beating")
+	checkErrFatal(t, err, "cancel failed: %v", err)
+	if !heartbeat("w1") {
+		t.Errorf("the task of a cancelled job goes on")
+	}
+}
+
+func TestLocalParallelDeadline(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	for _, name := range []string{"in/a", "in/b", "in/c"} {
+		store.WriteFile(name, []byte("foo"))
+	}
+	slowMap := func(contents string) []mapreduce.KeyValue {
+		time.Sleep(200 * time.Millisecond)
+		return mapF(contents)
+	}
+
+	// The workers stop their tasks once the job is past its deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := mapreduce.LocalParallel(2)("late", []string{"in"}, 2, slowMap, reduceF,
+		mapreduce.WithStorage(store), mapreduce.WithContext(ctx))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("job past its deadline: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("job stopped after %v", elapsed)
+	}
+	assertNoFiles(t, store)
+}
+
+func TestHeartbeatDeadline(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in", []byte("foo"))
+	slowMap := func(contents string) []mapreduce.KeyValue {
+		time.Sleep(600 * time.Millisecond)
+		return mapF(contents)
+	}
+
+	// The task runs twice longer than the timeout, but its worker is alive:
+	// its heartbeats push back the deadline and the task is not reassigned
+	counters := mapreduce.NewCounters()
+	err := mapreduce.LocalParallel(2)("beating", []string{"in"}, 1, slowMap, reduceF,
+		mapreduce.WithStorage(store), mapreduce.WithCounters(counters), mapreduce.WithMaxAttempts(1),
+		mapreduce.WithTaskTimeout(300*time.Millisecond), mapreduce.WithHeartbeatInterval(50*time.Millisecond))
+	checkErrFatal(t, err, "job failed: %v", err)
+	assertEqualMaps(t, decodeMapFromStorage(t, store, mapreduce.AnsName("beating")), map[string]string{"foo": "1"})
+	if n := counters.Get(mapreduce.CounterMapInputRecords); n != 1 {
+		t.Errorf("%d map input records, want 1", n)
+	}
+}
+
+func TestWorkerCancelledByHeartbeat(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in", []byte("foo bar"))
+	m := mapreduce.NewMaster(mapreduce.WithRPCAddr("127.0.0.1:0"), mapreduce.WithDashboardAddr("127.0.0.1:0"))
+	checkErrFatal(t, m.Serve(), "master failed to start")
+	defer m.Close()
+	err := m.Submit("heard", []string{"in"}, 1, mapreduce.WithStorage(store), mapreduce.WithNamedOutputs("side"))
+	checkErrFatal(t, err, "submit failed: %v", err)
+
+	// The map task writes to its named output, then runs until stopped
+	started := make(chan struct{})
+	blockingMap := func(tc *mapreduce.TaskContext, contents string) []mapreduce.KeyValue {
+		tc.Emit("side", mapreduce.KeyValue{Key: "foo", Value: "1"})
+		close(started)
+		<-tc.Context().Done()
+		return mapF(contents)
+	}
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	w := mapreduce.NewWorker("w1", m.RPCAddr(), mapF, reduceF, mapreduce.WithStorage(store),
+		mapreduce.WithMapTask(blockingMap), mapreduce.WithHeartbeatInterval(20*time.Millisecond), mapreduce.WithContext(ctx))
+	go func() { done <- w.Start() }()
+	defer func() {
+		stop()
+		if err := waitWorker(t, done); err != nil {
+			t.Errorf("worker failed: %v", err)
+		}
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("the map task did not start")
+	}
+	if names, _ := store.List("mrtmp."); len(names) == 0 {
+		t.Fatalf("the running task has no partial files")
+	}
+	checkErrFatal(t, m.CancelJob("heard"), "cancel failed")
+
+	// The next heartbeat stops the task, which removes its partial files
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		names, err := store.List("mrtmp.")
+		checkErrFatal(t, err, "cannot list files: %v", err)
+		if len(names) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("files left: %v", names)
+		}
+	}
+	if status, _ := m.JobStatus("heard"); status.Status != mapreduce.JobCancelled || status.Completed != 0 {
+		t.Errorf("cancelled job: %+v", status)
+	}
+}
@@ -0,0 +1,152 @@
+package tests
+
+import (
+	"encoding/json"
+	"mr/mapreduce"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// post sends a control action with token, returning the HTTP status
+func post(t *testing.T, url, token string) int {
+	t.Helper()
+	req, _ := http.NewRequest("POST", url, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	checkErrFatal(t, err, "cannot post %s: %v", url, err)
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+func getTask(t *testing.T, m *mapreduce.Master, workerID string) mapreduce.TaskReply {
+	t.Helper()
+	var reply mapreduce.TaskReply
+	err := m.GetTask(&mapreduce.TaskArgs{WorkerID: workerID}, &reply)
+	checkErrFatal(t, err, "GetTask failed: %v", err)
+	return reply
+}
+
+func workerStatus(t *testing.T, url, workerID string) string {
+	t.Helper()
+	var data mapreduce.DashboardData
+	json.Unmarshal([]byte(scrape(t, url+"/data")), &data)
+	for _, w := range data.Workers {
+		if w.Name == workerID {
+			return w.Status
+		}
+	}
+	return ""
+}
+
+func TestControlAuth(t *testing.T) {
+	disabled := httptest.NewServer(mapreduce.NewMaster().Handler())
+	defer disabled.Close()
+	if status := post(t, disabled.URL+"/api/pause", "secret"); status != http.StatusForbidden {
+		t.Errorf("control without admin token: status %d", status)
+	}
+
+	srv := httptest.NewServer(mapreduce.NewMaster(mapreduce.WithAdminToken("secret")).Handler())
+	defer srv.Close()
+	for _, token := range []string{"", "wrong"} {
+		if status := post(t, srv.URL+"/api/pause", token); status != http.StatusUnauthorized {
+			t.Errorf("token %q: status %d", token, status)
+		}
+	}
+	if status := post(t, srv.URL+"/api/pause", "secret"); status != http.StatusNoContent {
+		t.Errorf("valid token: status %d", status)
+	}
+}
+
+func TestControlActions(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in-0", []byte("a"))
+	store.WriteFile("in-1", []byte("b"))
+
+	m := mapreduce.NewMaster(mapreduce.WithAdminToken("secret"))
+	err := m.Submit("controlled", []string{"in-0", "in-1"}, 1, mapreduce.WithStorage(store))
+	checkErrFatal(t, err, "submit failed: %v", err)
+	srv := httptest.NewServer(m.Handler())
+	defer srv.Close()
+	api := srv.URL + "/api"
+	expectStatus := func(path string, want int) {
+		t.Helper()
+		if status := post(t, api+path, "secret"); status != want {
+			t.Errorf("%s: status %d, want %d", path, status, want)
+		}
+	}
+
+	// Pause and resume scheduling
+	expectStatus("/pause", http.StatusNoContent)
+	if getTask(t, m, "w1").Available {
+		t.Errorf("task assigned while paused")
+	}
+	expectStatus("/resume", http.StatusNoContent)
+	if task := getTask(t, m, "w1").Task; task.TaskID != 0 {
+		t.Fatalf("w1 got task %d, want 0", task.TaskID)
+	}
+
+	// Retry the running task on another worker, which is drained
+	expectStatus("/jobs/controlled/tasks/0/retry", http.StatusNoContent)
+	expectStatus("/jobs/controlled/tasks/1/retry", http.StatusConflict)
+	expectStatus("/jobs/controlled/tasks/9/retry", http.StatusNotFound)
+	if task := getTask(t, m, "w2").Task; task.TaskID != 0 || task.Attempts != 2 {
+		t.Fatalf("w2 got %+v, want the second attempt of task 0", task)
+	}
+	expectStatus("/workers/w2/drain", http.StatusNoContent)
+	if status := workerStatus(t, srv.URL, "w2"); status != mapreduce.WorkerDraining {
+		t.Errorf("w2 is %s, want %s", status, mapreduce.WorkerDraining)
+	}
+	err = m.ReportTaskDone(&mapreduce.ReportArgs{JobName: "controlled", TaskID: 0, WorkerID: "w2"}, &struct{}{})
+	checkErrFatal(t, err, "ReportTaskDone failed: %v", err)
+	if status := workerStatus(t, srv.URL, "w2"); status != mapreduce.WorkerDrained {
+		t.Errorf("w2 is %s, want %s", status, mapreduce.WorkerDrained)
+	}
+	if getTask(t, m, "w2").Available {
+		t.Errorf("task assigned to a drained worker")
+	}
+
+	// Blacklist and reinstate a worker
+	expectStatus("/workers/w1/blacklist", http.StatusNoContent)
+	expectStatus("/workers/nobody/blacklist", http.StatusNotFound)
+	if getTask(t, m, "w1").Available {
+		t.Errorf("task assigned to a blacklisted worker")
+	}
+	expectStatus("/workers/w1/reinstate", http.StatusNoContent)
+	if task := getTask(t, m, "w1").Task; task.TaskID != 1 {
+		t.Errorf("w1 got task %d, want 1", task.TaskID)
+	}
+
+	// Cancel the job
+	expectStatus("/jobs/controlled/cancel", http.StatusNoContent)
+	expectStatus("/jobs/controlled/cancel", http.StatusConflict)
+	if getTask(t, m, "w1").Available {
+		t.Errorf("task of a cancelled job assigned")
+	}
+
+	h, err := mapreduce.ReadHistory(store, mapreduce.DefaultHistoryDir, "controlled")
+	checkErrFatal(t, err, "cannot read history: %v", err)
+	if h.Status != "cancelled" {
+		t.Errorf("history status %q, want cancelled", h.Status)
+	}
+	var types []string
+	for _, e := range h.Events {
+		types = append(types, e.Type)
+	}
+	for _, typ := range []string{
+		mapreduce.EventSchedulingPaused,
+		mapreduce.EventSchedulingResumed,
+		mapreduce.EventTaskRetried,
+		mapreduce.EventWorkerDrained,
+		mapreduce.EventWorkerBlacklisted,
+		mapreduce.EventWorkerReinstated,
+		mapreduce.EventJobCancelled,
+	} {
+		if !slices.Contains(types, typ) {
+			t.Errorf("no %s event in %v", typ, types)
+		}
+	}
+}
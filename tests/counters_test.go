@@ -0,0 +1,85 @@
+package tests
+
+import (
+	"mr/mapreduce"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCounters(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in-0", []byte("alice,31\nbob\n"))
+	store.WriteFile("in-1", []byte("carol,27\ndave,x\nerin,45\n"))
+
+	// mapValidate sends malformed lines to "errors", count them as well
+	mapCount := func(tc *mapreduce.TaskContext, contents string) []mapreduce.KeyValue {
+		res := mapValidate(tc, contents)
+		tc.AddCounter("records.valid", int64(len(res)))
+		return res
+	}
+	counters := mapreduce.NewCounters()
+	mapreduce.Sequential("counted", []string{"in-0", "in-1"}, 2, nil, reduceF,
+		mapreduce.WithStorage(store),
+		mapreduce.WithNamedOutputs("errors"),
+		mapreduce.WithMapTask(mapCount),
+		mapreduce.WithCounters(counters))
+
+	got := counters.Snapshot()
+	shuffle := got[mapreduce.CounterShuffleBytes]
+	if shuffle <= 0 {
+		t.Errorf("no shuffle bytes counted")
+	}
+	expected := map[string]int64{
+		"records.valid":                      3,
+		mapreduce.CounterMapInputRecords:     2,
+		mapreduce.CounterMapInputBytes:       37,
+		mapreduce.CounterMapOutputRecords:    3,
+		mapreduce.CounterSpilledRecords:      3,
+		mapreduce.CounterShuffleBytes:        shuffle,
+		mapreduce.CounterReduceInputRecords:  3,
+		mapreduce.CounterReduceInputGroups:   3,
+		mapreduce.CounterReduceOutputRecords: 3,
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("counters got %v, want %v", got, expected)
+	}
+}
+
+func TestCountersOfWinningAttempts(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in", []byte("foo"))
+	counters := mapreduce.NewCounters()
+	m := mapreduce.NewMaster()
+	err := m.Submit("attempts", []string{"in"}, 1, mapreduce.WithStorage(store), mapreduce.WithCounters(counters),
+		mapreduce.WithTaskTimeout(10*time.Millisecond))
+	checkErrFatal(t, err, "submit failed: %v", err)
+	report := func(workerID string, taskID int, n int64) {
+		t.Helper()
+		err := m.ReportTaskDone(&mapreduce.ReportArgs{JobName: "attempts", TaskID: taskID, WorkerID: workerID,
+			Counters: map[string]int64{"records": n}}, &struct{}{})
+		checkErrFatal(t, err, "ReportTaskDone failed: %v", err)
+	}
+
+	// The map task of w1 times out and goes to w2
+	task := getTask(t, m, "w1").Task
+	time.Sleep(20 * time.Millisecond)
+	if retried := getTask(t, m, "w2").Task; retried.TaskID != task.TaskID {
+		t.Fatalf("w2 got task %+v, want %d", retried, task.TaskID)
+	}
+
+	// Only the report of w2 counts: neither the late reports of w1, which
+	// lost the task, nor the duplicate report of w2
+	report("w1", task.TaskID, 100)
+	report("w2", task.TaskID, 1)
+	report("w2", task.TaskID, 1)
+	report("w1", task.TaskID, 100)
+	if n := counters.Get("records"); n != 1 {
+		t.Errorf("records = %d, want 1", n)
+	}
+	status, err := m.JobStatus("attempts")
+	checkErrFatal(t, err, "no job status: %v", err)
+	if status.Counters["records"] != 1 {
+		t.Errorf("job counters %v, want 1 record", status.Counters)
+	}
+}
@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"mr/mapreduce"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFaults(t *testing.T) {
+	f, err := mapreduce.ParseFaults("seed=7, crash-before=0.1,delay=0.5,delay-duration=2s,drop-reply=1")
+	checkErrFatal(t, err, "cannot parse faults: %v", err)
+	want := mapreduce.Faults{Seed: 7, CrashBeforeOutput: 0.1, Delay: 0.5, DelayDuration: 2 * time.Second, DropReply: 1}
+	if f != want {
+		t.Errorf("got %+v, want %+v", f, want)
+	}
+	if f, err := mapreduce.ParseFaults(""); err != nil || f != (mapreduce.Faults{}) {
+		t.Errorf("no faults: %+v, %v", f, err)
+	}
+	for _, invalid := range []string{"crash=0.1", "delay=2", "delay", "seed=x", "corrupt=-1"} {
+		if _, err := mapreduce.ParseFaults(invalid); err == nil {
+			t.Errorf("%q parsed", invalid)
+		}
+	}
+}
+
+func TestCorruptIntermediate(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in", []byte("foo bar"))
+	err := mapreduce.DoMap("corrupt", 0, "in", 1, mapF, mapreduce.WithStorage(store))
+	checkErrFatal(t, err, "DoMap failed: %v", err)
+	store.WriteFile(mapreduce.ReduceName("corrupt", 0, 0), []byte("{not json"))
+
+	err = mapreduce.DoReduce("corrupt", 0, 1, reduceF, mapreduce.WithStorage(store))
+	var interErr *mapreduce.IntermediateError
+	if !errors.As(err, &interErr) || interErr.MapTask != 0 || interErr.File != mapreduce.ReduceName("corrupt", 0, 0) {
+		t.Errorf("reduce of a corrupted file: %v", err)
+	}
+	if _, err := store.ReadFile(mapreduce.MergeName("corrupt", 0)); err == nil {
+		t.Errorf("reduce of a corrupted file has a result")
+	}
+}
+
+func TestFaultInjection(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	expected := map[string]string{"foo": "36", "bar": "8"}
+	for i := range 8 {
+		store.WriteFile(fmt.Sprintf("in/%d", i), []byte(strings.Repeat("foo ", i+1)+"bar"))
+	}
+
+	tests := map[string]mapreduce.Faults{
+		"crash-before":     {CrashBeforeOutput: 0.3},
+		"crash-after":      {CrashAfterOutput: 0.3},
+		"delay":            {Delay: 0.5, DelayDuration: 50 * time.Millisecond},
+		"corrupt":          {CorruptIntermediate: 0.3},
+		"drop-reply":       {DropReply: 0.3},
+		"duplicate-report": {DuplicateReport: 0.5},
+		"all": {CrashBeforeOutput: 0.1, CrashAfterOutput: 0.1, Delay: 0.2, DelayDuration: 50 * time.Millisecond,
+			CorruptIntermediate: 0.1, DropReply: 0.1, DuplicateReport: 0.2},
+	}
+	for name, faults := range tests {
+		t.Run(name, func(t *testing.T) {
+			// Lost tasks time out and run again until the job succeeds
+			faults.Seed = 1
+			job := "faults-" + name
+			counters := mapreduce.NewCounters()
+			err := mapreduce.LocalParallel(3)(job, []string{"in"}, 3, mapF, reduceF, mapreduce.WithStorage(store),
+				mapreduce.WithCounters(counters), mapreduce.WithTaskTimeout(200*time.Millisecond), mapreduce.WithFaults(faults))
+			checkErrFatal(t, err, "job failed: %v", err)
+			assertEqualMaps(t, decodeMapFromStorage(t, store, mapreduce.AnsName(job)), expected)
+			// Only the winning attempts count, a map task run again for its
+			// corrupted output replacing the counters of the first run
+			if n := counters.Get(mapreduce.CounterMapInputRecords); n != 8 {
+				t.Errorf("%d map input records, want 8", n)
+			}
+		})
+	}
+}
@@ -0,0 +1,91 @@
+package tests
+
+import (
+	"encoding/json"
+	"mr/mapreduce"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+// runTask asks the master for a task as workerID and reports it done
+func runTask(t *testing.T, m *mapreduce.Master, workerID string, counters map[string]int64) mapreduce.Task {
+	t.Helper()
+	var reply mapreduce.TaskReply
+	err := m.GetTask(&mapreduce.TaskArgs{WorkerID: workerID}, &reply)
+	checkErrFatal(t, err, "GetTask failed: %v", err)
+	if !reply.Available {
+		t.Fatalf("no task available for %s", workerID)
+	}
+	err = m.ReportTaskDone(&mapreduce.ReportArgs{JobName: reply.Task.JobName, TaskID: reply.Task.TaskID,
+		WorkerID: workerID, Counters: counters}, &struct{}{})
+	checkErrFatal(t, err, "ReportTaskDone failed: %v", err)
+	return reply.Task
+}
+
+func TestJobHistory(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in-0", []byte("a b"))
+
+	m := mapreduce.NewMaster()
+	err := m.Submit("recorded", []string{"in-0"}, 1, mapreduce.WithStorage(store))
+	checkErrFatal(t, err, "submit failed: %v", err)
+
+	// w1 fails the map task, w2 runs everything
+	var reply mapreduce.TaskReply
+	err = m.GetTask(&mapreduce.TaskArgs{WorkerID: "w1"}, &reply)
+	checkErrFatal(t, err, "GetTask failed: %v", err)
+	err = m.ReportTaskFailed(&mapreduce.ReportArgs{JobName: "recorded", TaskID: reply.Task.TaskID,
+		WorkerID: "w1", Error: "boom"}, &struct{}{})
+	checkErrFatal(t, err, "ReportTaskFailed failed: %v", err)
+	runTask(t, m, "w2", map[string]int64{"x": 1})
+	runTask(t, m, "w2", map[string]int64{"x": 2})
+
+	h, err := mapreduce.ReadHistory(store, mapreduce.DefaultHistoryDir, "recorded")
+	checkErrFatal(t, err, "cannot read history: %v", err)
+	var types []string
+	for _, e := range h.Events {
+		types = append(types, e.Type)
+	}
+	expected := []string{
+		mapreduce.EventJobSubmitted,
+		mapreduce.EventWorkerJoined,
+		mapreduce.EventTaskAssigned,
+		mapreduce.EventTaskFailed,
+		mapreduce.EventWorkerJoined,
+		mapreduce.EventTaskAssigned,
+		mapreduce.EventTaskComplete,
+		mapreduce.EventTaskAssigned,
+		mapreduce.EventTaskComplete,
+		mapreduce.EventJobCompleted,
+	}
+	if !reflect.DeepEqual(types, expected) {
+		t.Errorf("events got %v, want %v", types, expected)
+	}
+	if h.Events[3].Error != "boom" || h.Events[5].Task.Attempt != 2 {
+		t.Errorf("unexpected failure and retry events: %+v, %+v", h.Events[3], *h.Events[5].Task)
+	}
+	if h.Status != "completed" || h.Config == nil || h.Config.NReduce != 1 ||
+		!reflect.DeepEqual(h.Config.Inputs, []string{"in-0"}) {
+		t.Errorf("unexpected history: %+v", h)
+	}
+	if h.Counters["x"] != 3 {
+		t.Errorf("counters got %v, want x=3", h.Counters)
+	}
+
+	// The history server lists the job once the master is gone
+	srv := httptest.NewServer(mapreduce.HistoryHandler(store, mapreduce.DefaultHistoryDir))
+	defer srv.Close()
+	var jobs []mapreduce.JobHistory
+	err = json.Unmarshal([]byte(scrape(t, srv.URL+"/jobs")), &jobs)
+	checkErrFatal(t, err, "cannot decode jobs: %v", err)
+	if len(jobs) != 1 || jobs[0].Name != "recorded" || jobs[0].Status != "completed" {
+		t.Errorf("unexpected jobs: %+v", jobs)
+	}
+	var job mapreduce.JobHistory
+	err = json.Unmarshal([]byte(scrape(t, srv.URL+"/jobs/recorded")), &job)
+	checkErrFatal(t, err, "cannot decode job: %v", err)
+	if len(job.Events) != len(expected) {
+		t.Errorf("job served with %d events, want %d", len(job.Events), len(expected))
+	}
+}
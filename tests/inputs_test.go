@@ -0,0 +1,89 @@
+package tests
+
+import (
+	"bytes"
+	"compress/gzip"
+	"mr/mapreduce"
+	"reflect"
+	"testing"
+)
+
+// "foo bar foo" compressed with bzip2 (the standard library cannot write it)
+var bzip2FooBarFoo = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x5b, 0xa3,
+	0xc7, 0x4e, 0x00, 0x00, 0x02, 0x11, 0x80, 0x40, 0x00, 0x31, 0x00, 0x90,
+	0x00, 0x20, 0x00, 0x21, 0xa6, 0x99, 0xa0, 0xc0, 0x29, 0x64, 0x6d, 0x08,
+	0xc2, 0xee, 0x48, 0xa7, 0x0a, 0x12, 0x0b, 0x74, 0x78, 0xe9, 0xc0,
+}
+
+func newInputStore() *mapreduce.MemStorage {
+	store := mapreduce.NewMemStorage()
+	for _, name := range []string{
+		"data/a.txt",
+		"data/b.log",
+		"data/2024/c.txt",
+		"data/2024/d.txt",
+		"data/2024/old/e.txt",
+		"other/f.txt",
+	} {
+		store.WriteFile(name, []byte("x"))
+	}
+	return store
+}
+
+func TestResolveInputs(t *testing.T) {
+	store := newInputStore()
+	tests := []struct {
+		inputs, include, exclude []string
+		want                     []string
+	}{
+		{[]string{"other/f.txt"}, nil, nil, []string{"other/f.txt"}},
+		{[]string{"data"}, nil, nil,
+			[]string{"data/2024/c.txt", "data/2024/d.txt", "data/2024/old/e.txt", "data/a.txt", "data/b.log"}},
+		{[]string{"data/"}, []string{"*.txt"}, []string{"data/2024/old/*"},
+			[]string{"data/2024/c.txt", "data/2024/d.txt", "data/a.txt"}},
+		{[]string{"data/*.txt", "other/*"}, nil, nil, []string{"data/a.txt", "other/f.txt"}},
+		{[]string{"data/**/*.txt"}, nil, []string{"d.*"},
+			[]string{"data/2024/c.txt", "data/2024/old/e.txt", "data/a.txt"}},
+		{[]string{"./other/f.txt", "other/*.txt"}, nil, nil, []string{"other/f.txt"}},
+	}
+	for _, test := range tests {
+		got, err := mapreduce.ResolveInputs(store, test.inputs, test.include, test.exclude)
+		checkErrFatal(t, err, "ResolveInputs(%v): %v", test.inputs, err)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ResolveInputs(%v, %v, %v) got %v, want %v",
+				test.inputs, test.include, test.exclude, got, test.want)
+		}
+	}
+}
+
+func TestResolveInputsErrors(t *testing.T) {
+	store := newInputStore()
+	for _, inputs := range [][]string{
+		{"missing.txt"},
+		{"data/*.csv"},
+		{"data/*.txt", "nope/"},
+	} {
+		if _, err := mapreduce.ResolveInputs(store, inputs, nil, nil); err == nil {
+			t.Errorf("ResolveInputs(%v) should have failed", inputs)
+		}
+	}
+}
+
+func TestSequentialCompressedInputs(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write([]byte("baz foo bar"))
+	w.Close()
+	store.WriteFile("in/part-0.gz", gz.Bytes())
+	store.WriteFile("in/part-1.bz2", bzip2FooBarFoo)
+	store.WriteFile("in/notes.md", []byte("ignored ignored"))
+
+	mapreduce.Sequential("gzjob", []string{"in"}, 2, mapF, reduceF,
+		mapreduce.WithStorage(store), mapreduce.WithInputFilter([]string{"*.gz", "*.bz2"}, nil))
+
+	expected := map[string]string{"foo": "3", "bar": "2", "baz": "1"}
+	assertEqualMaps(t, decodeMapFromStorage(t, store, "mrtmp.gzjob"), expected)
+}
@@ -0,0 +1,147 @@
+package tests
+
+import (
+	"errors"
+	"mr/mapreduce"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// apiStatus returns the HTTP status of an error of the client, 0 if none
+func apiStatus(err error) int {
+	var apiErr *mapreduce.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode
+	}
+	if err != nil {
+		return -1
+	}
+	return 0
+}
+
+func TestJobsAPI(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("data/in-0", []byte("a b"))
+	store.WriteFile("data/in-1", []byte("b"))
+	store.WriteFile("private/key", []byte("c"))
+
+	m := mapreduce.NewMaster(mapreduce.WithAdminToken("secret"), mapreduce.WithStorage(store),
+		mapreduce.WithInputRoots("data"))
+	srv := httptest.NewServer(m.Handler())
+	defer srv.Close()
+	client := mapreduce.NewClient(srv.URL, "secret", nil)
+
+	// Invalid and unauthorized submissions
+	for _, tc := range []struct {
+		client *mapreduce.Client
+		spec   mapreduce.JobSpec
+		status int
+	}{
+		{mapreduce.NewClient(srv.URL, "", nil), mapreduce.JobSpec{Name: "wc", Inputs: []string{"data"}}, http.StatusUnauthorized},
+		{client, mapreduce.JobSpec{Name: "../wc", Inputs: []string{"data"}}, http.StatusBadRequest},
+		{client, mapreduce.JobSpec{Name: "wc", Inputs: []string{"private"}}, http.StatusBadRequest},
+	} {
+		if status := apiStatus(tc.client.Submit(tc.spec)); status != tc.status {
+			t.Errorf("submit %+v: status %d, want %d", tc.spec, status, tc.status)
+		}
+	}
+
+	err := client.Submit(mapreduce.JobSpec{Name: "wc", Inputs: []string{"data"}, NReduce: 1})
+	checkErrFatal(t, err, "submit failed: %v", err)
+	if status := apiStatus(client.Submit(mapreduce.JobSpec{Name: "wc", Inputs: []string{"data"}})); status != http.StatusConflict {
+		t.Errorf("second submission of a job: status %d", status)
+	}
+	if _, err := client.Job("unknown"); apiStatus(err) != http.StatusNotFound {
+		t.Errorf("status of an unknown job: %v", err)
+	}
+
+	// Run the tasks, as the workers would
+	for range 3 {
+		task := getTask(t, m, "w1").Task
+		switch task.Type {
+		case "map":
+			mapreduce.DoMap(task.JobName, task.MapNum, task.File, task.NReduce, mapF, mapreduce.WithStorage(store))
+		case "reduce":
+			mapreduce.DoReduce(task.JobName, task.ReduceNum, task.NMap, reduceF, mapreduce.WithStorage(store))
+		}
+		err := m.ReportTaskDone(&mapreduce.ReportArgs{JobName: "wc", TaskID: task.TaskID, WorkerID: "w1"}, &struct{}{})
+		checkErrFatal(t, err, "ReportTaskDone failed: %v", err)
+	}
+	var status *mapreduce.JobStatus
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		status, err = client.Job("wc")
+		checkErrFatal(t, err, "cannot get job status: %v", err)
+		if status.Status != mapreduce.JobMerging || time.Now().After(deadline) {
+			break
+		}
+	}
+	if status.Status != mapreduce.JobCompleted || status.Result != mapreduce.AnsName("wc") || status.Completed != 3 {
+		t.Errorf("status of the job: %+v", status)
+	}
+	if _, err := store.ReadFile(status.Result); err != nil {
+		t.Errorf("no merged result: %v", err)
+	}
+
+	// Events, from the start then from the fourth one
+	events, err := client.Events("wc", 0)
+	checkErrFatal(t, err, "cannot get events: %v", err)
+	if len(events.Events) != events.Next || events.Events[0].Type != mapreduce.EventJobSubmitted ||
+		events.Events[len(events.Events)-1].Type != mapreduce.EventJobCompleted {
+		t.Errorf("unexpected events %+v", events)
+	}
+	rest, err := client.Events("wc", 3)
+	checkErrFatal(t, err, "cannot get events: %v", err)
+	if len(rest.Events) != events.Next-3 || !rest.Events[0].Time.Equal(events.Events[3].Time) {
+		t.Errorf("events after 3: %+v", rest.Events)
+	}
+
+	// Cancel a second job
+	err = client.Submit(mapreduce.JobSpec{Name: "other", Inputs: []string{"data/in-1"}})
+	checkErrFatal(t, err, "submit failed: %v", err)
+	checkErrFatal(t, client.Cancel("other"), "cancel failed")
+	jobs, err := client.Jobs()
+	checkErrFatal(t, err, "cannot list jobs: %v", err)
+	if len(jobs) != 2 || jobs[0].Name != "wc" || jobs[1].Status != mapreduce.JobCancelled {
+		t.Errorf("unexpected jobs %+v", jobs)
+	}
+}
+
+func TestJobEventsLimit(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in", []byte("foo"))
+	m := mapreduce.NewMaster()
+	checkErrFatal(t, m.Submit("retried", []string{"in"}, 0, mapreduce.WithStorage(store)), "submit failed")
+
+	// A task failing over and over: every attempt records two events
+	for i := 0; i < mapreduce.MaxJobEvents; i++ {
+		reply := getTask(t, m, "w1")
+		if !reply.Available {
+			t.Fatalf("no task for attempt %d", i)
+		}
+		report := &mapreduce.ReportArgs{JobName: "retried", TaskID: reply.Task.TaskID, WorkerID: "w1", Error: "boom"}
+		checkErrFatal(t, m.ReportTaskFailed(report, &struct{}{}), "ReportTaskFailed failed")
+	}
+
+	// Only the last events are kept in memory, the history has them all
+	events, err := m.JobEvents("retried", 0)
+	checkErrFatal(t, err, "cannot get events: %v", err)
+	total := events.Next
+	if total <= 2*mapreduce.MaxJobEvents || events.First == 0 || events.First+len(events.Events) != total ||
+		len(events.Events) > mapreduce.MaxJobEvents {
+		t.Errorf("%d events from %d, next %d, want at most %d of more than %d",
+			len(events.Events), events.First, events.Next, mapreduce.MaxJobEvents, 2*mapreduce.MaxJobEvents)
+	}
+	rest, err := m.JobEvents("retried", total-2)
+	checkErrFatal(t, err, "cannot get events: %v", err)
+	if rest.First != total-2 || len(rest.Events) != 2 || rest.Events[1].Type != mapreduce.EventTaskFailed {
+		t.Errorf("last events: %+v", rest)
+	}
+	checkErrFatal(t, m.CancelJob("retried"), "cancel failed")
+	history, err := mapreduce.ReadHistory(store, mapreduce.DefaultHistoryDir, "retried")
+	checkErrFatal(t, err, "cannot read the history: %v", err)
+	if len(history.Events) != total+1 {
+		t.Errorf("%d events in the history, want %d", len(history.Events), total+1)
+	}
+}
@@ -0,0 +1,48 @@
+package tests
+
+import (
+	"fmt"
+	"mr/mapreduce"
+	"strings"
+	"testing"
+)
+
+func TestLocalParallel(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	expected := make(map[string]string)
+	for i := range 8 {
+		store.WriteFile(fmt.Sprintf("in/%d.txt", i), []byte(strings.Repeat("foo ", i)+"bar"))
+	}
+	expected["foo"], expected["bar"] = "28", "8"
+
+	counters := mapreduce.NewCounters()
+	err := mapreduce.LocalParallel(3)("parallel", []string{"in"}, 4, mapF, reduceF,
+		mapreduce.WithStorage(store), mapreduce.WithCounters(counters))
+	checkErrFatal(t, err, "job failed: %v", err)
+	assertEqualMaps(t, decodeMapFromStorage(t, store, mapreduce.AnsName("parallel")), expected)
+	if n := counters.Get(mapreduce.CounterMapInputRecords); n != 8 {
+		t.Errorf("%d map input records, want 8", n)
+	}
+
+	// A map-only job, described by a spec
+	spec := mapreduce.JobSpec{Name: "parallel-map", Inputs: []string{"in/1.txt", "in/2.txt"}, App: "spec-lines"}
+	err = spec.Run(mapreduce.LocalParallel(0), mapreduce.WithStorage(store))
+	checkErrFatal(t, err, "job failed: %v", err)
+	assertEqualMaps(t, decodeMapFromStorage(t, store, mapreduce.AnsName("parallel-map")),
+		map[string]string{"foo": "1", "~calls": "1"})
+}
+
+func TestLocalParallelRetries(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in", []byte("foo"))
+
+	// Workers without the app give the task back until it failed too often
+	err := mapreduce.LocalParallel(2)("retried", []string{"in"}, 1, mapF, reduceF,
+		mapreduce.WithStorage(store), mapreduce.WithApp("unregistered"), mapreduce.WithMaxAttempts(3))
+	if err == nil || !strings.Contains(err.Error(), "failed 3 times") {
+		t.Errorf("job with an unknown app: %v", err)
+	}
+	if _, err := store.ReadFile(mapreduce.AnsName("retried")); err == nil {
+		t.Errorf("failed job has a result")
+	}
+}
@@ -0,0 +1,128 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"mr/mapreduce"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func scrape(t *testing.T, url string) string {
+	t.Helper()
+	resp, err := http.Get(url)
+	checkErrFatal(t, err, "cannot scrape %s: %v", url, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	checkErrFatal(t, err, "cannot read metrics: %v", err)
+	return string(body)
+}
+
+func TestMasterMetrics(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in-0", []byte("a b"))
+	store.WriteFile("in-1", []byte("c"))
+
+	m := mapreduce.NewMaster()
+	err := m.Submit("metered", []string{"in-0", "in-1"}, 1, mapreduce.WithStorage(store))
+	checkErrFatal(t, err, "submit failed: %v", err)
+
+	// Run the first map task only
+	var reply mapreduce.TaskReply
+	err = m.GetTask(&mapreduce.TaskArgs{WorkerID: "w1"}, &reply)
+	checkErrFatal(t, err, "GetTask failed: %v", err)
+	if !reply.Available || reply.Task.Type != "map" {
+		t.Fatalf("expected a map task, got %+v", reply)
+	}
+	err = m.ReportTaskDone(&mapreduce.ReportArgs{JobName: "metered", TaskID: reply.Task.TaskID, WorkerID: "w1"}, &struct{}{})
+	checkErrFatal(t, err, "ReportTaskDone failed: %v", err)
+
+	srv := httptest.NewServer(m.Handler())
+	defer srv.Close()
+	body := scrape(t, srv.URL+"/metrics")
+
+	for _, line := range []string{
+		"# TYPE mapreduce_tasks gauge",
+		`mapreduce_tasks{job="metered",type="map",status="completed"} 1`,
+		`mapreduce_tasks{job="metered",type="map",status="pending"} 1`,
+		`mapreduce_tasks{job="metered",type="reduce",status="pending"} 1`,
+		`mapreduce_job_progress_ratio{job="metered"} 0.3333333333333333`,
+		`mapreduce_workers{status="Idle"} 1`,
+		`mapreduce_task_duration_seconds_count{type="map"} 1`,
+		`mapreduce_task_duration_seconds_bucket{type="map",le="+Inf"} 1`,
+		`mapreduce_rpc_duration_seconds_count{method="GetTask"} 1`,
+		`mapreduce_rpc_duration_seconds_count{method="ReportTaskDone"} 1`,
+		"# TYPE mapreduce_task_timeouts_total counter",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics lack %q:\n%s", line, body)
+		}
+	}
+}
+
+func TestWorkerMetrics(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in-0", []byte("a b"))
+	store.WriteFile("in-1", []byte("c"))
+	m := mapreduce.NewMaster(mapreduce.WithRPCAddr("127.0.0.1:0"), mapreduce.WithDashboardAddr("127.0.0.1:0"))
+	checkErrFatal(t, m.Serve(), "master failed to start")
+	defer m.Close()
+
+	// The metrics of the workers of the process are labelled with their ID
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	w := mapreduce.NewWorker("metered-w1", m.RPCAddr(), mapF, reduceF, mapreduce.WithStorage(store), mapreduce.WithContext(ctx))
+	go func() { done <- w.Start() }()
+	err := m.RunJob("metered-worker", []string{"in-0", "in-1"}, 1, mapreduce.WithStorage(store))
+	checkErrFatal(t, err, "job failed: %v", err)
+	stop()
+	if err := waitWorker(t, done); err != nil {
+		t.Errorf("worker failed: %v", err)
+	}
+
+	lines := []string{
+		"# TYPE mapreduce_worker_tasks_total counter",
+		`mapreduce_worker_tasks_total{worker="metered-w1",type="map"} 2`,
+		`mapreduce_worker_tasks_total{worker="metered-w1",type="reduce"} 1`,
+		`mapreduce_worker_task_duration_seconds_count{worker="metered-w1",type="map"} 2`,
+		`mapreduce_worker_task_duration_seconds_bucket{worker="metered-w1",type="reduce",le="+Inf"} 1`,
+		`mapreduce_worker_rpc_duration_seconds_count{worker="metered-w1",method="ReportTaskDone"} 3`,
+	}
+	srv := httptest.NewServer(mapreduce.WorkerMetricsHandler())
+	defer srv.Close()
+	body := scrape(t, srv.URL)
+	for _, line := range lines {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("worker metrics lack %q:\n%s", line, body)
+		}
+	}
+
+	// ServeWorkerMetrics serves them at /metrics
+	addr := freeAddr(t)
+	served := make(chan error, 1)
+	go func() { served <- mapreduce.ServeWorkerMetrics(addr) }()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		resp, err := http.Get("http://" + addr + "/metrics")
+		if err == nil {
+			resp.Body.Close()
+			break
+		}
+		select {
+		case err := <-served:
+			t.Fatalf("ServeWorkerMetrics failed: %v", err)
+		default:
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("worker metrics not served: %v", err)
+		}
+	}
+	if body := scrape(t, "http://"+addr+"/metrics"); !strings.Contains(body, lines[1]+"\n") {
+		t.Errorf("served worker metrics lack %q:\n%s", lines[1], body)
+	}
+	if err := mapreduce.ServeWorkerMetrics(addr); err == nil {
+		t.Errorf("worker metrics served twice on %s", addr)
+	}
+}
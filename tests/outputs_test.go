@@ -0,0 +1,78 @@
+package tests
+
+import (
+	"mr/mapreduce"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// mapValidate is an ETL style map function: well formed "name,age" lines
+// go to the main result, the others to the "errors" named output.
+func mapValidate(tc *mapreduce.TaskContext, contents string) (res []mapreduce.KeyValue) {
+	for _, line := range strings.Split(contents, "\n") {
+		if line == "" {
+			continue
+		}
+		name, age, ok := strings.Cut(line, ",")
+		if _, err := strconv.Atoi(age); !ok || err != nil {
+			tc.Emit("errors", mapreduce.KeyValue{Key: line, Value: tc.TaskType})
+			continue
+		}
+		res = append(res, mapreduce.KeyValue{Key: name, Value: age})
+	}
+	return res
+}
+
+func TestMapOnlyNamedOutputs(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("people-0", []byte("alice,31\nbob\ncarol,27\n"))
+	store.WriteFile("people-1", []byte("dave,x\nerin,45\n"))
+
+	opts := []mapreduce.Option{
+		mapreduce.WithStorage(store),
+		mapreduce.WithNamedOutputs("errors"),
+		mapreduce.WithMapTask(mapValidate),
+	}
+	mapreduce.Sequential("etl", []string{"people-0", "people-1"}, 0, nil, nil, opts...)
+
+	assertEqualMaps(t, decodeMapFromStorage(t, store, mapreduce.AnsName("etl")),
+		map[string]string{"alice": "31", "carol": "27", "erin": "45"})
+	assertEqualMaps(t, decodeMapFromStorage(t, store, mapreduce.OutputName("etl", "errors")),
+		map[string]string{"bob": "map", "dave,x": "map"})
+
+	// No shuffle: a map-only job writes no intermediate files
+	names, _ := store.List("mrtmp.etl-0-")
+	if len(names) != 0 {
+		t.Errorf("map-only job wrote intermediate files: %v", names)
+	}
+
+	mapreduce.CleanIntermediary("etl", 2, 0, opts...)
+	names, _ = store.List("mrtmp.etl-")
+	if want := []string{mapreduce.OutputName("etl", "errors")}; len(names) != 1 || names[0] != want[0] {
+		t.Errorf("after cleanup got %v, want %v", names, want)
+	}
+}
+
+func TestReduceNamedOutputs(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in", []byte("the cat and the dog and the bird"))
+
+	// Words seen more than once also go to the "frequent" output
+	reduce := func(tc *mapreduce.TaskContext, key string, values []string) string {
+		res := reduceF(key, values)
+		if n, _ := strconv.Atoi(res); n > 1 {
+			tc.Emit("frequent", mapreduce.KeyValue{Key: key, Value: res})
+		}
+		return res
+	}
+	mapreduce.Sequential("freq", []string{"in"}, 3, mapF, nil,
+		mapreduce.WithStorage(store),
+		mapreduce.WithNamedOutputs("frequent"),
+		mapreduce.WithReduceTask(reduce))
+
+	assertEqualMaps(t, decodeMapFromStorage(t, store, mapreduce.AnsName("freq")),
+		map[string]string{"the": "3", "and": "2", "cat": "1", "dog": "1", "bird": "1"})
+	assertEqualMaps(t, decodeMapFromStorage(t, store, mapreduce.OutputName("freq", "frequent")),
+		map[string]string{"the": "3", "and": "2"})
+}
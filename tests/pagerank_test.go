@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"math"
+	"mr/mapreduce"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// a small graph where every node has links
+var graph = map[string][]string{
+	"A": {"B", "C"},
+	"B": {"C"},
+	"C": {"A"},
+	"D": {"C"},
+}
+
+// expectedRanks computes the fixed point of the PageRank iteration directly,
+// nodes without links keeping their rank
+func expectedRanks(graph map[string][]string) map[string]float64 {
+	ranks := make(map[string]float64)
+	for node, links := range graph {
+		ranks[node] = 1
+		for _, link := range links {
+			ranks[link] = 1
+		}
+	}
+	for i := 0; i < 500; i++ {
+		next := make(map[string]float64)
+		for node := range ranks {
+			next[node] = 1 - mapreduce.PageRankDamping
+			if len(graph[node]) == 0 {
+				next[node] += mapreduce.PageRankDamping * ranks[node]
+			}
+		}
+		for node, links := range graph {
+			for _, link := range links {
+				next[link] += mapreduce.PageRankDamping * ranks[node] / float64(len(links))
+			}
+		}
+		ranks = next
+	}
+	return ranks
+}
+
+func TestPageRank(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("graph/part-0", []byte("A B C\nB C\n"))
+	store.WriteFile("graph/part-1", []byte("C A\nD C\n"))
+
+	job := mapreduce.NewPageRank("pagerank", []string{"graph"}, 2, 100, 1e-4,
+		mapreduce.WithStorage(store))
+	iterations, err := job.Run(mapreduce.SequentialRunner)
+	checkErrFatal(t, err, "pagerank failed: %v", err)
+	if iterations < 2 || iterations == 100 {
+		t.Errorf("pagerank ran %d iterations, expected convergence before the limit", iterations)
+	}
+
+	got := decodeMapFromStorage(t, store, mapreduce.AnsName("pagerank"))
+	for node, want := range expectedRanks(graph) {
+		rank, _, _ := strings.Cut(got[node], "|")
+		r, err := strconv.ParseFloat(rank, 64)
+		if err != nil || math.Abs(r-want) > 1e-3 {
+			t.Errorf("rank of %s = %q, want %.4f", node, got[node], want)
+		}
+	}
+
+	// Only the final result is left
+	names, _ := store.List("mrtmp.")
+	if len(names) != 1 || names[0] != mapreduce.AnsName("pagerank") {
+		t.Errorf("files left after the job: %v", names)
+	}
+}
+
+func TestIterativeMaxIterations(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("graph", []byte("A B C\nB C\nC A\nD C\n"))
+
+	job := mapreduce.NewPageRank("pr2", []string{"graph"}, 1, 3, 0,
+		mapreduce.WithStorage(store))
+	iterations, err := job.Run(mapreduce.SequentialRunner)
+	checkErrFatal(t, err, "pagerank failed: %v", err)
+	if iterations != 3 {
+		t.Errorf("ran %d iterations, want 3", iterations)
+	}
+	if _, err := store.ReadFile(mapreduce.AnsName("pr2")); err != nil {
+		t.Errorf("no result after reaching the iteration limit: %v", err)
+	}
+}
+
+func TestPageRankDanglingNodes(t *testing.T) {
+	// C has no links, and only appears as a link target
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("graph", []byte("A B C\nB C\n"))
+
+	job := mapreduce.NewPageRank("dangling", []string{"graph"}, 1, 200, 1e-5,
+		mapreduce.WithStorage(store))
+	_, err := job.Run(mapreduce.SequentialRunner)
+	checkErrFatal(t, err, "pagerank failed: %v", err)
+
+	got := decodeMapFromStorage(t, store, mapreduce.AnsName("dangling"))
+	expected := expectedRanks(map[string][]string{"A": {"B", "C"}, "B": {"C"}})
+	if len(got) != len(expected) {
+		t.Fatalf("got %v, want the ranks of %d nodes", got, len(expected))
+	}
+	sum := 0.0
+	for node, want := range expected {
+		rank, _, _ := strings.Cut(got[node], "|")
+		r, err := strconv.ParseFloat(rank, 64)
+		if err != nil || math.Abs(r-want) > 1e-3 {
+			t.Errorf("rank of %s = %q, want %.4f", node, got[node], want)
+		}
+		sum += r
+	}
+	// No rank is lost: they still sum up to the number of nodes
+	if math.Abs(sum-3) > 1e-6 {
+		t.Errorf("ranks sum up to %f, want 3", sum)
+	}
+}
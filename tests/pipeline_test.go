@@ -0,0 +1,183 @@
+package tests
+
+import (
+	"fmt"
+	"mr/mapreduce"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// mapTopWord reads a word count result and sends every word to a single key
+func mapTopWord(contents string) (res []mapreduce.KeyValue) {
+	for _, kv := range mapreduce.DecodeKeyValues(contents) {
+		res = append(res, mapreduce.KeyValue{Key: "top", Value: kv.Key + ":" + kv.Value})
+	}
+	return res
+}
+
+// reduceTopWord keeps the most frequent word
+func reduceTopWord(key string, values []string) string {
+	best, bestCount := "", -1
+	for _, v := range values {
+		word, count, _ := strings.Cut(v, ":")
+		n, _ := strconv.Atoi(count)
+		if n > bestCount || n == bestCount && word < best {
+			best, bestCount = word, n
+		}
+	}
+	return best
+}
+
+// mapWordLengths counts the words of each length
+func mapWordLengths(contents string) (res []mapreduce.KeyValue) {
+	for _, word := range strings.Fields(contents) {
+		res = append(res, mapreduce.KeyValue{Key: strconv.Itoa(len(word)), Value: "1"})
+	}
+	return res
+}
+
+// countCalls wraps a map function to count how many times it runs
+func countCalls(calls *int32, f func(string) []mapreduce.KeyValue) func(string) []mapreduce.KeyValue {
+	return func(contents string) []mapreduce.KeyValue {
+		atomic.AddInt32(calls, 1)
+		return f(contents)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("docs/a.txt", []byte("go is fun and go is fast"))
+	store.WriteFile("docs/b.txt", []byte("go go go"))
+
+	var countRuns, topRuns, lengthRuns int32
+	p := &mapreduce.Pipeline{
+		Name:    "analysis",
+		Options: []mapreduce.Option{mapreduce.WithStorage(store)},
+		Stages: []mapreduce.Stage{
+			{Name: "top", From: []string{"count"}, NReduce: 1,
+				MapF: countCalls(&topRuns, mapTopWord), ReduceF: reduceTopWord},
+			{Name: "count", Inputs: []string{"docs"}, NReduce: 2,
+				MapF: countCalls(&countRuns, mapF), ReduceF: reduceF},
+			{Name: "lengths", Inputs: []string{"docs"}, NReduce: 1,
+				MapF: countCalls(&lengthRuns, mapWordLengths), ReduceF: reduceF},
+		},
+	}
+	checkRuns := func(step string, count, top, lengths int32) {
+		t.Helper()
+		got := []int32{atomic.SwapInt32(&countRuns, 0), atomic.SwapInt32(&topRuns, 0), atomic.SwapInt32(&lengthRuns, 0)}
+		if got[0] != count || got[1] != top || got[2] != lengths {
+			t.Errorf("%s: map calls (count, top, lengths) = %v, want [%d %d %d]", step, got, count, top, lengths)
+		}
+	}
+
+	err := p.Run(mapreduce.SequentialRunner)
+	checkErrFatal(t, err, "pipeline failed: %v", err)
+	checkRuns("first run", 2, 1, 2)
+
+	topFile := mapreduce.AnsName(mapreduce.StageJobName("analysis", "top"))
+	assertEqualMaps(t, decodeMapFromStorage(t, store, topFile), map[string]string{"top": "go"})
+	lengthsFile := mapreduce.AnsName(mapreduce.StageJobName("analysis", "lengths"))
+	assertEqualMaps(t, decodeMapFromStorage(t, store, lengthsFile),
+		map[string]string{"2": "7", "3": "2", "4": "1"})
+
+	// The intermediate result of "count" has been cleaned up
+	countFile := mapreduce.AnsName(mapreduce.StageJobName("analysis", "count"))
+	if _, err := store.ReadFile(countFile); err == nil {
+		t.Errorf("intermediate result %s was not removed", countFile)
+	}
+
+	// Nothing changed: everything is skipped
+	err = p.Run(mapreduce.SequentialRunner)
+	checkErrFatal(t, err, "pipeline failed: %v", err)
+	checkRuns("second run", 0, 0, 0)
+
+	// A new version of "top" needs the cleaned result of "count" again,
+	// but "lengths" is still up to date
+	p.Stages[0].Version = "2"
+	err = p.Run(mapreduce.SequentialRunner)
+	checkErrFatal(t, err, "pipeline failed: %v", err)
+	checkRuns("new top version", 2, 1, 0)
+
+	// Changing an input reruns everything depending on it
+	store.WriteFile("docs/b.txt", []byte("fun fun fun fun fun"))
+	err = p.Run(mapreduce.SequentialRunner)
+	checkErrFatal(t, err, "pipeline failed: %v", err)
+	checkRuns("input changed", 2, 1, 2)
+	assertEqualMaps(t, decodeMapFromStorage(t, store, topFile), map[string]string{"top": "fun"})
+
+	// So does changing the settings of a stage
+	p.Stages[2].Options = []mapreduce.Option{mapreduce.WithInputFormat(mapreduce.InputLines)}
+	err = p.Run(mapreduce.SequentialRunner)
+	checkErrFatal(t, err, "pipeline failed: %v", err)
+	checkRuns("settings changed", 0, 0, 2)
+}
+
+func TestDistributedPipeline(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("docs/a.txt", []byte("go is fun and go is fast"))
+	store.WriteFile("docs/b.txt", []byte("go go go"))
+
+	// The workers are started for another job: they run the stages with the
+	// apps the pipeline registers, which the tasks name
+	var countRuns, topRuns int32
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	onServe := func(rpcAddr, dashboardAddr string) {
+		for i := range 2 {
+			w := mapreduce.NewWorker(fmt.Sprintf("w%d", i), rpcAddr, mapWordLengths, reduceTopWord, mapreduce.WithStorage(store))
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- w.Start()
+			}()
+		}
+	}
+	p := &mapreduce.Pipeline{
+		Name: "distributed",
+		Options: []mapreduce.Option{mapreduce.WithStorage(store), mapreduce.WithRPCAddr("127.0.0.1:0"),
+			mapreduce.WithDashboardAddr("127.0.0.1:0"), mapreduce.WithOnServe(onServe)},
+		Stages: []mapreduce.Stage{
+			{Name: "count", Inputs: []string{"docs"}, NReduce: 2,
+				MapF: countCalls(&countRuns, mapF), ReduceF: reduceF},
+			{Name: "top", From: []string{"count"}, NReduce: 1,
+				MapF: countCalls(&topRuns, mapTopWord), ReduceF: reduceTopWord},
+		},
+	}
+	err := mapreduce.StartDistributedPipeline(p)
+	checkErrFatal(t, err, "pipeline failed: %v", err)
+
+	// The master told the workers to exit once the pipeline was done
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Errorf("worker failed: %v", err)
+		}
+	}
+	if countRuns != 2 || topRuns != 1 {
+		t.Errorf("map calls (count, top) = (%d, %d), want (2, 1)", countRuns, topRuns)
+	}
+	topFile := mapreduce.AnsName(mapreduce.StageJobName("distributed", "top"))
+	assertEqualMaps(t, decodeMapFromStorage(t, store, topFile), map[string]string{"top": "go"})
+}
+
+func TestPipelineErrors(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in", []byte("x"))
+	opts := []mapreduce.Option{mapreduce.WithStorage(store)}
+
+	for _, stages := range [][]mapreduce.Stage{
+		{{Name: "a", Inputs: []string{"in"}}, {Name: "a", Inputs: []string{"in"}}},
+		{{Name: "a", From: []string{"missing"}}},
+		{{Name: "a", From: []string{"b"}}, {Name: "b", From: []string{"a"}}},
+		{{Name: "a", Inputs: []string{"in"}}, {Name: "b", From: []string{"a/nope"}}},
+	} {
+		p := &mapreduce.Pipeline{Name: "bad", Stages: stages, Options: opts}
+		if err := p.Run(mapreduce.SequentialRunner); err == nil {
+			t.Errorf("pipeline %+v should have been rejected", stages)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"mr/mapreduce"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPlanJob(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in/a.txt", []byte("foo bar foo baz"))
+	store.WriteFile("in/b.txt", []byte("bar qux"))
+	store.WriteFile("in/empty.txt", nil)
+	before, _ := store.List("")
+
+	plan, err := mapreduce.PlanJob("plan", []string{"in", "missing.txt"}, 3, mapF, reduceF, mapreduce.WithStorage(store))
+	checkErrFatal(t, err, "cannot plan job: %v", err)
+
+	// Nothing was written
+	if after, _ := store.List(""); !reflect.DeepEqual(before, after) {
+		t.Errorf("planning wrote files: %v", after)
+	}
+	if len(plan.MapTasks) != 3 || plan.MapTasks[1].File != "in/b.txt" || !plan.MapTasks[1].Sampled ||
+		plan.ReduceTasks != 3 || plan.InputBytes != 22 || plan.SampledBytes != 22 {
+		t.Errorf("unexpected plan %+v", plan)
+	}
+	warnings := strings.Join(plan.Warnings, "\n")
+	if len(plan.Warnings) != 2 || !strings.Contains(warnings, "missing.txt") || !strings.Contains(warnings, "in/empty.txt is empty") {
+		t.Errorf("unexpected warnings %q", plan.Warnings)
+	}
+
+	// The inputs were sampled whole, so the estimates are the actual sizes
+	mapreduce.Sequential("plan", []string{"in"}, 3, mapF, reduceF, mapreduce.WithStorage(store))
+	var intermediate int64
+	for m := range 3 {
+		for r := range 3 {
+			data, _ := store.ReadFile(mapreduce.ReduceName("plan", m, r))
+			intermediate += int64(len(data))
+		}
+	}
+	result, _ := store.ReadFile(mapreduce.AnsName("plan"))
+	if plan.EstimatedIntermediateBytes != intermediate || plan.EstimatedOutputBytes != int64(len(result)) ||
+		plan.EstimatedOutputRecords != 4 {
+		t.Errorf("estimated %d intermediate and %d output bytes (%d records), got %d and %d",
+			plan.EstimatedIntermediateBytes, plan.EstimatedOutputBytes, plan.EstimatedOutputRecords, intermediate, len(result))
+	}
+}
+
+func TestPlanJobSkew(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in", []byte(strings.Repeat("foo ", 100)+"bar baz"))
+	mapreduce.RegisterPartitioner("plan-skewed", func(key string, nReduce int) int {
+		if key == "foo" {
+			return 0
+		}
+		return 1
+	})
+
+	mapreduce.RegisterApp("wordcount-plan", mapreduce.App{Map: mapF, Reduce: reduceF})
+
+	spec := mapreduce.JobSpec{Name: "skew", Inputs: []string{"in"}, NReduce: 4, Map: "wordcount-plan", Reduce: "wordcount-plan",
+		Partitioner: "plan-skewed"}
+	plan, err := spec.Plan(mapreduce.WithStorage(store))
+	checkErrFatal(t, err, "cannot plan job: %v", err)
+	if len(plan.Warnings) != 1 || !strings.Contains(plan.Warnings[0], "skewed") {
+		t.Errorf("skew not reported: %q", plan.Warnings)
+	}
+
+	// A combiner evens out the partitions
+	spec = mapreduce.JobSpec{Name: "skew", Inputs: []string{"in"}, NReduce: 2, App: "wordcount-plan", Combiner: "wordcount-plan"}
+	if plan, err = spec.Plan(mapreduce.WithStorage(store)); err != nil || len(plan.Warnings) != 0 {
+		t.Errorf("plan with a combiner: %v %q", err, plan.Warnings)
+	}
+
+	// Invalid specs are errors
+	if _, err := (mapreduce.JobSpec{Name: "skew", NReduce: 1, App: "wordcount-plan"}).Plan(); err == nil {
+		t.Errorf("plan without inputs")
+	}
+}
+
+func TestPartitionOutOfRange(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in", []byte("foo bar"))
+	mapreduce.RegisterPartitioner("out-of-range", func(key string, nReduce int) int { return nReduce })
+	opts := []mapreduce.Option{mapreduce.WithStorage(store), mapreduce.WithPartitioner("out-of-range")}
+
+	// The map task fails with the partition it got, leaving no file behind
+	err := mapreduce.DoMap("partition", 0, "in", 2, mapF, opts...)
+	if err == nil || !strings.Contains(err.Error(), `partitioner "out-of-range" sent key`) ||
+		!strings.Contains(err.Error(), "reduce task 2, out of [0, 2)") {
+		t.Errorf("map task with a partition out of range: %v", err)
+	}
+	if names, _ := store.List("mrtmp."); len(names) > 0 {
+		t.Errorf("files left: %v", names)
+	}
+
+	// The plan warns about it, and a job on workers fails once the task
+	// failed as many times as allowed
+	plan, err := mapreduce.PlanJob("partition", []string{"in"}, 2, mapF, reduceF, opts...)
+	checkErrFatal(t, err, "cannot plan job: %v", err)
+	if len(plan.Warnings) != 1 || !strings.Contains(plan.Warnings[0], "out of [0, 2)") {
+		t.Errorf("plan with a partition out of range: %q", plan.Warnings)
+	}
+	err = mapreduce.LocalParallel(1)("partition", []string{"in"}, 2, mapF, reduceF,
+		append(opts, mapreduce.WithMaxAttempts(2))...)
+	if err == nil || !strings.Contains(err.Error(), "failed 2 times") {
+		t.Errorf("job with a partition out of range: %v", err)
+	}
+}
+
+func TestUnknownTaskFunctions(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in", []byte("foo bar"))
+
+	// A map task naming functions this process does not have fails, instead
+	// of running others
+	for _, opt := range []mapreduce.Option{
+		mapreduce.WithPartitioner("unregistered"),
+		mapreduce.WithCombiner("unregistered"),
+	} {
+		err := mapreduce.DoMap("unknown", 0, "in", 2, mapF, mapreduce.WithStorage(store), opt)
+		if err == nil || !strings.Contains(err.Error(), `"unregistered"`) {
+			t.Errorf("map task with an unknown function: %v", err)
+		}
+	}
+	if names, _ := store.List("mrtmp."); len(names) > 0 {
+		t.Errorf("files left: %v", names)
+	}
+}
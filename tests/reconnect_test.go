@@ -0,0 +1,157 @@
+package tests
+
+import (
+	"errors"
+	"fmt"
+	"mr/mapreduce"
+	"net"
+	"net/rpc"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMaster answers the RPC calls of workers: no task, then exit once
+// told to, calling onGetTask first
+type fakeMaster struct {
+	mu        sync.Mutex
+	sessions  []string
+	getTasks  []string // sessions of the GetTask calls
+	exit      bool
+	onGetTask func()
+}
+
+func (f *fakeMaster) Register(args *mapreduce.RegisterArgs, reply *mapreduce.RegisterReply) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	reply.Session = fmt.Sprintf("%s-%d", args.WorkerID, len(f.sessions))
+	f.sessions = append(f.sessions, reply.Session)
+	return nil
+}
+
+func (f *fakeMaster) GetTask(args *mapreduce.TaskArgs, reply *mapreduce.TaskReply) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.getTasks = append(f.getTasks, args.Session)
+	if f.onGetTask != nil {
+		f.onGetTask()
+	}
+	reply.Exit = f.exit
+	return nil
+}
+
+// serveFake serves f on addr until the returned function is called, which
+// closes the connections of the workers as well
+func serveFake(t *testing.T, addr string, f *fakeMaster) func() {
+	t.Helper()
+	server := rpc.NewServer()
+	server.RegisterName("Master", f)
+	l, err := net.Listen("tcp", addr)
+	checkErrFatal(t, err, "cannot listen: %v", err)
+	var mu sync.Mutex
+	var conns []net.Conn
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, conn)
+			mu.Unlock()
+			go server.ServeConn(conn)
+		}
+	}()
+	return func() {
+		l.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}
+}
+
+// freeAddr returns a local address no server listens on, for the masters
+// that are down or that restart on the same address
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	checkErrFatal(t, err, "cannot listen: %v", err)
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// startWorker runs a worker, returning the channel Start returns on
+func startWorker(addr string, opts ...mapreduce.Option) chan error {
+	done := make(chan error, 1)
+	w := mapreduce.NewWorker("w1", addr, mapF, reduceF, opts...)
+	go func() { done <- w.Start() }()
+	return done
+}
+
+func waitWorker(t *testing.T, done chan error) error {
+	t.Helper()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(10 * time.Second):
+		t.Fatalf("the worker did not stop")
+		return nil
+	}
+}
+
+func TestWorkerFailover(t *testing.T) {
+	// The first address is down, the worker goes on with the next one
+	standby := &fakeMaster{exit: true}
+	standbyAddr := freeAddr(t)
+	defer serveFake(t, standbyAddr, standby)()
+	err := waitWorker(t, startWorker(freeAddr(t), mapreduce.WithMasterAddrs(standbyAddr)))
+	checkErrFatal(t, err, "worker failed: %v", err)
+	standby.mu.Lock()
+	defer standby.mu.Unlock()
+	if len(standby.sessions) != 1 || len(standby.getTasks) != 1 {
+		t.Errorf("standby got %d registrations and %d GetTask calls, want 1 and 1", len(standby.sessions), len(standby.getTasks))
+	}
+}
+
+func TestWorkerReconnect(t *testing.T) {
+	addr := freeAddr(t)
+	restarted := &fakeMaster{exit: true}
+	first := &fakeMaster{}
+	closeFirst := serveFake(t, addr, first)
+	closeRestarted := make(chan func(), 1)
+	var once sync.Once
+	first.onGetTask = func() {
+		// The master goes down while the worker waits for its reply, and
+		// restarts a moment later on the same address
+		once.Do(func() {
+			go func() {
+				closeFirst()
+				time.Sleep(200 * time.Millisecond)
+				closeRestarted <- serveFake(t, addr, restarted)
+			}()
+		})
+	}
+	defer func() { (<-closeRestarted)() }()
+
+	err := waitWorker(t, startWorker(addr))
+	checkErrFatal(t, err, "worker failed: %v", err)
+	restarted.mu.Lock()
+	defer restarted.mu.Unlock()
+	if len(restarted.sessions) != 1 || len(restarted.getTasks) != 1 || restarted.getTasks[0] != restarted.sessions[0] {
+		t.Errorf("restarted master got sessions %v and GetTask calls in %v", restarted.sessions, restarted.getTasks)
+	}
+}
+
+func TestWorkerGivesUp(t *testing.T) {
+	start := time.Now()
+	err := waitWorker(t, startWorker(freeAddr(t), mapreduce.WithReconnectTimeout(300*time.Millisecond)))
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		t.Errorf("worker without master: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("worker gave up after %v", elapsed)
+	}
+}
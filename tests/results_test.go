@@ -0,0 +1,178 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mr/mapreduce"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func keysOf(kvs []mapreduce.KeyValue) []string {
+	var keys []string
+	for _, kv := range kvs {
+		keys = append(keys, kv.Key)
+	}
+	return keys
+}
+
+func TestResultPages(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in", []byte("apple apricot banana apple cherry avocado blueberry"))
+	mapreduce.Sequential("fruits", []string{"in"}, 1, mapF, reduceF, mapreduce.WithStorage(store))
+
+	// Page through the keys starting with "a", two at a time
+	var keys []string
+	q := mapreduce.ResultQuery{Prefix: "a", Limit: 2}
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatalf("too many pages")
+		}
+		page, err := mapreduce.ReadResults(store, "fruits", q)
+		checkErrFatal(t, err, "cannot read results: %v", err)
+		keys = append(keys, keysOf(page.Records)...)
+		if page.Next < 0 {
+			break
+		}
+		q.Offset = page.Next
+	}
+	if expected := []string{"apple", "apricot", "avocado"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("keys got %v, want %v", keys, expected)
+	}
+
+	// A page holding the last records has no next one
+	page, err := mapreduce.ReadResults(store, "fruits", mapreduce.ResultQuery{Search: "rr", Limit: 2})
+	checkErrFatal(t, err, "cannot read results: %v", err)
+	if expected := []string{"blueberry", "cherry"}; !reflect.DeepEqual(keysOf(page.Records), expected) || page.Next != -1 {
+		t.Errorf("search got %v (next %d), want %v", keysOf(page.Records), page.Next, expected)
+	}
+}
+
+func TestResultDownloads(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in", []byte("b a b \"c,d\""))
+	mapreduce.Sequential("letters", []string{"in"}, 1, func(contents string) []mapreduce.KeyValue {
+		var kvs []mapreduce.KeyValue
+		for _, w := range bytes.Fields([]byte(contents)) {
+			kvs = append(kvs, mapreduce.KeyValue{Key: string(w), Value: "1"})
+		}
+		return kvs
+	}, reduceF, mapreduce.WithStorage(store))
+
+	for format, expected := range map[string]string{
+		"jsonl": "{\"Key\":\"\\\"c,d\\\"\",\"Value\":\"1\"}\n{\"Key\":\"a\",\"Value\":\"1\"}\n{\"Key\":\"b\",\"Value\":\"2\"}\n",
+		"json":  "[\n{\"Key\":\"\\\"c,d\\\"\",\"Value\":\"1\"},\n{\"Key\":\"a\",\"Value\":\"1\"},\n{\"Key\":\"b\",\"Value\":\"2\"}\n]\n",
+		"csv":   "key,value\n\"\"\"c,d\"\"\",1\na,1\nb,2\n",
+		"tsv":   "key\tvalue\n\"\"\"c,d\"\"\"\t1\na\t1\nb\t2\n",
+	} {
+		var buf bytes.Buffer
+		err := mapreduce.WriteResults(&buf, store, "letters", mapreduce.ResultQuery{}, format)
+		checkErrFatal(t, err, "cannot write %s: %v", format, err)
+		if buf.String() != expected {
+			t.Errorf("%s got %q, want %q", format, buf.String(), expected)
+		}
+	}
+	var buf bytes.Buffer
+	if err := mapreduce.WriteResults(&buf, store, "letters", mapreduce.ResultQuery{}, "xml"); err == nil {
+		t.Errorf("no error for an unknown format")
+	}
+}
+
+func TestResultsAPINotReady(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in", []byte("a"))
+	m := mapreduce.NewMaster()
+	err := m.Submit("pending", []string{"in"}, 1, mapreduce.WithStorage(store))
+	checkErrFatal(t, err, "submit failed: %v", err)
+	srv := httptest.NewServer(m.Handler())
+	defer srv.Close()
+
+	for _, path := range []string{"/api/results/pending", "/api/results/unknown/download"} {
+		resp, err := http.Get(srv.URL + path)
+		checkErrFatal(t, err, "cannot get %s: %v", path, err)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: status %d, want %d", path, resp.StatusCode, http.StatusNotFound)
+		}
+	}
+}
+
+func TestResultsAPI(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in", []byte("apple apricot banana apple cherry avocado blueberry"))
+	m := mapreduce.NewMaster(mapreduce.WithRPCAddr("127.0.0.1:0"), mapreduce.WithDashboardAddr("127.0.0.1:0"))
+	checkErrFatal(t, m.Serve(), "master failed to start")
+	defer m.Close()
+	ctx, stop := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	w := mapreduce.NewWorker("w1", m.RPCAddr(), mapF, reduceF, mapreduce.WithStorage(store), mapreduce.WithContext(ctx))
+	go func() { done <- w.Start() }()
+	err := m.RunJob("fruits-api", []string{"in"}, 1, mapreduce.WithStorage(store))
+	checkErrFatal(t, err, "job failed: %v", err)
+	stop()
+	if err := waitWorker(t, done); err != nil {
+		t.Errorf("worker failed: %v", err)
+	}
+	srv := httptest.NewServer(m.Handler())
+	defer srv.Close()
+
+	get := func(path string) (*http.Response, []byte) {
+		t.Helper()
+		resp, err := http.Get(srv.URL + path)
+		checkErrFatal(t, err, "cannot get %s: %v", path, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		checkErrFatal(t, err, "cannot read %s: %v", path, err)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", path, resp.StatusCode, body)
+		}
+		return resp, body
+	}
+
+	// Page through the keys starting with "a", two at a time
+	var keys []string
+	next := int64(0)
+	for pages := 0; next >= 0; pages++ {
+		if pages > 3 {
+			t.Fatalf("too many pages")
+		}
+		_, body := get(fmt.Sprintf("/api/results/fruits-api?prefix=a&limit=2&offset=%d", next))
+		var page mapreduce.ResultPage
+		checkErrFatal(t, json.Unmarshal(body, &page), "invalid page %s", body)
+		if page.Job != "fruits-api" || page.Offset != next {
+			t.Errorf("page %+v, want offset %d", page, next)
+		}
+		keys = append(keys, keysOf(page.Records)...)
+		next = page.Next
+	}
+	if expected := []string{"apple", "apricot", "avocado"}; !reflect.DeepEqual(keys, expected) {
+		t.Errorf("keys got %v, want %v", keys, expected)
+	}
+
+	// Search
+	_, body := get("/api/results/fruits-api?q=rr")
+	var page mapreduce.ResultPage
+	checkErrFatal(t, json.Unmarshal(body, &page), "invalid page %s", body)
+	if expected := []string{"blueberry", "cherry"}; !reflect.DeepEqual(keysOf(page.Records), expected) || page.Next != -1 {
+		t.Errorf("search got %v (next %d), want %v", keysOf(page.Records), page.Next, expected)
+	}
+
+	// Download the matching records as a file
+	resp, body := get("/api/results/fruits-api/download?format=csv&prefix=b")
+	if ct, cd := resp.Header.Get("Content-Type"), resp.Header.Get("Content-Disposition"); ct != "text/csv" ||
+		cd != `attachment; filename="fruits-api.csv"` {
+		t.Errorf("download headers %q and %q", ct, cd)
+	}
+	if expected := "key,value\nbanana,1\nblueberry,1\n"; string(body) != expected {
+		t.Errorf("download got %q, want %q", body, expected)
+	}
+	resp, body = get("/api/results/fruits-api/download")
+	if resp.Header.Get("Content-Type") != "application/x-ndjson" || bytes.Count(body, []byte("\n")) != 6 {
+		t.Errorf("default download %q: %q", resp.Header.Get("Content-Type"), body)
+	}
+}
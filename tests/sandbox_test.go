@@ -0,0 +1,125 @@
+package tests
+
+import (
+	"context"
+	"mr/mapreduce"
+	"net"
+	"net/rpc"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJobNames(t *testing.T) {
+	for name, valid := range map[string]bool{
+		"wordcount":              true,
+		"logs-2024.10_v2":        true,
+		"":                       false,
+		"-rf":                    false,
+		".hidden":                false,
+		"../escape":              false,
+		"a/b":                    false,
+		"with space":             false,
+		strings.Repeat("x", 129): false,
+	} {
+		if mapreduce.ValidJobName(name) != valid {
+			t.Errorf("ValidJobName(%q) = %v, want %v", name, !valid, valid)
+		}
+	}
+
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in", []byte("a"))
+	err := mapreduce.NewMaster().Submit("../../etc/cron.d/job", []string{"in"}, 1, mapreduce.WithStorage(store))
+	if err == nil {
+		t.Errorf("job with an unsafe name submitted")
+	}
+}
+
+func TestInputRoots(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("data/in", []byte("a"))
+	store.WriteFile("private/key", []byte("b"))
+	store.WriteFile("../outside", []byte("c"))
+
+	for _, tc := range []struct {
+		inputs []string
+		roots  []string
+		ok     bool
+	}{
+		{[]string{"data"}, []string{"data"}, true},
+		{[]string{"data/in"}, []string{"data/"}, true},
+		{[]string{"data", "private/key"}, []string{"data"}, false},
+		{[]string{"private"}, []string{"data"}, false},
+		{[]string{"data/../private/key"}, []string{"data"}, false},
+		{[]string{"../outside"}, nil, false},
+		{[]string{"data"}, []string{"../"}, false},
+	} {
+		m := mapreduce.NewMaster()
+		err := m.Submit("rooted", tc.inputs, 1, mapreduce.WithStorage(store), mapreduce.WithInputRoots(tc.roots...))
+		if (err == nil) != tc.ok {
+			t.Errorf("inputs %v in roots %v: got error %v", tc.inputs, tc.roots, err)
+		}
+	}
+}
+
+// sandboxMaster hands out a single task and records how it was reported
+type sandboxMaster struct {
+	task     mapreduce.Task
+	given    bool
+	reported chan mapreduce.ReportArgs
+}
+
+func (s *sandboxMaster) Register(args *mapreduce.RegisterArgs, reply *mapreduce.RegisterReply) error {
+	return nil
+}
+
+func (s *sandboxMaster) GetTask(args *mapreduce.TaskArgs, reply *mapreduce.TaskReply) error {
+	reply.Available, s.given = !s.given, true
+	reply.Task = s.task
+	return nil
+}
+
+func (s *sandboxMaster) ReportTaskDone(args *mapreduce.ReportArgs, reply *struct{}) error {
+	s.reported <- *args
+	return nil
+}
+
+func (s *sandboxMaster) ReportTaskFailed(args *mapreduce.ReportArgs, reply *struct{}) error {
+	s.reported <- *args
+	return nil
+}
+
+func TestWorkerSandbox(t *testing.T) {
+	for _, task := range []mapreduce.Task{
+		{Type: "map", JobName: "leak", File: "/etc/passwd", NReduce: 1},
+		{Type: "map", JobName: "leak", File: "private/key", NReduce: 1},
+		{Type: "reduce", JobName: "../leak", NMap: 1},
+	} {
+		fake := &sandboxMaster{task: task, reported: make(chan mapreduce.ReportArgs, 1)}
+		server := rpc.NewServer()
+		server.RegisterName("Master", fake)
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		checkErrFatal(t, err, "cannot listen: %v", err)
+		go server.Accept(l)
+
+		// The worker is stopped once it reported the task
+		ctx, stop := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		w := mapreduce.NewWorker("w1", l.Addr().String(), mapF, reduceF, mapreduce.WithContext(ctx),
+			mapreduce.WithStorage(mapreduce.NewMemStorage()), mapreduce.WithInputRoots("data"))
+		go func() { done <- w.Start() }()
+		select {
+		case report := <-fake.reported:
+			if report.Error == "" {
+				t.Errorf("task %+v run, want refused", task)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("task %+v not reported", task)
+		}
+		stop()
+		if err := waitWorker(t, done); err != nil {
+			t.Errorf("worker failed: %v", err)
+		}
+		l.Close()
+	}
+}
@@ -0,0 +1,59 @@
+package tests
+
+import (
+	"mr/mapreduce"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestStartDistributedResult(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in/a", []byte("foo bar"))
+	store.WriteFile("in/b", []byte("foo"))
+
+	// A worker joins the master once it listens, and runs the tasks until
+	// it is told to exit
+	rpcAddrs := make(chan string, 1)
+	done := make(chan error, 1)
+	onServe := func(rpcAddr, dashboardAddr string) {
+		rpcAddrs <- rpcAddr
+		w := mapreduce.NewWorker("w1", rpcAddr, mapF, reduceF, mapreduce.WithStorage(store))
+		go func() { done <- w.Start() }()
+	}
+	results := make(chan *mapreduce.JobResult)
+	go func() {
+		results <- mapreduce.StartDistributed("shutdown", []string{"in"}, 2, mapF, reduceF, mapreduce.WithStorage(store),
+			mapreduce.WithRPCAddr("127.0.0.1:0"), mapreduce.WithDashboardAddr("127.0.0.1:0"), mapreduce.WithOutputFormat("csv"),
+			mapreduce.WithOnServe(onServe))
+	}()
+
+	var res *mapreduce.JobResult
+	select {
+	case res = <-results:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("StartDistributed did not return")
+	}
+	if err := waitWorker(t, done); err != nil {
+		t.Errorf("worker failed: %v", err)
+	}
+	if res.Err != nil || res.Output != mapreduce.AnsName("shutdown") || res.Export != mapreduce.ExportName("shutdown", "csv") ||
+		res.Counters[mapreduce.CounterMapInputRecords] != 2 || res.Counters[mapreduce.CounterReduceOutputRecords] != 2 ||
+		res.Duration <= 0 {
+		t.Errorf("unexpected result %+v", res)
+	}
+	assertEqualMaps(t, decodeMapFromStorage(t, store, res.Output), map[string]string{"foo": "2", "bar": "1"})
+
+	// The RPC server is closed
+	if conn, err := net.Dial("tcp", <-rpcAddrs); err == nil {
+		conn.Close()
+		t.Errorf("RPC server still listening")
+	}
+
+	// Failures are reported in the result
+	res = mapreduce.StartDistributed("missing", []string{"nothing"}, 1, mapF, reduceF, mapreduce.WithStorage(store),
+		mapreduce.WithRPCAddr("127.0.0.1:0"), mapreduce.WithDashboardAddr("127.0.0.1:0"))
+	if res.Err == nil || res.Output != "" {
+		t.Errorf("job without inputs: %+v", res)
+	}
+}
@@ -0,0 +1,108 @@
+package tests
+
+import (
+	"encoding/json"
+	"fmt"
+	"mr/mapreduce"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func workerInfo(t *testing.T, url, workerID string) mapreduce.WorkerInfo {
+	t.Helper()
+	var data mapreduce.DashboardData
+	json.Unmarshal([]byte(scrape(t, url+"/data")), &data)
+	for _, w := range data.Workers {
+		if w.Name == workerID {
+			return w
+		}
+	}
+	t.Fatalf("no worker %s on the dashboard", workerID)
+	return mapreduce.WorkerInfo{}
+}
+
+func TestSlotAssignment(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in-0", []byte("a"))
+	store.WriteFile("in-1", []byte("b"))
+	m := mapreduce.NewMaster()
+	err := m.Submit("slotted", []string{"in-0", "in-1"}, 1, mapreduce.WithStorage(store))
+	checkErrFatal(t, err, "submit failed: %v", err)
+	srv := httptest.NewServer(m.Handler())
+	defer srv.Close()
+
+	getTask := func(free mapreduce.Slots) mapreduce.TaskReply {
+		t.Helper()
+		var reply mapreduce.TaskReply
+		err := m.GetTask(&mapreduce.TaskArgs{WorkerID: "w1", Free: free}, &reply)
+		checkErrFatal(t, err, "GetTask failed: %v", err)
+		return reply
+	}
+
+	// Only free reduce slots: the map tasks wait for another worker
+	if getTask(mapreduce.Slots{Reduce: 1}).Available {
+		t.Errorf("map task assigned to a free reduce slot")
+	}
+	// Two free map slots: the worker runs both map tasks at once
+	first, second := getTask(mapreduce.Slots{Map: 2, Reduce: 1}), getTask(mapreduce.Slots{Map: 1, Reduce: 1})
+	if first.Task.Type != "map" || second.Task.Type != "map" || first.Task.TaskID == second.Task.TaskID {
+		t.Fatalf("got tasks %+v and %+v, want both map tasks", first.Task, second.Task)
+	}
+	err = m.Heartbeat(&mapreduce.HeartbeatArgs{WorkerID: "w1", JobName: "slotted", TaskID: first.Task.TaskID,
+		Slots: mapreduce.Slots{Map: 2, Reduce: 1}, Used: mapreduce.Slots{Map: 2}}, &mapreduce.HeartbeatReply{})
+	checkErrFatal(t, err, "Heartbeat failed: %v", err)
+	info := workerInfo(t, srv.URL, "w1")
+	if info.Status != "Working" || info.Tasks != (mapreduce.Slots{Map: 2}) ||
+		info.Slots != (mapreduce.Slots{Map: 2, Reduce: 1}) || info.Used != (mapreduce.Slots{Map: 2}) {
+		t.Errorf("worker running two map tasks: %+v", info)
+	}
+
+	// The worker works until both are done
+	report := func(taskID int) {
+		t.Helper()
+		err := m.ReportTaskDone(&mapreduce.ReportArgs{JobName: "slotted", TaskID: taskID, WorkerID: "w1"}, &struct{}{})
+		checkErrFatal(t, err, "ReportTaskDone failed: %v", err)
+	}
+	report(first.Task.TaskID)
+	if info := workerInfo(t, srv.URL, "w1"); info.Status != "Working" || info.Tasks != (mapreduce.Slots{Map: 1}) {
+		t.Errorf("worker running a map task: %+v", info)
+	}
+	report(second.Task.TaskID)
+	if info := workerInfo(t, srv.URL, "w1"); info.Status != "Idle" || info.Tasks != (mapreduce.Slots{}) {
+		t.Errorf("worker without tasks: %+v", info)
+	}
+	if task := getTask(mapreduce.Slots{Reduce: 1}); task.Task.Type != "reduce" {
+		t.Errorf("got %+v, want the reduce task", task)
+	}
+}
+
+func TestSlotsConcurrency(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	for i := range 8 {
+		store.WriteFile(fmt.Sprintf("in/%d", i), []byte("foo"))
+	}
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	slowMap := func(contents string) []mapreduce.KeyValue {
+		mu.Lock()
+		running++
+		maxRunning = max(maxRunning, running)
+		mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return mapF(contents)
+	}
+
+	// A single worker runs up to 4 map tasks at once
+	err := mapreduce.LocalParallel(1)("concurrent", []string{"in"}, 2, slowMap, reduceF,
+		mapreduce.WithStorage(store), mapreduce.WithSlots(4, 2))
+	checkErrFatal(t, err, "job failed: %v", err)
+	assertEqualMaps(t, decodeMapFromStorage(t, store, mapreduce.AnsName("concurrent")), map[string]string{"foo": "8"})
+	if maxRunning < 2 || maxRunning > 4 {
+		t.Errorf("%d map tasks ran at once, want 2 to 4", maxRunning)
+	}
+}
@@ -0,0 +1,207 @@
+package tests
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"mr/mapreduce"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const yamlSpec = `
+# Counts lines by first word
+name: lines
+inputs:
+  - in/a.txt
+  - "in/b.txt"   # quoted
+nReduce: 2
+app: spec-lines
+include: [ '*.txt', "*.log" ]
+inputFormat: lines
+combiner: spec-lines
+partitioner: spec-first
+outputFormat: csv
+compression: gzip
+taskTimeout: 30s
+maxAttempts: 3
+counters:
+  map.malformed: 0
+`
+
+const jsonSpec = `{
+	"Name": "lines", "Inputs": ["in/a.txt", "in/b.txt"], "NReduce": 2, "App": "spec-lines",
+	"Include": ["*.txt", "*.log"], "InputFormat": "lines", "Combiner": "spec-lines",
+	"Partitioner": "spec-first", "OutputFormat": "csv", "Compression": "gzip",
+	"TaskTimeout": 30, "MaxAttempts": 3, "Counters": {"map.malformed": 0}
+}`
+
+func init() {
+	// Emits the first word of each line it is given, and the number of calls
+	mapreduce.RegisterApp("spec-lines", mapreduce.App{
+		Map: func(contents string) []mapreduce.KeyValue {
+			return []mapreduce.KeyValue{{Key: strings.Fields(contents)[0], Value: "1"}, {Key: "~calls", Value: "1"}}
+		},
+		Reduce: reduceF,
+	})
+	mapreduce.RegisterPartitioner("spec-first", func(key string, nReduce int) int { return 0 })
+}
+
+func mustRead(t *testing.T, store *mapreduce.MemStorage, name string) []byte {
+	t.Helper()
+	data, err := store.ReadFile(name)
+	checkErrFatal(t, err, "cannot read %s: %v", name, err)
+	return data
+}
+
+func TestParseJobSpec(t *testing.T) {
+	fromYAML, err := mapreduce.ParseJobSpec([]byte(yamlSpec))
+	checkErrFatal(t, err, "cannot parse YAML spec: %v", err)
+	fromJSON, err := mapreduce.ParseJobSpec([]byte(jsonSpec))
+	checkErrFatal(t, err, "cannot parse JSON spec: %v", err)
+	if !reflect.DeepEqual(fromYAML, fromJSON) {
+		t.Errorf("YAML spec %+v differs from JSON spec %+v", fromYAML, fromJSON)
+	}
+	if fromYAML.TaskTimeout != mapreduce.Duration(30*time.Second) || fromYAML.Include[1] != "*.log" {
+		t.Errorf("unexpected spec %+v", fromYAML)
+	}
+	if err := fromYAML.Validate(); err != nil {
+		t.Errorf("valid spec rejected: %v", err)
+	}
+
+	// Syntax errors name the line or the field
+	for _, tc := range []struct{ spec, err string }{
+		{"name: a\n  nReduce: 2\n", "line 2"},
+		{"name: a\nname: b\n", "duplicate key"},
+		{"name: a\nunknown: 1\n", "unknown field"},
+		{"name: [a\n", "line 1"},
+		{"nReduce: two\n", "nReduce"},
+		{"taskTimeout: soon\n", "invalid duration"},
+		{"inputs:\n  - path: a\n", "not supported"},
+	} {
+		if _, err := mapreduce.ParseJobSpec([]byte(tc.spec)); err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("spec %q: error %v, want one containing %q", tc.spec, err, tc.err)
+		}
+	}
+}
+
+func TestJobSpecValidate(t *testing.T) {
+	spec := mapreduce.JobSpec{
+		Name:         "../x",
+		NReduce:      -1,
+		App:          "missing",
+		InputFormat:  "xml",
+		Partitioner:  "random",
+		OutputFormat: "pdf",
+		Compression:  "zip",
+		TaskTimeout:  mapreduce.Duration(-time.Second),
+		MaxAttempts:  -1,
+	}
+	err := spec.Validate()
+	if err == nil {
+		t.Fatalf("invalid spec accepted")
+	}
+	for _, field := range []string{"Name", "Inputs", "NReduce", "App", "InputFormat", "Partitioner",
+		"OutputFormat", "Compression", "TaskTimeout", "MaxAttempts"} {
+		if !strings.Contains(err.Error(), field+": ") {
+			t.Errorf("error %q does not report %s", err, field)
+		}
+	}
+}
+
+func TestJobSpecRun(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in/a.txt", []byte("foo 1\nbar 2\nfoo 3\n\n"))
+	store.WriteFile("in/b.txt", []byte("foo 4\n"))
+	spec, err := mapreduce.ParseJobSpec([]byte(yamlSpec))
+	checkErrFatal(t, err, "cannot parse spec: %v", err)
+
+	counters := mapreduce.NewCounters()
+	err = spec.Run(mapreduce.SequentialRunner, mapreduce.WithStorage(store), mapreduce.WithCounters(counters))
+	checkErrFatal(t, err, "cannot run spec: %v", err)
+
+	expected := map[string]string{"foo": "3", "bar": "1", "~calls": "4"}
+	assertEqualMaps(t, decodeMapFromStorage(t, store, mapreduce.AnsName("lines")), expected)
+
+	// Everything went to the first reduce task, compressed, and was combined
+	if data := mustRead(t, store, mapreduce.ReduceName("lines", 0, 0)); !bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		t.Errorf("intermediate file not compressed")
+	}
+	r, err := gzip.NewReader(bytes.NewReader(mustRead(t, store, mapreduce.ReduceName("lines", 0, 1))))
+	checkErrFatal(t, err, "cannot decompress intermediate file: %v", err)
+	if data, _ := io.ReadAll(r); len(data) > 0 {
+		t.Errorf("keys in the second partition: %q", data)
+	}
+	if in, out := counters.Get(mapreduce.CounterCombineInputRecords), counters.Get(mapreduce.CounterCombineOutputRecords); in != 8 || out != 5 {
+		t.Errorf("combiner read %d and wrote %d records, want 8 and 5", in, out)
+	}
+	if csv := mustRead(t, store, mapreduce.ExportName("lines", "csv")); !strings.Contains(string(csv), "foo,3") {
+		t.Errorf("unexpected export %q", csv)
+	}
+}
+
+func TestJobSpecLimits(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in/a.txt", []byte("foo bar"))
+	store.WriteFile("in/b.txt", []byte("foo"))
+	m := mapreduce.NewMaster(mapreduce.WithStorage(store))
+
+	// The job fails once a task failed as many times as allowed
+	err := m.StartJob(mapreduce.JobSpec{Name: "retries", Inputs: []string{"in/a.txt"}, NReduce: 1, MaxAttempts: 2})
+	checkErrFatal(t, err, "cannot start job: %v", err)
+	for range 2 {
+		task := getTask(t, m, "w1").Task
+		if task.Settings != (mapreduce.TaskSettings{}) || task.JobName != "retries" {
+			t.Fatalf("unexpected task %+v", task)
+		}
+		err := m.ReportTaskFailed(&mapreduce.ReportArgs{JobName: "retries", TaskID: task.TaskID, WorkerID: "w1", Error: "boom"}, &struct{}{})
+		checkErrFatal(t, err, "ReportTaskFailed failed: %v", err)
+	}
+	status, err := m.JobStatus("retries")
+	checkErrFatal(t, err, "no job status: %v", err)
+	if status.Status != mapreduce.JobFailed || !strings.Contains(status.Error, "failed 2 times") {
+		t.Errorf("status of the job: %+v", status)
+	}
+
+	// Or once a counter goes over its limit
+	err = m.StartJob(mapreduce.JobSpec{Name: "limits", Inputs: []string{"in/b.txt"}, NReduce: 1,
+		InputFormat: mapreduce.InputLines, Counters: map[string]int64{"map.malformed": 0}})
+	checkErrFatal(t, err, "cannot start job: %v", err)
+	task := getTask(t, m, "w1").Task
+	if task.Settings.InputFormat != mapreduce.InputLines {
+		t.Errorf("settings not sent with the task: %+v", task.Settings)
+	}
+	err = m.ReportTaskDone(&mapreduce.ReportArgs{JobName: "limits", TaskID: task.TaskID, WorkerID: "w1",
+		Counters: map[string]int64{"map.malformed": 1}}, &struct{}{})
+	checkErrFatal(t, err, "ReportTaskDone failed: %v", err)
+	status, err = m.JobStatus("limits")
+	checkErrFatal(t, err, "no job status: %v", err)
+	if status.Status != mapreduce.JobFailed || !strings.Contains(status.Error, "map.malformed") {
+		t.Errorf("status of the job: %+v", status)
+	}
+	if reply := getTask(t, m, "w1"); reply.Task.JobName == "limits" {
+		t.Errorf("task of a failed job handed out: %+v", reply.Task)
+	}
+}
+
+func TestJobSpecLimitsSequential(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in/a.txt", []byte("foo 1\nbar 2\nfoo 3\n"))
+
+	// A job with a counter over its limit fails instead of merging its results
+	spec := mapreduce.JobSpec{Name: "seqlimits", Inputs: []string{"in"}, NReduce: 1, App: "spec-lines", InputFormat: mapreduce.InputLines,
+		Counters: map[string]int64{mapreduce.CounterMapInputRecords: 2}}
+	err := spec.Run(mapreduce.SequentialRunner, mapreduce.WithStorage(store))
+	if err == nil || !strings.Contains(err.Error(), mapreduce.CounterMapInputRecords) {
+		t.Errorf("job over its limits: %v", err)
+	}
+	if _, err := store.ReadFile(mapreduce.AnsName("seqlimits")); err == nil {
+		t.Errorf("job over its limits has a result")
+	}
+
+	spec.Counters[mapreduce.CounterMapInputRecords] = 3
+	err = spec.Run(mapreduce.SequentialRunner, mapreduce.WithStorage(store))
+	checkErrFatal(t, err, "job within its limits failed: %v", err)
+}
@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"mr/mapreduce"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type sseEvent struct {
+	name string
+	data string
+}
+
+// readEvents parses the Server-Sent Events of a stream into events
+func readEvents(r *bufio.Reader, events chan<- sseEvent) {
+	defer close(events)
+	var e sseEvent
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			e.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			e.data = strings.TrimPrefix(line, "data: ")
+		case line == "":
+			events <- e
+			e = sseEvent{}
+		}
+	}
+}
+
+func nextEvent(t *testing.T, events <-chan sseEvent, name string) sseEvent {
+	t.Helper()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				t.Fatalf("stream closed while waiting for a %s event", name)
+			}
+			if e.name == name {
+				return e
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no %s event", name)
+		}
+	}
+}
+
+func TestDashboardEvents(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in-0", []byte("a b"))
+
+	m := mapreduce.NewMaster()
+	err := m.Submit("streamed", []string{"in-0"}, 1, mapreduce.WithStorage(store))
+	checkErrFatal(t, err, "submit failed: %v", err)
+
+	srv := httptest.NewServer(m.Handler())
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/events", nil)
+	resp, err := http.DefaultClient.Do(req)
+	checkErrFatal(t, err, "cannot follow events: %v", err)
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	events := make(chan sseEvent, 100)
+	go readEvents(bufio.NewReader(resp.Body), events)
+
+	var snapshot mapreduce.DashboardData
+	json.Unmarshal([]byte(nextEvent(t, events, "snapshot").data), &snapshot)
+	if len(snapshot.Tasks) != 2 || snapshot.Progress != 0 {
+		t.Errorf("unexpected snapshot: %+v", snapshot)
+	}
+
+	// Only the task that changed is sent
+	runTask(t, m, "w1", map[string]int64{"x": 1})
+	var task mapreduce.Task
+	json.Unmarshal([]byte(nextEvent(t, events, "task").data), &task)
+	if task.Type != "map" || task.Status != "in-progress" || task.Worker != "w1" {
+		t.Errorf("unexpected task event: %+v", task)
+	}
+	json.Unmarshal([]byte(nextEvent(t, events, "task").data), &task)
+	if task.Status != "completed" {
+		t.Errorf("unexpected task event: %+v", task)
+	}
+	var progress struct{ Progress float64 }
+	json.Unmarshal([]byte(nextEvent(t, events, "progress").data), &progress)
+	if progress.Progress != 50 {
+		t.Errorf("progress = %v, want 50", progress.Progress)
+	}
+	var counters struct {
+		Job      string
+		Counters map[string]int64
+	}
+	json.Unmarshal([]byte(nextEvent(t, events, "counters").data), &counters)
+	if counters.Job != "streamed" || counters.Counters["x"] != 1 {
+		t.Errorf("unexpected counters event: %+v", counters)
+	}
+	var worker mapreduce.WorkerInfo
+	json.Unmarshal([]byte(nextEvent(t, events, "worker").data), &worker)
+	if worker.Name != "w1" || worker.Status != "Idle" {
+		t.Errorf("unexpected worker event: %+v", worker)
+	}
+}
@@ -0,0 +1,270 @@
+package tests
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/fs"
+	"mr/mapreduce"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// decodeMapFromStorage reads a JSON encoded key/value file from a storage
+func decodeMapFromStorage(t *testing.T, store mapreduce.Storage, name string) map[string]string {
+	t.Helper()
+	r, err := store.Open(name)
+	checkErrFatal(t, err, "cannot open %s: %v", name, err)
+	defer r.Close()
+
+	kvs := make(map[string]string)
+	decoder := json.NewDecoder(r)
+	var kv mapreduce.KeyValue
+	for decoder.Decode(&kv) == nil {
+		kvs[kv.Key] = kv.Value
+	}
+	return kvs
+}
+
+// exerciseStorage runs the same checks against any Storage backend
+func exerciseStorage(t *testing.T, store mapreduce.Storage) {
+	w, err := store.Create("dir/a.txt")
+	checkErrFatal(t, err, "create: %v", err)
+	io.WriteString(w, "hello")
+	checkErrFatal(t, w.Close(), "close failed")
+
+	r, err := store.Open("dir/a.txt")
+	checkErrFatal(t, err, "open: %v", err)
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != "hello" {
+		t.Errorf("got %q, want %q", data, "hello")
+	}
+
+	err = store.Rename("dir/a.txt", "dir/b.txt")
+	checkErrFatal(t, err, "rename: %v", err)
+	if _, err := store.Open("dir/a.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("open after rename: got %v, want ErrNotExist", err)
+	}
+
+	w, _ = store.Create("dir/sub/c.txt")
+	w.Close()
+	w, _ = store.Create("other.txt")
+	w.Close()
+
+	names, err := store.List("dir/")
+	checkErrFatal(t, err, "list: %v", err)
+	if want := []string{"dir/b.txt", "dir/sub/c.txt"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("List got %v, want %v", names, want)
+	}
+
+	for _, name := range []string{"dir/b.txt", "dir/sub/c.txt", "other.txt"} {
+		checkErrFatal(t, store.Remove(name), "remove %s failed", name)
+	}
+	if err := store.Remove("other.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("remove of a missing file: got %v, want ErrNotExist", err)
+	}
+	names, _ = store.List("")
+	if len(names) != 0 {
+		t.Errorf("List after remove got %v, want nothing", names)
+	}
+}
+
+func TestLocalStorage(t *testing.T) {
+	exerciseStorage(t, mapreduce.LocalStorage{Dir: t.TempDir()})
+}
+
+func TestMemStorage(t *testing.T) {
+	exerciseStorage(t, mapreduce.NewMemStorage())
+}
+
+func TestS3Storage(t *testing.T) {
+	server := httptest.NewServer(newFakeS3(t, "bucket"))
+	defer server.Close()
+
+	exerciseStorage(t, &mapreduce.S3Storage{
+		Endpoint:  server.URL,
+		Bucket:    "bucket",
+		AccessKey: "test",
+		SecretKey: "secret",
+		Prefix:    "jobs/",
+	})
+}
+
+func TestSequentialMemStorage(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in-0", []byte("foo bar foo"))
+	store.WriteFile("in-1", []byte("baz foo bar"))
+
+	mapreduce.Sequential("memjob", []string{"in-0", "in-1"}, 3, mapF, reduceF,
+		mapreduce.WithStorage(store))
+
+	expected := map[string]string{"foo": "3", "bar": "2", "baz": "1"}
+	assertEqualMaps(t, decodeMapFromStorage(t, store, "mrtmp.memjob"), expected)
+
+	// Nothing must have been written on the local disk
+	if _, err := os.Stat("mrtmp.memjob"); err == nil {
+		t.Errorf("Sequential with a MemStorage wrote to the local disk")
+	}
+
+	mapreduce.CleanIntermediary("memjob", 2, 3, mapreduce.WithStorage(store))
+	names, _ := store.List("mrtmp.memjob-")
+	if len(names) != 0 {
+		t.Errorf("intermediate files left behind: %v", names)
+	}
+}
+
+// brokenStorage fails to publish files, as a storage that is down would.
+type brokenStorage struct {
+	*mapreduce.MemStorage
+}
+
+func (brokenStorage) Rename(oldName, newName string) error {
+	return errors.New("storage unavailable")
+}
+
+func TestStorageFailure(t *testing.T) {
+	store := brokenStorage{mapreduce.NewMemStorage()}
+	store.WriteFile("in", []byte("foo bar"))
+
+	// Tasks return the errors of the storage instead of exiting the process
+	err := mapreduce.DoMap("broken", 0, "in", 2, mapF, mapreduce.WithStorage(store))
+	if err == nil || !strings.Contains(err.Error(), "storage unavailable") {
+		t.Errorf("map task on a broken storage: %v", err)
+	}
+	store.WriteFile(mapreduce.ReduceName("broken", 0, 0), nil)
+	err = mapreduce.DoReduce("broken", 0, 1, reduceF, mapreduce.WithStorage(store))
+	if err == nil || !strings.Contains(err.Error(), "storage unavailable") {
+		t.Errorf("reduce task on a broken storage: %v", err)
+	}
+
+	// Workers report them as failures of the tasks
+	err = mapreduce.LocalParallel(1)("broken", []string{"in"}, 2, mapF, reduceF,
+		mapreduce.WithStorage(store), mapreduce.WithMaxAttempts(2))
+	if err == nil || !strings.Contains(err.Error(), "failed 2 times") {
+		t.Errorf("job on a broken storage: %v", err)
+	}
+}
+
+// lossyStorage cannot read one of its files back.
+type lossyStorage struct {
+	*mapreduce.MemStorage
+	lost string
+}
+
+func (s lossyStorage) Open(name string) (io.ReadCloser, error) {
+	if name == s.lost {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return s.MemStorage.Open(name)
+}
+
+func TestMergeFailure(t *testing.T) {
+	store := lossyStorage{mapreduce.NewMemStorage(), mapreduce.MergeName("lossy", 1)}
+	store.WriteFile("in", []byte("foo bar baz"))
+
+	// A result that cannot be merged is not published, even partly
+	err := mapreduce.Sequential("lossy", []string{"in"}, 2, mapF, reduceF, mapreduce.WithStorage(store))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("job with a lost result part: %v", err)
+	}
+	if names, _ := store.List(mapreduce.AnsName("lossy") + "."); len(names) > 0 {
+		t.Errorf("partial result left: %v", names)
+	}
+	if _, err := store.ReadFile(mapreduce.AnsName("lossy")); err == nil {
+		t.Errorf("partial result published")
+	}
+}
+
+// fakeS3 is a minimal in-memory stand-in for an S3 server supporting the
+// requests made by S3Storage.
+type fakeS3 struct {
+	t       *testing.T
+	bucket  string
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func newFakeS3(t *testing.T, bucket string) *fakeS3 {
+	return &fakeS3{t: t, bucket: bucket, objects: make(map[string][]byte)}
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	sum := sha256.Sum256(body)
+	if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=test/") ||
+		r.Header.Get("x-amz-content-sha256") != hex.EncodeToString(sum[:]) {
+		http.Error(w, "AccessDenied", http.StatusForbidden)
+		return
+	}
+
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key, _ := strings.Cut(path, "/")
+	if bucket != s.bucket {
+		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch {
+	case r.Method == "GET" && key == "":
+		s.list(w, r.URL.Query().Get("prefix"))
+	case r.Method == "GET":
+		data, ok := s.objects[key]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	case r.Method == "PUT" && r.Header.Get("x-amz-copy-source") != "":
+		src, _ := url.PathUnescape(r.Header.Get("x-amz-copy-source"))
+		data, ok := s.objects[strings.TrimPrefix(src, "/"+s.bucket+"/")]
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		s.objects[key] = data
+	case r.Method == "PUT":
+		s.objects[key] = body
+	case r.Method == "DELETE":
+		if _, ok := s.objects[key]; !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		delete(s.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "NotImplemented", http.StatusNotImplemented)
+	}
+}
+
+func (s *fakeS3) list(w http.ResponseWriter, prefix string) {
+	type content struct{ Key string }
+	var result struct {
+		XMLName  xml.Name `xml:"ListBucketResult"`
+		Contents []content
+	}
+	var keys []string
+	for k := range s.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		result.Contents = append(result.Contents, content{Key: k})
+	}
+	xml.NewEncoder(w).Encode(result)
+}
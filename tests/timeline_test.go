@@ -0,0 +1,62 @@
+package tests
+
+import (
+	"encoding/json"
+	"mr/mapreduce"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTaskAttempts(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in-0", []byte("a b"))
+
+	m := mapreduce.NewMaster()
+	err := m.Submit("timed", []string{"in-0"}, 1, mapreduce.WithStorage(store))
+	checkErrFatal(t, err, "submit failed: %v", err)
+
+	var reply mapreduce.TaskReply
+	err = m.GetTask(&mapreduce.TaskArgs{WorkerID: "w1"}, &reply)
+	checkErrFatal(t, err, "GetTask failed: %v", err)
+	err = m.ReportTaskFailed(&mapreduce.ReportArgs{JobName: "timed", TaskID: reply.Task.TaskID,
+		WorkerID: "w1", Error: "boom"}, &struct{}{})
+	checkErrFatal(t, err, "ReportTaskFailed failed: %v", err)
+	runTask(t, m, "w2", nil)
+	err = m.GetTask(&mapreduce.TaskArgs{WorkerID: "w1"}, &reply) // the reduce task
+	checkErrFatal(t, err, "GetTask failed: %v", err)
+
+	srv := httptest.NewServer(m.Handler())
+	defer srv.Close()
+	var data mapreduce.DashboardData
+	err = json.Unmarshal([]byte(scrape(t, srv.URL+"/data")), &data)
+	checkErrFatal(t, err, "cannot decode data: %v", err)
+
+	expected := []struct {
+		taskType, worker, status string
+		attempt                  int
+	}{
+		{"map", "w1", mapreduce.AttemptFailed, 1},
+		{"map", "w2", mapreduce.AttemptCompleted, 2},
+		{"reduce", "w1", mapreduce.AttemptRunning, 1},
+	}
+	if len(data.Attempts) != len(expected) {
+		t.Fatalf("got %d attempts, want %d: %+v", len(data.Attempts), len(expected), data.Attempts)
+	}
+	for i, want := range expected {
+		a := data.Attempts[i]
+		if a.Type != want.taskType || a.Worker != want.worker || a.Status != want.status || a.Attempt != want.attempt {
+			t.Errorf("attempt %d: got %+v, want %+v", i, a, want)
+		}
+		if a.Start.IsZero() || (a.Status == mapreduce.AttemptRunning) != a.End.IsZero() || (!a.End.IsZero() && a.End.Before(a.Start)) {
+			t.Errorf("attempt %d has wrong times: %v - %v", i, a.Start, a.End)
+		}
+	}
+	if data.Attempts[1].Start.Before(data.Attempts[0].End) {
+		t.Errorf("retry started before the failure")
+	}
+	for _, task := range data.Tasks {
+		if task.Status != "pending" && task.StartTime.IsZero() {
+			t.Errorf("task %d has no start time", task.TaskID)
+		}
+	}
+}
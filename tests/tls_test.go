@@ -0,0 +1,139 @@
+package tests
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"mr/mapreduce"
+	"net"
+	"net/http"
+	"net/rpc"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert creates a certificate signed by parent (self-signed when nil)
+// and writes it and its key as PEM files in dir
+func writeCert(t *testing.T, dir, name string, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	checkErrFatal(t, err, "cannot generate key: %v", err)
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	checkErrFatal(t, err, "cannot create certificate: %v", err)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	checkErrFatal(t, err, "cannot marshal key: %v", err)
+	os.WriteFile(filepath.Join(dir, name+".pem"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(filepath.Join(dir, name+"-key.pem"), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600)
+	cert, err := x509.ParseCertificate(der)
+	checkErrFatal(t, err, "cannot parse certificate: %v", err)
+	return cert, key
+}
+
+// testTLSConfigs returns the configurations of a master and of a client
+// holding certificates signed by the same test CA
+func testTLSConfigs(t *testing.T) (server, client *tls.Config) {
+	t.Helper()
+	dir := t.TempDir()
+	validity := func(serial int64, cn string) *x509.Certificate {
+		return &x509.Certificate{
+			SerialNumber: big.NewInt(serial),
+			Subject:      pkix.Name{CommonName: cn},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+	}
+	caTemplate := validity(1, "test CA")
+	caTemplate.IsCA = true
+	caTemplate.BasicConstraintsValid = true
+	caTemplate.KeyUsage = x509.KeyUsageCertSign
+	ca, caKey := writeCert(t, dir, "ca", caTemplate, nil, nil)
+
+	serverTemplate := validity(2, "master")
+	serverTemplate.IPAddresses = []net.IP{net.ParseIP("127.0.0.1")}
+	serverTemplate.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	writeCert(t, dir, "master", serverTemplate, ca, caKey)
+
+	clientTemplate := validity(3, "worker")
+	clientTemplate.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	writeCert(t, dir, "worker", clientTemplate, ca, caKey)
+
+	ca1 := filepath.Join(dir, "ca.pem")
+	server, err := mapreduce.LoadTLSConfig(filepath.Join(dir, "master.pem"), filepath.Join(dir, "master-key.pem"), ca1)
+	checkErrFatal(t, err, "cannot load master TLS config: %v", err)
+	client, err = mapreduce.LoadTLSConfig(filepath.Join(dir, "worker.pem"), filepath.Join(dir, "worker-key.pem"), ca1)
+	checkErrFatal(t, err, "cannot load worker TLS config: %v", err)
+	return server, client
+}
+
+func TestMutualTLS(t *testing.T) {
+	serverTLS, clientTLS := testTLSConfigs(t)
+	store := mapreduce.NewMemStorage()
+	store.WriteFile("in", []byte("a"))
+
+	m := mapreduce.NewMaster(mapreduce.WithRPCAddr("127.0.0.1:0"), mapreduce.WithDashboardAddr("127.0.0.1:0"),
+		mapreduce.WithTLS(serverTLS))
+	checkErrFatal(t, m.Serve(), "cannot serve")
+	defer m.Close()
+	err := m.Submit("secure", []string{"in"}, 1, mapreduce.WithStorage(store))
+	checkErrFatal(t, err, "submit failed: %v", err)
+
+	// A second master runs alongside on other free ports
+	other := mapreduce.NewMaster(mapreduce.WithRPCAddr("127.0.0.1:0"), mapreduce.WithDashboardAddr("127.0.0.1:0"))
+	checkErrFatal(t, other.Serve(), "cannot serve a second master")
+	defer other.Close()
+	if other.RPCAddr() == m.RPCAddr() || other.DashboardAddr() == m.DashboardAddr() {
+		t.Errorf("masters share addresses: %s %s", other.RPCAddr(), other.DashboardAddr())
+	}
+
+	// RPC with a client certificate
+	conn, err := tls.Dial("tcp", m.RPCAddr(), clientTLS)
+	checkErrFatal(t, err, "cannot connect with TLS: %v", err)
+	client := rpc.NewClient(conn)
+	defer client.Close()
+	var reply mapreduce.TaskReply
+	err = client.Call("Master.GetTask", &mapreduce.TaskArgs{WorkerID: "w1"}, &reply)
+	checkErrFatal(t, err, "GetTask over TLS failed: %v", err)
+	if !reply.Available || reply.Task.JobName != "secure" {
+		t.Errorf("unexpected reply: %+v", reply)
+	}
+
+	// RPC without a client certificate
+	noCert := &tls.Config{RootCAs: clientTLS.RootCAs}
+	if conn, err := tls.Dial("tcp", m.RPCAddr(), noCert); err == nil {
+		client := rpc.NewClient(conn)
+		err = client.Call("Master.GetTask", &mapreduce.TaskArgs{WorkerID: "w2"}, &reply)
+		client.Close()
+		if err == nil {
+			t.Errorf("RPC accepted without a client certificate")
+		}
+	}
+
+	// Dashboard
+	for _, tc := range []struct {
+		name string
+		cfg  *tls.Config
+		ok   bool
+	}{
+		{"client certificate", clientTLS, true},
+		{"no client certificate", noCert, false},
+	} {
+		httpClient := &http.Client{Transport: &http.Transport{TLSClientConfig: tc.cfg}}
+		resp, err := httpClient.Get("https://" + m.DashboardAddr() + "/data")
+		if err == nil {
+			resp.Body.Close()
+		}
+		if ok := err == nil && resp.StatusCode == http.StatusOK; ok != tc.ok {
+			t.Errorf("dashboard with %s: got error %v", tc.name, err)
+		}
+	}
+}
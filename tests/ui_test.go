@@ -0,0 +1,44 @@
+package tests
+
+import (
+	"mr/mapreduce"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// The pages are embedded: they are served even though the tests do not run
+// from the repository root, and load nothing from the network.
+func TestEmbeddedUI(t *testing.T) {
+	store := mapreduce.NewMemStorage()
+	master := httptest.NewServer(mapreduce.NewMaster().Handler())
+	defer master.Close()
+	history := httptest.NewServer(mapreduce.HistoryHandler(store, mapreduce.DefaultHistoryDir))
+	defer history.Close()
+
+	for _, tc := range []struct {
+		url, contentType, contains string
+	}{
+		{master.URL + "/", "text/html", "MapReduce Dashboard"},
+		{master.URL + "/static/style.css", "text/css", ".bg-gray-800"},
+		{master.URL + "/static/dashboard.js", "text/javascript", "EventSource"},
+		{history.URL + "/", "text/html", "MapReduce Job History"},
+		{history.URL + "/static/history.js", "text/javascript", "/jobs"},
+	} {
+		resp, err := http.Get(tc.url)
+		checkErrFatal(t, err, "cannot get %s: %v", tc.url, err)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), tc.contentType) {
+			t.Errorf("%s: status %d, Content-Type %q", tc.url, resp.StatusCode, resp.Header.Get("Content-Type"))
+			continue
+		}
+		body := scrape(t, tc.url)
+		if !strings.Contains(body, tc.contains) {
+			t.Errorf("%s does not contain %q", tc.url, tc.contains)
+		}
+		if strings.Contains(body, "http://") || strings.Contains(body, "https://") {
+			t.Errorf("%s loads remote resources", tc.url)
+		}
+	}
+}